@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/certs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/loadtest"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/migration"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// commands contains the subcommands of the shortener, a subcommand is run
+// instead of the server: shortener <command> [args] [flags], the args
+// are the words following the command up to the first flag.
+var commands = map[string]func(args []string) error{
+	"audit-verify":    verifyAuditTrail,
+	"config":          configCommand,
+	"gen-cert":        generateCertificate,
+	"load-test":       loadTest,
+	"migrate-storage": migrateStorage,
+}
+
+// commandFlags registers the flags of the commands having their own flags
+// on flag.CommandLine, they are parsed together with the flags of the config.
+var commandFlags = map[string]func(fs *flag.FlagSet){
+	"load-test":       loadTestFlags,
+	"migrate-storage": migrateStorageFlags,
+}
+
+// The flags of migrate-storage.
+var migrateFlags struct {
+	from, to       string
+	dryRun, resume bool
+	batchSize      int
+}
+
+func migrateStorageFlags(fs *flag.FlagSet) {
+	fs.StringVar(&migrateFlags.from, "from", "", "the storage url to copy the links from, like file:///path")
+	fs.StringVar(&migrateFlags.to, "to", "", "the storage url to copy the links to, like postgres://host/db")
+	fs.BoolVar(&migrateFlags.dryRun, "dry-run", false, "report what would be copied without writing")
+	fs.BoolVar(&migrateFlags.resume, "resume", false, "continue an interrupted migration into a non-empty storage")
+	fs.IntVar(&migrateFlags.batchSize, "batch-size", 500, "the number of links written in a single transaction")
+}
+
+// Copies the links between the storages given by the urls
+// and verifies the counts and the checksums afterwards.
+func migrateStorage(args []string) error {
+	if migrateFlags.from == "" || migrateFlags.to == "" {
+		return fmt.Errorf("usage: shortener migrate-storage --from <url> --to <url> [--dry-run] [--resume] [--batch-size n]")
+	}
+
+	from, err := storage.OpenRecordStore(migrateFlags.from, utils.Logger)
+	if err != nil {
+		return fmt.Errorf("open the source: %w", err)
+	}
+	defer from.Close()
+
+	to, err := storage.OpenRecordStore(migrateFlags.to, utils.Logger)
+	if err != nil {
+		return fmt.Errorf("open the target: %w", err)
+	}
+	defer to.Close()
+
+	report, err := migration.Run(context.Background(), from, to, migration.Options{
+		DryRun:    migrateFlags.dryRun,
+		Resume:    migrateFlags.resume,
+		BatchSize: migrateFlags.batchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	if migrateFlags.dryRun {
+		fmt.Printf("dry run: %d links would be copied, %d are already there, %d in the source with checksum %s\n",
+			report.Copied, report.Skipped, report.Source, report.Checksum)
+		return nil
+	}
+	fmt.Printf("%d links copied, %d were already there, %d links with checksum %s verified\n",
+		report.Copied, report.Skipped, report.Source, report.Checksum)
+	return nil
+}
+
+// The flags of load-test.
+var loadFlags struct {
+	url         string
+	links       int
+	concurrency int
+	duration    time.Duration
+}
+
+func loadTestFlags(fs *flag.FlagSet) {
+	fs.StringVar(&loadFlags.url, "url", "http://localhost:8080", "the address of the running shortener")
+	fs.IntVar(&loadFlags.links, "links", 1000, "the number of the links created and redirected at random")
+	fs.IntVar(&loadFlags.concurrency, "concurrency", 50, "the number of the clients sending the redirects at once")
+	fs.DurationVar(&loadFlags.duration, "duration", 10*time.Second, "how long the redirects are sent")
+}
+
+// Sends the redirects to the running shortener and prints the throughput and the latencies.
+func loadTest(args []string) error {
+	fmt.Printf("creating %d links at %s\n", loadFlags.links, loadFlags.url)
+	report, err := loadtest.Run(context.Background(), loadtest.Options{
+		BaseURL:     loadFlags.url,
+		Links:       loadFlags.links,
+		Concurrency: loadFlags.concurrency,
+		Duration:    loadFlags.duration,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d redirects in %v by %d clients: %.0f per second, %d failed\n",
+		report.Requests, report.Duration.Round(time.Millisecond), loadFlags.concurrency, report.RPS(), report.Errors)
+	fmt.Printf("latency p50 %v, p90 %v, p99 %v, max %v\n", report.P50, report.P90, report.P99, report.Max)
+	return nil
+}
+
+// Checks the hash chain of the audit trail of the configured storage.
+func verifyAuditTrail(args []string) error {
+	st, err := storage.NewStorage(utils.Logger)
+	if err != nil {
+		return err
+	}
+	if err := st.VerifyAuditTrail(context.Background()); err != nil {
+		return err
+	}
+
+	fmt.Println("audit trail is intact")
+	return nil
+}
+
+// Runs shortener config print, which writes the effective config
+// with the secrets redacted.
+func configCommand(args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return fmt.Errorf("usage: shortener config print [flags]")
+	}
+	return configs.Get().Print(os.Stdout)
+}
+
+// Writes a self-signed certificate for the hosts given as the args,
+// localhost by default, to the configured files or cert.pem and key.pem.
+func generateCertificate(args []string) error {
+	hosts := args
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	certFile, keyFile := configs.Get().TLSCertFile, configs.Get().TLSKeyFile
+	if certFile == "" {
+		certFile, keyFile = "cert.pem", "key.pem"
+	}
+
+	if err := certs.WriteSelfSigned(certFile, keyFile, hosts, 365*24*time.Hour); err != nil {
+		return err
+	}
+
+	fmt.Printf("certificate for %v is written to %s and its key to %s\n", hosts, certFile, keyFile)
+	return nil
+}
+
+// Splits the args following the command into the args of the command and the flags.
+func splitCommandArgs(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if len(arg) > 1 && arg[0] == '-' {
+			return args[:i], args[i:]
+		}
+	}
+	return args, nil
+}
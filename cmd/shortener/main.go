@@ -1,19 +1,146 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"net"
 	"net/http"
+	"os"
 
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/certs"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/grpcapi"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/handlers"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/tracing"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		name := os.Args[1]
+		if command, ok := commands[name]; ok {
+			args, flags := splitCommandArgs(os.Args[2:])
+			os.Args = append([]string{os.Args[0]}, flags...)
+			if register, ok := commandFlags[name]; ok {
+				register(flag.CommandLine)
+			}
+			configs.SetConfig()
+			if err := command(args); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	configs.SetConfig()
-	utils.LoggerInit()
-	urlStorage := storage.NewStorage()
-	handler := handlers.NewRouter(urlStorage)
-	log.Fatal(http.ListenAndServe(configs.Cfg.ServerAddress, handler))
+	if err := utils.LoggerInit(); err != nil {
+		log.Fatal(err)
+	}
+	logger := utils.Logger
+
+	if err := tracing.Init(context.Background()); err != nil {
+		logger.Fatal().Err(err).Msg("cannot init tracing")
+	}
+	urlStorage, err := storage.NewStorage(logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("cannot open the storage")
+	}
+	go urlStorage.Blocklist().Watch(configs.Get().BlocklistReloadInterval, logger)
+	go configs.Watch(func(changes []configs.Change, err error) {
+		if err != nil {
+			logger.Error().Err(err).Msg("config is not reloaded")
+			return
+		}
+		logConfigChanges(logger, changes)
+	})
+	limiterStore, err := ratelimit.NewStore()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("cannot create the rate limiter store")
+	}
+	handler := handlers.NewRouter(urlStorage,
+		handlers.WithRateLimitStore(limiterStore),
+		handlers.WithLogger(logger),
+	)
+	if configs.Get().MetricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			err := http.ListenAndServe(configs.Get().MetricsAddress, mux)
+			logger.Fatal().Err(err).Msg("metrics server stopped")
+		}()
+	}
+	cfg := configs.Get()
+	var reloader *certs.Reloader
+	if cfg.TLSEnabled() {
+		reloader, err = certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("cannot load the certificate")
+		}
+		go reloader.Watch(cfg.TLSReloadInterval, logger)
+	}
+	if cfg.GRPCAddress != "" {
+		go serveGRPC(urlStorage, limiterStore, logger, reloader)
+	}
+
+	if reloader == nil {
+		err = http.ListenAndServe(cfg.ServerAddress, handler)
+		logger.Fatal().Err(err).Msg("server stopped")
+	}
+	if cfg.TLSRedirectAddress != "" {
+		go func() {
+			err := http.ListenAndServe(cfg.TLSRedirectAddress, handlers.RedirectToHTTPSHandler(cfg.ServerAddress))
+			logger.Fatal().Err(err).Msg("redirect server stopped")
+		}()
+	}
+	server := &http.Server{
+		Addr:      cfg.ServerAddress,
+		Handler:   handler,
+		TLSConfig: reloader.TLSConfig(),
+	}
+	err = server.ListenAndServeTLS("", "")
+	logger.Fatal().Err(err).Msg("server stopped")
+}
+
+// Serves the grpc api over tls with the certificate of the reloader if it is not nil.
+func serveGRPC(urlStorage storage.ShortURLRepo, limiter ratelimit.Store, logger zerolog.Logger, reloader *certs.Reloader) {
+	var opts []grpc.ServerOption
+	if reloader != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+	}
+
+	listener, err := net.Listen("tcp", configs.Get().GRPCAddress)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("cannot listen the grpc address")
+	}
+	err = grpcapi.NewGRPCServer(urlStorage, limiter, logger, opts...).Serve(listener)
+	logger.Fatal().Err(err).Msg("grpc server stopped")
+}
+
+// Logs the changed settings, the settings requiring a restart separately,
+// and applies the reloaded log level.
+func logConfigChanges(logger zerolog.Logger, changes []configs.Change) {
+	var applied, restart []string
+	for _, change := range changes {
+		if change.Applied {
+			applied = append(applied, change.String())
+		} else {
+			restart = append(restart, change.String())
+		}
+	}
+	logger.Info().
+		Strs("changed", applied).
+		Strs("requires_restart", restart).
+		Msg("config reloaded")
+
+	if err := utils.ApplyLogLevel(); err != nil {
+		logger.Error().Err(err).Msg("cannot apply the log level")
+	}
 }
@@ -0,0 +1,243 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Entry describes a single action written to the audit trail.
+// Before and After contain the link before and after a mutation.
+// Every entry is chained to the previous one by PrevHash,
+// Hash is the sha256 of the entry with an empty Hash.
+type Entry struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Actor     uint32            `json:"actor"`
+	RequestID string            `json:"request_id,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	Action    string            `json:"action"`
+	ShortURL  string            `json:"short_url,omitempty"`
+	Before    json.RawMessage   `json:"before,omitempty"`
+	After     json.RawMessage   `json:"after,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// Filter contains the search criteria over the audit trail,
+// empty fields are not taken into account.
+type Filter struct {
+	Actor    *uint32
+	Action   string
+	ShortURL string
+	Limit    int
+}
+
+// Recorder writes entries to the audit trail, finds them
+// and verifies that the trail has not been tampered with.
+type Recorder interface {
+	Record(ctx context.Context, entry Entry) error
+	Find(ctx context.Context, filter Filter) ([]Entry, error)
+	Verify(ctx context.Context) error
+}
+
+// Actor describes who performs a mutation.
+type Actor struct {
+	UserID    uint32
+	RequestID string
+	ClientIP  string
+}
+
+type contextKey int
+
+const contextKeyActor contextKey = iota
+
+// Returns a copy of ctx carrying the actor.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, contextKeyActor, actor)
+}
+
+// Returns the actor from ctx or an empty actor.
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(contextKeyActor).(Actor)
+	return actor
+}
+
+// Returns an entry for the action performed by the actor from ctx.
+func NewEntry(ctx context.Context, action, shortURL string) Entry {
+	actor := ActorFromContext(ctx)
+	return Entry{
+		Actor:     actor.UserID,
+		RequestID: actor.RequestID,
+		ClientIP:  actor.ClientIP,
+		Action:    action,
+		ShortURL:  shortURL,
+	}
+}
+
+// Returns a Recorder writing to Postgres if the pool is not nil,
+// otherwise to the file from config. For the SQLite, bolt and file storages
+// the trail is written next to the storage file if the audit log path is empty.
+// Without both paths the trail is kept in memory.
+func NewRecorder(pool *pgxpool.Pool) (Recorder, error) {
+	if pool != nil {
+		return NewDBRecorder(pool)
+	}
+
+	cfg := configs.Get()
+	if cfg.AuditLogPath != "" {
+		return NewFileRecorder(cfg.AuditLogPath), nil
+	}
+
+	if path, ok := cfg.SQLitePath(); ok {
+		return NewFileRecorder(path + ".audit"), nil
+	}
+
+	if cfg.BoltPath != "" {
+		return NewFileRecorder(cfg.BoltPath + ".audit"), nil
+	}
+
+	if cfg.FileStoragePath != "" {
+		return NewFileRecorder(cfg.FileStoragePath + ".audit"), nil
+	}
+
+	return NewInMemoryRecorder(), nil
+}
+
+// Match reports whether the entry satisfies the filter.
+func (f Filter) Match(e Entry) bool {
+	if f.Actor != nil && *f.Actor != e.Actor {
+		return false
+	}
+	if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+	if f.ShortURL != "" && f.ShortURL != e.ShortURL {
+		return false
+	}
+	return true
+}
+
+// Seals the entry: sets its sequence number, time and hashes
+// chaining it to the previous entry.
+func (e *Entry) seal(prev *Entry) error {
+	e.Seq = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	e.Time = time.Now().UTC().Truncate(time.Microsecond)
+
+	hash, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	return nil
+}
+
+// Returns the hex encoded sha256 of the entry with an empty Hash.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Checks the chain of entries sorted by Seq.
+func verifyChain(entries []Entry) error {
+	var prev *Entry
+	for i := range entries {
+		e := entries[i]
+		if prev == nil && (e.Seq != 1 || e.PrevHash != "") {
+			return utils.NewAuditTamperedError(e.Seq, "the trail does not start with the first entry")
+		}
+		if prev != nil && e.Seq != prev.Seq+1 {
+			return utils.NewAuditTamperedError(e.Seq, "the entry does not follow the previous one")
+		}
+		if prev != nil && e.PrevHash != prev.Hash {
+			return utils.NewAuditTamperedError(e.Seq, "the previous hash does not match")
+		}
+
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return utils.NewAuditTamperedError(e.Seq, "the hash does not match the content")
+		}
+		prev = &entries[i]
+	}
+	return nil
+}
+
+// Returns the last entries matching the filter, newest first.
+func findEntries(entries []Entry, filter Filter) []Entry {
+	var result []Entry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+		if filter.Match(entries[i]) {
+			result = append(result, entries[i])
+		}
+	}
+	return result
+}
+
+// InMemoryRecorder keeps the audit trail in memory.
+type InMemoryRecorder struct {
+	entries []Entry
+	m       sync.Mutex
+}
+
+// Returns a pointer to InMemoryRecorder.
+func NewInMemoryRecorder() *InMemoryRecorder {
+	return &InMemoryRecorder{}
+}
+
+// Record adds the entry to the in memory trail.
+func (r *InMemoryRecorder) Record(ctx context.Context, entry Entry) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var prev *Entry
+	if len(r.entries) > 0 {
+		prev = &r.entries[len(r.entries)-1]
+	}
+	if err := entry.seal(prev); err != nil {
+		return err
+	}
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+// Find returns the last entries matching the filter, newest first.
+func (r *InMemoryRecorder) Find(ctx context.Context, filter Filter) ([]Entry, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return findEntries(r.entries, filter), nil
+}
+
+// Verify checks the chain of the in memory trail.
+func (r *InMemoryRecorder) Verify(ctx context.Context) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return verifyChain(r.entries)
+}
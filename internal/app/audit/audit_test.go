@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+func TestFileRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	ctx := WithActor(context.Background(), Actor{
+		UserID:    7,
+		RequestID: "host/abc-000001",
+		ClientIP:  "10.0.0.1",
+	})
+
+	recorder := NewFileRecorder(path)
+	require.NoError(t, recorder.Record(ctx, NewEntry(ctx, "create", "abc")))
+	require.NoError(t, recorder.Record(ctx, NewEntry(ctx, "delete", "abc")))
+
+	// a new recorder continues the chain written by the previous one.
+	recorder = NewFileRecorder(path)
+	require.NoError(t, recorder.Record(ctx, NewEntry(ctx, "create", "xyz")))
+	require.NoError(t, recorder.Verify(ctx))
+
+	entries, err := recorder.Find(ctx, Filter{ShortURL: "abc"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "delete", entries[0].Action)
+	assert.Equal(t, uint64(2), entries[0].Seq)
+	assert.Equal(t, entries[1].Hash, entries[0].PrevHash)
+	assert.Equal(t, uint32(7), entries[0].Actor)
+	assert.Equal(t, "host/abc-000001", entries[0].RequestID)
+	assert.Equal(t, "10.0.0.1", entries[0].ClientIP)
+
+	entries, err = recorder.Find(ctx, Filter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "xyz", entries[0].ShortURL)
+}
+
+func TestFileRecorderVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		seq    uint64
+	}{
+		{
+			name: "edited entry",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"action":"delete"`, `"action":"create"`, 1)
+				return lines
+			},
+			seq: 2,
+		},
+		{
+			name: "removed entry",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			seq: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			recorder := NewFileRecorder(path)
+			for _, action := range []string{"create", "delete", "admin.reassign"} {
+				require.NoError(t, recorder.Record(context.Background(), NewEntry(context.Background(), action, "abc")))
+			}
+
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			data = []byte(strings.Join(tt.tamper(lines), "\n") + "\n")
+			require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+			err = NewFileRecorder(path).Verify(context.Background())
+			var tampered *utils.AuditTamperedError
+			require.True(t, errors.As(err, &tampered))
+			assert.Equal(t, tt.seq, tampered.Seq)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/dbpool"
+)
+
+// DBRecorder writes the audit trail to the append-only audit_log table,
+// updates and deletes of the table are rejected by a trigger.
+// Every query is cancelled after DATABASE_QUERY_TIMEOUT.
+type DBRecorder struct {
+	Postgres     *pgxpool.Pool
+	queryTimeout time.Duration
+}
+
+// CheckHealth pings the database over a connection of the pool.
+func (r *DBRecorder) CheckHealth(ctx context.Context) error {
+	return r.Postgres.Ping(ctx)
+}
+
+// Returns a pointer to DBRecorder and creates the audit_log table.
+func NewDBRecorder(pool *pgxpool.Pool) (*DBRecorder, error) {
+	recorder := &DBRecorder{
+		Postgres:     pool,
+		queryTimeout: configs.Get().DatabaseQueryTimeout,
+	}
+
+	if err := recorder.CreateTable(); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// Returns the context of a query cancelled after DATABASE_QUERY_TIMEOUT.
+func (dbr *DBRecorder) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return dbpool.QueryContext(ctx, dbr.queryTimeout)
+}
+
+func (dbr *DBRecorder) CreateTable() error {
+	ctx, cancel := dbr.queryContext(context.Background())
+	defer cancel()
+
+	conn, e := dbr.Postgres.Acquire(ctx)
+	if e != nil {
+		return e
+	}
+	defer conn.Release()
+
+	sqlCreateStmt := `
+	create table if not exists public.audit_log ( seq bigint constraint audit_log_pk primary key,
+	time timestamptz not null, actor bigint not null, request_id varchar(256), client_ip varchar(64),
+	action varchar(64) not null, short_link varchar(256), before text, after text, details text,
+	prev_hash varchar(64) not null, hash varchar(64) not null );
+
+	create or replace function audit_log_append_only() returns trigger as $$
+	begin
+		raise exception 'audit_log is append-only';
+	end;
+	$$ language plpgsql;
+
+	drop trigger if exists audit_log_append_only on public.audit_log;
+	create trigger audit_log_append_only before update or delete or truncate on public.audit_log
+	for each statement execute procedure audit_log_append_only();`
+
+	_, err := conn.Exec(ctx, sqlCreateStmt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Record writes the entry in a transaction of its own, see RecordTx.
+func (dbr *DBRecorder) Record(ctx context.Context, entry Entry) error {
+	beginCtx, cancel := dbr.queryContext(ctx)
+	defer cancel()
+	tx, err := dbr.Postgres.Begin(beginCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if err = dbr.RecordTx(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	commitCtx, cancel := dbr.queryContext(ctx)
+	defer cancel()
+	return tx.Commit(commitCtx)
+}
+
+// RecordTx chains the entry to the last one and inserts it in the transaction,
+// so that a mutation and its entry are committed together or not at all.
+// The advisory lock serialises writers of all the instances until the commit.
+func (dbr *DBRecorder) RecordTx(ctx context.Context, tx pgx.Tx, entry Entry) error {
+	lockCtx, cancel := dbr.queryContext(ctx)
+	defer cancel()
+	if _, err := tx.Exec(lockCtx, "select pg_advisory_xact_lock(hashtext('audit_log'))"); err != nil {
+		return err
+	}
+
+	var prev *Entry
+	var last Entry
+	lastCtx, cancel := dbr.queryContext(ctx)
+	defer cancel()
+	err := tx.QueryRow(
+		lastCtx,
+		"select seq, hash from audit_log order by seq desc limit 1",
+	).Scan(&last.Seq, &last.Hash)
+	if err == nil {
+		prev = &last
+	} else if err != pgx.ErrNoRows {
+		return err
+	}
+
+	if err = entry.seal(prev); err != nil {
+		return err
+	}
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	insertStatement := `
+	INSERT INTO audit_log (seq, time, actor, request_id, client_ip, action, short_link, before, after, details, prev_hash, hash)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);`
+
+	insertCtx, cancel := dbr.queryContext(ctx)
+	defer cancel()
+	_, err = tx.Exec(
+		insertCtx,
+		insertStatement,
+		entry.Seq,
+		entry.Time,
+		entry.Actor,
+		entry.RequestID,
+		entry.ClientIP,
+		entry.Action,
+		entry.ShortURL,
+		nullableText(entry.Before),
+		nullableText(entry.After),
+		string(details),
+		entry.PrevHash,
+		entry.Hash,
+	)
+	return err
+}
+
+// Find returns the last entries matching the filter, newest first.
+func (dbr *DBRecorder) Find(ctx context.Context, filter Filter) ([]Entry, error) {
+	selectStatement := selectEntries + `
+	where ($1::bigint is null or actor = $1)
+	and ($2 = '' or action = $2)
+	and ($3 = '' or short_link = $3)
+	order by seq desc
+	limit nullif($4, 0)`
+
+	return dbr.query(ctx, selectStatement, filter.Actor, filter.Action, filter.ShortURL, filter.Limit)
+}
+
+// Verify checks the chain of the trail stored in the table.
+func (dbr *DBRecorder) Verify(ctx context.Context) error {
+	entries, err := dbr.query(ctx, selectEntries+" order by seq")
+	if err != nil {
+		return err
+	}
+
+	return verifyChain(entries)
+}
+
+const selectEntries = `
+	select seq, time, actor, COALESCE(request_id, ''), COALESCE(client_ip, ''), action,
+	COALESCE(short_link, ''), COALESCE(before, ''), COALESCE(after, ''), COALESCE(details, 'null'),
+	prev_hash, hash
+	from audit_log`
+
+func (dbr *DBRecorder) query(ctx context.Context, sql string, args ...interface{}) ([]Entry, error) {
+	ctx, cancel := dbr.queryContext(ctx)
+	defer cancel()
+
+	conn, e := dbr.Postgres.Acquire(ctx)
+	if e != nil {
+		return nil, e
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Entry
+	for rows.Next() {
+		var e Entry
+		var actor int64
+		var before, after, details string
+		err = rows.Scan(&e.Seq, &e.Time, &actor, &e.RequestID, &e.ClientIP, &e.Action,
+			&e.ShortURL, &before, &after, &details, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		e.Actor = uint32(actor)
+		e.Time = e.Time.UTC()
+		if before != "" {
+			e.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			e.After = json.RawMessage(after)
+		}
+		if err = json.Unmarshal([]byte(details), &e.Details); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return result, nil
+}
+
+func nullableText(data json.RawMessage) *string {
+	if len(data) == 0 {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
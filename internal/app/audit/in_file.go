@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+)
+
+// FileRecorder appends the audit trail to a file, one json entry per line.
+// The last written entry is kept to chain the next one to it.
+type FileRecorder struct {
+	path string
+	last *Entry
+	m    sync.Mutex
+}
+
+// Returns a pointer to FileRecorder with the given path.
+func NewFileRecorder(path string) *FileRecorder {
+	return &FileRecorder{
+		path: path,
+	}
+}
+
+// CheckHealth checks that the file can be written to.
+func (r *FileRecorder) CheckHealth(ctx context.Context) error {
+	return health.CheckWritable(r.path)
+}
+
+// Record chains the entry to the last one and appends it to the file.
+func (r *FileRecorder) Record(ctx context.Context, entry Entry) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.last == nil {
+		entries, err := r.readAll()
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			r.last = &entries[len(entries)-1]
+		}
+	}
+
+	if err := entry.seal(r.last); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.WriteByte('\n'); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	r.last = &entry
+	return nil
+}
+
+// Find returns the last entries matching the filter, newest first.
+func (r *FileRecorder) Find(ctx context.Context, filter Filter) ([]Entry, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	entries, err := r.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return findEntries(entries, filter), nil
+}
+
+// Verify checks the chain of the trail written to the file.
+func (r *FileRecorder) Verify(ctx context.Context) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	entries, err := r.readAll()
+	if err != nil {
+		return err
+	}
+
+	return verifyChain(entries)
+}
+
+// Reads all the entries from the file.
+func (r *FileRecorder) readAll() ([]Entry, error) {
+	file, err := os.OpenFile(r.path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,213 @@
+package blocklist
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Match describes the entry of a list the link is matched by.
+type Match struct {
+	List  string
+	Entry string
+}
+
+// List contains the domains and sha256 hash prefixes of known malware
+// and phishing destinations loaded from local files, one entry per line:
+//
+//	# a comment
+//	evil.com                 the domain and its subdomains
+//	sha256:5d3e5b7a          the prefix of the hex sha256 of a url expression
+//
+// A url expression is a host suffix followed by a path prefix,
+// like example.com/ or login.example.com/path/page, see expressions.
+// The name of a list is the base name of its file.
+type List struct {
+	paths    []string
+	domains  map[string]string
+	prefixes map[int]map[string]string
+	m        sync.RWMutex
+}
+
+// Returns a pointer to List loaded from the files.
+func NewList(paths []string) (*List, error) {
+	list := &List{
+		paths: paths,
+	}
+
+	if err := list.Reload(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Reload reads the files again, the loaded entries are kept if a file cannot be read.
+func (l *List) Reload() error {
+	domains := make(map[string]string)
+	prefixes := make(map[int]map[string]string)
+
+	for _, path := range l.paths {
+		if err := load(path, domains, prefixes); err != nil {
+			return err
+		}
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.domains = domains
+	l.prefixes = prefixes
+	return nil
+}
+
+// Watch reloads the files on SIGHUP and every interval if it is not zero,
+// the failed reloads are logged by the logger and the lists are kept.
+func (l *List) Watch(interval time.Duration, logger zerolog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+		}
+
+		if err := l.Reload(); err != nil {
+			logger.Error().Err(err).Msg("blocklist is not reloaded")
+		}
+	}
+}
+
+// Check reports whether the link is matched by an entry of the lists.
+func (l *List) Check(link string) (Match, bool) {
+	if l == nil {
+		return Match{}, false
+	}
+
+	host, exprs, ok := expressions(link)
+	if !ok {
+		return Match{}, false
+	}
+
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	for suffix := host; suffix != ""; {
+		if list, ok := l.domains[suffix]; ok {
+			return Match{List: list, Entry: suffix}, true
+		}
+		i := strings.IndexByte(suffix, '.')
+		if i < 0 {
+			break
+		}
+		suffix = suffix[i+1:]
+	}
+
+	for _, expr := range exprs {
+		sum := sha256.Sum256([]byte(expr))
+		hash := hex.EncodeToString(sum[:])
+		for size, prefixes := range l.prefixes {
+			if list, ok := prefixes[hash[:size]]; ok {
+				return Match{List: list, Entry: "sha256:" + hash[:size]}, true
+			}
+		}
+	}
+
+	return Match{}, false
+}
+
+func load(path string, domains map[string]string, prefixes map[int]map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name := filepath.Base(path)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if prefix := strings.TrimPrefix(line, "sha256:"); prefix != line {
+			prefix = strings.ToLower(prefix)
+			if _, err := hex.DecodeString(prefix); err != nil || len(prefix) < 8 || len(prefix) > 64 {
+				return fmt.Errorf("%s:%d: incorrect hash prefix %q", path, n, line)
+			}
+			if prefixes[len(prefix)] == nil {
+				prefixes[len(prefix)] = make(map[string]string)
+			}
+			prefixes[len(prefix)][prefix] = name
+			continue
+		}
+
+		domains[strings.TrimSuffix(strings.ToLower(line), ".")] = name
+	}
+
+	return scanner.Err()
+}
+
+// Returns the host of the link and its url expressions: up to five
+// host suffixes, each followed by up to four path prefixes, the path
+// and the path with the query.
+func expressions(link string) (string, []string, bool) {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Hostname() == "" {
+		return "", nil, false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	hosts := []string{host}
+	labels := strings.Split(host, ".")
+	for i := len(labels) - 2; i > 0 && len(hosts) < 5; i-- {
+		hosts = append(hosts, strings.Join(labels[i:], "."))
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := []string{"/"}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments) && len(paths) < 4; i++ {
+		paths = append(paths, "/"+strings.Join(segments[:i], "/")+"/")
+	}
+	if path != "/" {
+		paths = append(paths, path)
+	}
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+
+	var exprs []string
+	for _, h := range hosts {
+		for _, p := range paths {
+			exprs = append(exprs, h+p)
+		}
+	}
+	return host, exprs, true
+}
@@ -0,0 +1,71 @@
+package blocklist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hashPrefix(expr string) string {
+	sum := sha256.Sum256([]byte(expr))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+func TestListCheck(t *testing.T) {
+	dir := t.TempDir()
+	malware := filepath.Join(dir, "malware.txt")
+	phishing := filepath.Join(dir, "phishing.txt")
+	require.NoError(t, ioutil.WriteFile(malware, []byte("# malware domains\nevil.com\n\nBAD.example.\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(phishing, []byte("sha256:"+hashPrefix("bank.example.org/login/")+"\n"), 0644))
+
+	list, err := NewList([]string{malware, phishing})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		link  string
+		match Match
+		ok    bool
+	}{
+		{name: "domain", link: "http://evil.com/", match: Match{List: "malware.txt", Entry: "evil.com"}, ok: true},
+		{name: "subdomain", link: "https://cdn.evil.com/x.exe", match: Match{List: "malware.txt", Entry: "evil.com"}, ok: true},
+		{name: "normalised domain", link: "http://Bad.Example/", match: Match{List: "malware.txt", Entry: "bad.example"}, ok: true},
+		{name: "not listed", link: "https://notevil.com/", ok: false},
+		{name: "hash prefix of path", link: "https://www.bank.example.org/login/form?user=1",
+			match: Match{List: "phishing.txt", Entry: "sha256:" + hashPrefix("bank.example.org/login/")}, ok: true},
+		{name: "hash prefix does not match other paths", link: "https://bank.example.org/about", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := list.Check(tt.link)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.match, match)
+		})
+	}
+}
+
+func TestListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("evil.com\n"), 0644))
+
+	list, err := NewList([]string{path})
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("other.com\n"), 0644))
+	require.NoError(t, list.Reload())
+	_, ok := list.Check("http://evil.com/")
+	assert.False(t, ok)
+	_, ok = list.Check("http://other.com/")
+	assert.True(t, ok)
+
+	// an incorrect file keeps the loaded entries.
+	require.NoError(t, ioutil.WriteFile(path, []byte("sha256:xyz\n"), 0644))
+	assert.Error(t, list.Reload())
+	_, ok = list.Check("http://other.com/")
+	assert.True(t, ok)
+}
@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Reloader serves the certificate loaded from the files and loads it
+// again when the files change, so that a renewed certificate is used
+// without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	m        sync.RWMutex
+}
+
+// Returns a pointer to Reloader with the certificate loaded from the files.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload loads the certificate again, the loaded one is kept if the files are invalid.
+func (r *Reloader) Reload() error {
+	modTime := r.filesModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate, it is used as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.cert, nil
+}
+
+// TLSConfig returns the server config serving the current certificate.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Watch reloads the certificate on SIGHUP and when the files
+// are modified, checking them every interval if it is not zero.
+// The failed reloads are logged by the logger and the certificate is kept.
+func (r *Reloader) Watch(interval time.Duration, logger zerolog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			r.m.RLock()
+			modified := !r.filesModTime().Equal(r.modTime)
+			r.m.RUnlock()
+			if !modified {
+				continue
+			}
+		}
+
+		if err := r.Reload(); err != nil {
+			logger.Error().Err(err).Msg("certificate is not reloaded")
+		}
+	}
+}
+
+// Returns the latest modification time of the files.
+func (r *Reloader) filesModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// GenerateSelfSigned returns the pem encoded certificate and ECDSA P-256 key
+// valid for the hosts, which are dns names or ip addresses, for the duration.
+// The certificate is meant for local development only.
+func GenerateSelfSigned(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"shortener development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// WriteSelfSigned generates the self-signed certificate and writes it
+// and its key to the files, the key is readable by the owner only.
+func WriteSelfSigned(certFile, keyFile string, hosts []string, validFor time.Duration) error {
+	certPEM, keyPEM, err := GenerateSelfSigned(hosts, validFor)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, keyPEM, 0600)
+}
@@ -0,0 +1,59 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns the leaf certificate the reloader serves.
+func servedCertificate(t *testing.T, r *Reloader) *x509.Certificate {
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf
+}
+
+func TestGenerateSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSigned([]string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"localhost"}, leaf.DNSNames)
+	require.Len(t, leaf.IPAddresses, 1)
+	assert.Equal(t, "127.0.0.1", leaf.IPAddresses[0].String())
+	assert.NoError(t, leaf.VerifyHostname("localhost"))
+	assert.True(t, leaf.NotAfter.Before(time.Now().Add(time.Hour+time.Minute)))
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, WriteSelfSigned(certFile, keyFile, []string{"first.test"}, time.Hour))
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first.test"}, servedCertificate(t, r).DNSNames)
+
+	require.NoError(t, WriteSelfSigned(certFile, keyFile, []string{"second.test"}, time.Hour))
+	require.NoError(t, r.Reload())
+	assert.Equal(t, []string{"second.test"}, servedCertificate(t, r).DNSNames)
+
+	// the invalid files do not replace the served certificate.
+	require.NoError(t, WriteSelfSigned(certFile, filepath.Join(dir, "other.pem"), []string{"third.test"}, time.Hour))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, []string{"second.test"}, servedCertificate(t, r).DNSNames)
+
+	_, err = NewReloader(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+}
@@ -0,0 +1,256 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnv is the environment variable with the path to the config file.
+const ConfigEnv = "CONFIG"
+
+// field describes a field of Config: its index, the key in the config file,
+// the environment variable, the short flag, how the value is redacted
+// and whether it can be reloaded.
+type field struct {
+	index  int
+	key    string
+	env    string
+	short  string
+	secret string
+	reload bool
+}
+
+// LoadConfig returns the config with the values taken in the order of precedence:
+//
+//  1. flags, like -server-address or the short -a, -b, -f, -d;
+//  2. environment variables, like SERVER_ADDRESS;
+//  3. the config file from the -c flag or the CONFIG environment variable,
+//     json if its extension is .json or yaml otherwise, with keys like server_address;
+//  4. the defaults.
+//
+// Unknown keys of the file and invalid values are errors, the result is validated.
+// The flags are registered on fs unless they are already there, so fs can be
+// flag.CommandLine shared with other packages.
+func LoadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	fields := configFields()
+	registerFlags(fs, fields)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := env.Parse(&cfg, env.Options{Environment: map[string]string{}}); err != nil {
+		return Config{}, err
+	}
+
+	path := fs.Lookup("c").Value.String()
+	if path == "" {
+		path = os.Getenv(ConfigEnv)
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+		cfg.ConfigFile = path
+	}
+
+	var fromEnv Config
+	if err := env.Parse(&fromEnv); err != nil {
+		return Config{}, err
+	}
+	value, envValue := reflect.ValueOf(&cfg).Elem(), reflect.ValueOf(fromEnv)
+	for _, f := range fields {
+		if _, ok := os.LookupEnv(f.env); ok {
+			value.Field(f.index).Set(envValue.Field(f.index))
+		}
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		if v, ok := fl.Value.(fieldValue); ok {
+			value.Field(v.index).Set(v.v)
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Returns the fields of Config having the env tag.
+func configFields() []field {
+	t := reflect.TypeOf(Config{})
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		if tag.Get("env") == "" {
+			continue
+		}
+		fields = append(fields, field{
+			index:  i,
+			key:    tag.Get("yaml"),
+			env:    tag.Get("env"),
+			short:  tag.Get("flag"),
+			secret: tag.Get("secret"),
+			reload: tag.Get("reload") == "true",
+		})
+	}
+	return fields
+}
+
+func flagName(f field) string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+// Registers the flags parsed into a new Config,
+// the values are copied to the loaded config only if the flags are set.
+func registerFlags(fs *flag.FlagSet, fields []field) {
+	if fs.Lookup("c") == nil {
+		fs.String("c", "", "path to the json or yaml config file (env "+ConfigEnv+")")
+	}
+
+	value := reflect.ValueOf(new(Config)).Elem()
+	for _, f := range fields {
+		v := fieldValue{index: f.index, v: value.Field(f.index)}
+		usage := fmt.Sprintf("sets %s (env %s)", f.key, f.env)
+		if fs.Lookup(flagName(f)) == nil {
+			fs.Var(v, flagName(f), usage)
+		}
+		if f.short != "" && fs.Lookup(f.short) == nil {
+			fs.Var(v, f.short, usage)
+		}
+	}
+}
+
+// Decodes the file over the config, the durations are strings like 30s.
+func loadFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	// json is decoded into a map and encoded as yaml,
+	// so that both formats are decoded by the same rules.
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var m map[string]interface{}
+		if err = json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(m); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// fieldValue implements flag.Value over the field of Config with the index,
+// slices are given as comma separated values.
+type fieldValue struct {
+	index int
+	v     reflect.Value
+}
+
+func (f fieldValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+	return formatValue(f.v)
+}
+
+func (f fieldValue) Set(s string) error {
+	return setValue(f.v, s)
+}
+
+func (f fieldValue) IsBoolFlag() bool {
+	return f.v.IsValid() && f.v.Kind() == reflect.Bool
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		if s != "" {
+			for _, item := range strings.Split(s, ",") {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := setValue(elem, strings.TrimSpace(item)); err != nil {
+					return err
+				}
+				slice = reflect.Append(slice, elem)
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
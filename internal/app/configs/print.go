@@ -0,0 +1,75 @@
+package configs
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The value secrets are replaced with.
+const redacted = "REDACTED"
+
+// Redacted returns the copy of the config with the secrets replaced,
+// the dsns keep everything but the password.
+func (c Config) Redacted() Config {
+	value := reflect.ValueOf(&c).Elem()
+	for _, f := range configFields() {
+		v := value.Field(f.index)
+		if v.Kind() == reflect.Slice && f.secret != "" {
+			// The copy must not share the items with the config.
+			v.Set(reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v))
+		}
+		switch f.secret {
+		case "true":
+			if v.Kind() == reflect.Slice {
+				for i := 0; i < v.Len(); i++ {
+					v.Index(i).SetString(redacted)
+				}
+			} else if v.String() != "" {
+				v.SetString(redacted)
+			}
+		case "dsn":
+			if v.Kind() == reflect.Slice {
+				for i := 0; i < v.Len(); i++ {
+					v.Index(i).SetString(redactDSN(v.Index(i).String()))
+				}
+			} else {
+				v.SetString(redactDSN(v.String()))
+			}
+		}
+	}
+	return c
+}
+
+// Replaces the password of the url dsn or the whole key value dsn.
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	query := u.Query()
+	if query.Get("password") != "" {
+		query.Set("password", redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// Print writes the config with the secrets redacted in the format
+// of the config file, so it can be used as one.
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
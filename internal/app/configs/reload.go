@@ -0,0 +1,133 @@
+package configs
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// current holds the *Config snapshot read by Get.
+var current atomic.Value
+
+// Serialises the reloads, so that a reload does not overwrite another one.
+var reloadMu sync.Mutex
+
+func init() {
+	current.Store(&Config{})
+}
+
+// Get returns the current config. The snapshot is replaced as a whole
+// on reload and must not be modified, a request reading it once sees
+// consistent settings.
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+// Set replaces the current config.
+func Set(cfg Config) {
+	current.Store(&cfg)
+}
+
+// Change describes a setting that differs in the reloaded config,
+// the values of the secrets are redacted. Applied is false for
+// the settings that take effect only after a restart.
+type Change struct {
+	Key     string
+	Old     string
+	New     string
+	Applied bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// Reload loads the config again like SetConfig and applies it, see Apply.
+// The current config is kept if the loaded one is invalid.
+func Reload() ([]Change, error) {
+	cfg, err := LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return Apply(cfg)
+}
+
+// Apply replaces the reloadable settings of the current config
+// with the settings of cfg and returns the changes, including
+// the changes of the other settings which are not applied.
+func Apply(cfg Config) ([]Change, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := *Get()
+	next := old
+	nextValue := reflect.ValueOf(&next).Elem()
+	oldValue, cfgValue := reflect.ValueOf(old.Redacted()), reflect.ValueOf(cfg.Redacted())
+
+	var changes []Change
+	for _, f := range configFields() {
+		if reflect.DeepEqual(reflect.ValueOf(old).Field(f.index).Interface(), reflect.ValueOf(cfg).Field(f.index).Interface()) {
+			continue
+		}
+		changes = append(changes, Change{
+			Key:     f.key,
+			Old:     formatValue(oldValue.Field(f.index)),
+			New:     formatValue(cfgValue.Field(f.index)),
+			Applied: f.reload,
+		})
+		if f.reload {
+			nextValue.Field(f.index).Set(reflect.ValueOf(cfg).Field(f.index))
+		}
+	}
+
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	Set(next)
+	return changes, nil
+}
+
+// Watch calls Reload on SIGHUP and when the modification time of
+// the config file changes, checking it every ConfigWatchInterval,
+// and passes the result to report.
+func Watch(report func([]Change, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	cfg := Get()
+	var tick <-chan time.Time
+	if cfg.ConfigFile != "" && cfg.ConfigWatchInterval > 0 {
+		ticker := time.NewTicker(cfg.ConfigWatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modTime := fileModTime(cfg.ConfigFile)
+
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			t := fileModTime(cfg.ConfigFile)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+		}
+
+		report(Reload())
+	}
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
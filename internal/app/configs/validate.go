@@ -0,0 +1,223 @@
+package configs
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// The default of secret_key, it is public and must not sign the cookies of the admins.
+const defaultSecretKey = "secret_key"
+
+const minAdminTokenLength = 16
+
+// ValidationError lists the invalid settings of the config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks the settings and returns ValidationError
+// describing all the invalid ones, the keys are the yaml keys.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if err := validateBaseURL(c.BaseURL); err != nil {
+		check(false, "base_url %q: %v", c.BaseURL, err)
+	}
+	hosts := map[string]bool{}
+	if u, err := url.Parse(c.BaseURL); err == nil {
+		hosts[strings.ToLower(u.Host)] = true
+	}
+	for _, domain := range c.Domains {
+		if err := validateBaseURL(domain); err != nil {
+			check(false, "domains %q: %v", domain, err)
+			continue
+		}
+		u, _ := url.Parse(domain)
+		host := strings.ToLower(u.Host)
+		check(!hosts[host], "domains %q: the host %s is repeated or is the host of base_url", domain, host)
+		hosts[host] = true
+	}
+	if err := validateAddress(c.ServerAddress); err != nil {
+		check(false, "server_address %q: %v", c.ServerAddress, err)
+	}
+	if c.GRPCAddress != "" {
+		if err := validateAddress(c.GRPCAddress); err != nil {
+			check(false, "grpc_address %q: %v", c.GRPCAddress, err)
+		}
+	}
+	if c.MetricsAddress != "" {
+		if err := validateAddress(c.MetricsAddress); err != nil {
+			check(false, "metrics_address %q: %v", c.MetricsAddress, err)
+		}
+	}
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "tls_cert_file and tls_key_file: must be set together")
+	check(c.TLSReloadInterval >= 0, "tls_reload_interval %v: must not be negative", c.TLSReloadInterval)
+	if c.TLSRedirectAddress != "" {
+		check(c.TLSEnabled(), "tls_redirect_address: requires tls_cert_file and tls_key_file")
+		if err := validateAddress(c.TLSRedirectAddress); err != nil {
+			check(false, "tls_redirect_address %q: %v", c.TLSRedirectAddress, err)
+		}
+	}
+	check(c.HSTSMaxAge >= 0, "hsts_max_age %v: must not be negative", c.HSTSMaxAge)
+	if path, ok := c.SQLitePath(); ok {
+		check(path != "", "database_dsn: the sqlite path must not be empty")
+	} else if c.DatabaseDSN != "" {
+		if _, err := pgx.ParseConfig(c.DatabaseDSN); err != nil {
+			check(false, "database_dsn: cannot be parsed")
+		}
+	}
+
+	_, sqlite := c.SQLitePath()
+	if len(c.DatabaseReplicaDSNs) > 0 {
+		check(c.DatabaseDSN != "" && !sqlite, "database_replica_dsns: requires a postgres database_dsn")
+	}
+	for i, dsn := range c.DatabaseReplicaDSNs {
+		if _, err := pgx.ParseConfig(dsn); err != nil || dsn == "" {
+			check(false, "database_replica_dsns[%d]: cannot be parsed", i)
+		}
+	}
+	check(c.DatabaseReplicaCheckInterval > 0, "database_replica_check_interval %v: must be positive", c.DatabaseReplicaCheckInterval)
+	check(c.DatabaseReplicaPinDuration >= 0, "database_replica_pin_duration %v: must not be negative", c.DatabaseReplicaPinDuration)
+	check(c.DatabaseMaxConns > 0, "database_max_conns %d: must be positive", c.DatabaseMaxConns)
+	check(c.DatabaseMinConns >= 0 && c.DatabaseMinConns <= c.DatabaseMaxConns,
+		"database_min_conns %d: must be from 0 to database_max_conns", c.DatabaseMinConns)
+	check(c.DatabaseMaxConnIdleTime > 0, "database_max_conn_idle_time %v: must be positive", c.DatabaseMaxConnIdleTime)
+	check(c.DatabaseMaxConnLifetime > 0, "database_max_conn_lifetime %v: must be positive", c.DatabaseMaxConnLifetime)
+	check(c.DatabaseHealthCheckPeriod > 0, "database_health_check_period %v: must be positive", c.DatabaseHealthCheckPeriod)
+	check(c.DatabaseConnectTimeout >= 0, "database_connect_timeout %v: must not be negative", c.DatabaseConnectTimeout)
+	switch c.DatabaseStatementCacheMode {
+	case "prepare", "describe", "none":
+	default:
+		check(false, "database_statement_cache_mode %q: must be prepare, describe or none", c.DatabaseStatementCacheMode)
+	}
+	check(c.DatabaseStatementCacheCapacity >= 0,
+		"database_statement_cache_capacity %d: must not be negative", c.DatabaseStatementCacheCapacity)
+	check(c.DatabaseQueryTimeout >= 0, "database_query_timeout %v: must not be negative", c.DatabaseQueryTimeout)
+	for _, backend := range append([]string{c.StorageBackend}, c.StorageFallback...) {
+		switch backend {
+		case "postgres":
+			check(c.DatabaseDSN != "" && !sqlite, "storage backend %q: requires a postgres database_dsn", backend)
+		case "sqlite":
+			check(sqlite, "storage backend %q: requires a sqlite:// database_dsn", backend)
+		case "bolt":
+			check(c.BoltPath != "", "storage backend %q: requires bolt_path", backend)
+		case "file":
+			check(c.FileStoragePath != "", "storage backend %q: requires file_storage_path", backend)
+		}
+	}
+	for _, backend := range c.StorageFallback {
+		check(backend != "", "storage_fallback: must not contain empty backends")
+	}
+
+	check(c.CacheSize >= 0, "cache_size %d: must not be negative", c.CacheSize)
+	check(c.CacheTTL >= 0, "cache_ttl %v: must not be negative", c.CacheTTL)
+	check(c.CacheNegativeTTL >= 0, "cache_negative_ttl %v: must not be negative", c.CacheNegativeTTL)
+
+	if c.LogLevel != "" {
+		_, err := zerolog.ParseLevel(c.LogLevel)
+		check(err == nil, "log_level %q: must be one of trace, debug, info, warn, error, fatal, panic", c.LogLevel)
+	}
+	check(c.ZerologLevel >= -1 && c.ZerologLevel <= 5, "zero_log_level %d: must be from -1 to 5", c.ZerologLevel)
+	_, err := pgx.LogLevelFromString(c.PgxLogLevel)
+	check(err == nil, "pgx_log_level %q: must be one of trace, debug, info, warn, error, none", c.PgxLogLevel)
+	check(c.LogOutput != "", "log_output: must not be empty")
+	check(c.LogMaxSizeMB >= 0, "log_max_size_mb %d: must not be negative", c.LogMaxSizeMB)
+	check(c.LogMaxBackups >= 0, "log_max_backups %d: must not be negative", c.LogMaxBackups)
+	check(c.LogMaxAgeDays >= 0, "log_max_age_days %d: must not be negative", c.LogMaxAgeDays)
+
+	check(c.RateLimitCreateRPS >= 0, "rate_limit_create_rps %v: must not be negative", c.RateLimitCreateRPS)
+	check(c.RateLimitCreateBurst >= 0, "rate_limit_create_burst %d: must not be negative", c.RateLimitCreateBurst)
+	check(c.RateLimitRedirectRPS >= 0, "rate_limit_redirect_rps %v: must not be negative", c.RateLimitRedirectRPS)
+	check(c.RateLimitRedirectBurst >= 0, "rate_limit_redirect_burst %d: must not be negative", c.RateLimitRedirectBurst)
+	check(c.RateLimitDeleteRPS >= 0, "rate_limit_delete_rps %v: must not be negative", c.RateLimitDeleteRPS)
+	check(c.RateLimitDeleteBurst >= 0, "rate_limit_delete_burst %d: must not be negative", c.RateLimitDeleteBurst)
+	switch c.RateLimitStore {
+	case "memory":
+	case "postgres":
+		check(c.DatabaseDSN != "" && !sqlite, "rate_limit_store %q: requires a postgres database_dsn", c.RateLimitStore)
+	default:
+		check(false, "rate_limit_store %q: must be memory or postgres", c.RateLimitStore)
+	}
+	check(c.RateLimitKeyBy == "user" || c.RateLimitKeyBy == "ip",
+		"rate_limit_key_by %q: must be user or ip", c.RateLimitKeyBy)
+	for _, key := range c.APIKeys {
+		check(strings.TrimSpace(key) != "", "api_keys: must not contain empty keys")
+	}
+
+	check(len(c.URLPolicyAllowedSchemes) > 0, "url_policy_allowed_schemes: must not be empty")
+	for _, scheme := range c.URLPolicyAllowedSchemes {
+		check(scheme != "" && !strings.Contains(scheme, ":"),
+			"url_policy_allowed_schemes %q: must be a scheme like https", scheme)
+	}
+	check(c.BlocklistReloadInterval >= 0, "blocklist_reload_interval %v: must not be negative", c.BlocklistReloadInterval)
+	check(c.HealthCheckTimeout >= 0, "health_check_timeout %v: must not be negative", c.HealthCheckTimeout)
+	check(c.DeletionQueueMaxDepth >= 0, "deletion_queue_max_depth %d: must not be negative", c.DeletionQueueMaxDepth)
+
+	if c.TracingOTLPEndpoint != "" {
+		if err := validateAddress(c.TracingOTLPEndpoint); err != nil {
+			check(false, "tracing_otlp_endpoint %q: %v", c.TracingOTLPEndpoint, err)
+		}
+	}
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1,
+		"tracing_sample_ratio %v: must be from 0 to 1", c.TracingSampleRatio)
+
+	if len(c.AdminUserIDs) > 0 {
+		// the admins are told by the user cookie signed with the secret key.
+		check(c.SecretKey != "" && c.SecretKey != defaultSecretKey,
+			"secret_key: must be set to a non default value when admin_user_ids is set")
+		check(len(c.AdminToken) >= minAdminTokenLength,
+			"admin_token: must be at least %d characters when admin_user_ids is set", minAdminTokenLength)
+	}
+
+	check(c.ConfigWatchInterval >= 0, "config_watch_interval %v: must not be negative", c.ConfigWatchInterval)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// The base url must be an absolute http or https url without the query,
+// the short links are appended to it after a slash.
+func validateBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("cannot be parsed")
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return fmt.Errorf("must be an absolute http or https url")
+	case u.Host == "":
+		return fmt.Errorf("must have a host")
+	case u.RawQuery != "" || u.Fragment != "":
+		return fmt.Errorf("must not have a query or a fragment")
+	case strings.HasSuffix(u.Path, "/"):
+		return fmt.Errorf("must not end with a slash")
+	}
+	return nil
+}
+
+func validateAddress(s string) error {
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return fmt.Errorf("must be host:port")
+	}
+	if port == "" {
+		return fmt.Errorf("must have a port")
+	}
+	return nil
+}
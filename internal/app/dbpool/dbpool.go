@@ -0,0 +1,104 @@
+// Package dbpool builds the pgx pools of the service from config,
+// so that every pool the service opens has the same settings.
+package dbpool
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+// Connect returns the pool connected to the dsn with the settings of Config.
+func Connect(ctx context.Context, dsn string, logger pgx.Logger, logLevel pgx.LogLevel) (*pgxpool.Pool, error) {
+	conf, err := Config(dsn, logger, logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, conf)
+	if err != nil {
+		return nil, fmt.Errorf("pgx connection error: %w", err)
+	}
+	return pool, nil
+}
+
+// Config returns the config of a pool connected to the dsn with the settings
+// of the config, the zero settings keep the defaults of pgx.
+func Config(dsn string, logger pgx.Logger, logLevel pgx.LogLevel) (*pgxpool.Config, error) {
+	conf, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.ConnConfig.Logger = logger
+
+	if logLevel != 0 {
+		conf.ConnConfig.LogLevel = logLevel
+	}
+
+	cfg := configs.Get()
+	if cfg.DatabaseMaxConns > 0 {
+		conf.MaxConns = cfg.DatabaseMaxConns
+	}
+	conf.MinConns = cfg.DatabaseMinConns
+	if cfg.DatabaseMaxConnIdleTime > 0 {
+		conf.MaxConnIdleTime = cfg.DatabaseMaxConnIdleTime
+	}
+	if cfg.DatabaseMaxConnLifetime > 0 {
+		conf.MaxConnLifetime = cfg.DatabaseMaxConnLifetime
+	}
+	if cfg.DatabaseHealthCheckPeriod > 0 {
+		conf.HealthCheckPeriod = cfg.DatabaseHealthCheckPeriod
+	}
+	if cfg.DatabaseConnectTimeout > 0 {
+		conf.ConnConfig.ConnectTimeout = cfg.DatabaseConnectTimeout
+	}
+
+	capacity := cfg.DatabaseStatementCacheCapacity
+	switch cfg.DatabaseStatementCacheMode {
+	case "none":
+		conf.ConnConfig.BuildStatementCache = nil
+	case "describe":
+		conf.ConnConfig.BuildStatementCache = statementCache(stmtcache.ModeDescribe, capacity)
+	default:
+		conf.ConnConfig.BuildStatementCache = statementCache(stmtcache.ModePrepare, capacity)
+	}
+	return conf, nil
+}
+
+// PreparesStatements reports whether the statements are prepared on connect
+// by the statement cache mode of the config.
+func PreparesStatements() bool {
+	switch configs.Get().DatabaseStatementCacheMode {
+	case "none", "describe":
+		return false
+	}
+	return true
+}
+
+// Returns the builder of the statement caches of the mode keeping
+// up to capacity statements, nil disables the cache.
+func statementCache(mode int, capacity int) pgx.BuildStatementCacheFunc {
+	if capacity <= 0 {
+		return nil
+	}
+	return func(conn *pgconn.PgConn) stmtcache.Cache {
+		return stmtcache.New(conn, mode, capacity)
+	}
+}
+
+// QueryContext returns the context of a query cancelled after the timeout,
+// the zero timeout disables it.
+func QueryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,44 @@
+package dbpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+func TestConfig(t *testing.T) {
+	old := *configs.Get()
+	cfg := old
+	cfg.DatabaseMaxConns = 50
+	cfg.DatabaseMaxConnLifetime = time.Hour
+	cfg.DatabaseStatementCacheMode = "describe"
+	cfg.DatabaseStatementCacheCapacity = 128
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+
+	conf, err := Config("postgres://postgres@127.0.0.1:1/db", nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int32(50), conf.MaxConns)
+	assert.Equal(t, time.Hour, conf.MaxConnLifetime)
+	assert.NotNil(t, conf.ConnConfig.BuildStatementCache)
+	assert.Nil(t, conf.AfterConnect, "the statements of the storage are not prepared on other pools")
+	assert.False(t, PreparesStatements())
+}
+
+func TestQueryContext(t *testing.T) {
+	ctx, cancel := QueryContext(context.Background(), time.Second)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	ctx, cancel = QueryContext(context.Background(), 0)
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok, "zero timeout disables the timeout")
+}
@@ -0,0 +1,59 @@
+// Package deletion deletes the links of users in the background
+// for the http and the grpc api and reports how many are waiting.
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+)
+
+const workersCount = 3
+
+// The number of links waiting to be deleted by the workers.
+var queueDepth int64
+
+type job struct {
+	shortURL string
+}
+
+// InBackground deletes the links of the user in the domain by the workers outliving
+// the request, the links waiting to be deleted are counted in the deletion queue.
+func InBackground(ctx context.Context, urlStorage storage.ShortURLRepo, domain string, links []string, id uint32) {
+	// only the actor and the trace are taken from the context of the request.
+	workerCtx := audit.WithActor(context.Background(), audit.ActorFromContext(ctx))
+	workerCtx = trace.ContextWithSpanContext(workerCtx, trace.SpanContextFromContext(ctx))
+	jobCh := make(chan *job)
+	for i := 0; i < workersCount; i++ {
+		go func() {
+			for job := range jobCh {
+				urlStorage.DeleteShortURLUser(workerCtx, domain, job.shortURL, id)
+				metrics.DeletionQueueDepth.Dec()
+				atomic.AddInt64(&queueDepth, -1)
+			}
+		}()
+	}
+
+	metrics.DeletionQueueDepth.Add(float64(len(links)))
+	atomic.AddInt64(&queueDepth, int64(len(links)))
+	for i := 0; i < len(links); i++ {
+		jobCh <- &job{shortURL: links[i]}
+	}
+	close(jobCh)
+}
+
+// CheckQueue checks that the deletion workers keep up with the requests.
+func CheckQueue(ctx context.Context) error {
+	depth := atomic.LoadInt64(&queueDepth)
+	if max := int64(configs.Get().DeletionQueueMaxDepth); max > 0 && depth > max {
+		return fmt.Errorf("%d links are waiting to be deleted, more than %d", depth, max)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package domains keeps the short domains the links are created in.
+// A link is identified by its code within its domain, the domain of
+// the base url is the default one and is kept as the empty name.
+package domains
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Default is the name of the domain of the base url.
+const Default = ""
+
+// Registry contains the base urls of the domains by their names,
+// the name of a domain is the lowercased host of its base url.
+type Registry struct {
+	baseURLs map[string]string
+	// defaultHost is the host of the default domain.
+	defaultHost string
+}
+
+// Returns a pointer to Registry with the default domain of the base url and the domains.
+func NewRegistry(baseURL string, domains []string) *Registry {
+	r := &Registry{
+		baseURLs:    map[string]string{Default: baseURL},
+		defaultHost: host(baseURL),
+	}
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(domain, "/")
+		if name := host(domain); name != "" && name != r.defaultHost {
+			r.baseURLs[name] = domain
+		}
+	}
+	return r
+}
+
+var (
+	m        sync.Mutex
+	cached   *Registry
+	cachedBy *configs.Config
+)
+
+// Get returns the registry of the current config, it is rebuilt
+// only after the config is replaced.
+func Get() *Registry {
+	cfg := configs.Get()
+
+	m.Lock()
+	defer m.Unlock()
+	if cachedBy != cfg {
+		cached, cachedBy = NewRegistry(cfg.BaseURL, cfg.Domains), cfg
+	}
+	return cached
+}
+
+// Lookup returns the name of the domain given by its name or its host with a port,
+// the error is of the utils.ErrValidation kind if there is no such domain.
+func (r *Registry) Lookup(name string) (string, error) {
+	if domain, ok := r.find(name); ok {
+		return domain, nil
+	}
+	return "", utils.NewValidationError(fmt.Sprintf("unknown domain %q", name))
+}
+
+// Resolve returns the domain of the host the request is sent to,
+// the default domain if the host is not one of the domains.
+func (r *Registry) Resolve(host string) string {
+	domain, _ := r.find(host)
+	return domain
+}
+
+// ShortURL returns the short url of the code in the domain.
+func (r *Registry) ShortURL(domain, code string) string {
+	return r.BaseURL(domain) + "/" + code
+}
+
+// BaseURL returns the base url of the domain, the base url
+// of the default domain if the domain is unknown.
+func (r *Registry) BaseURL(domain string) string {
+	if baseURL, ok := r.baseURLs[domain]; ok {
+		return baseURL
+	}
+	return r.baseURLs[Default]
+}
+
+// Finds the domain of the name: the name as is or without its port.
+func (r *Registry) find(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	candidates := []string{name}
+	if hostname, _, err := net.SplitHostPort(name); err == nil {
+		candidates = append(candidates, strings.TrimSuffix(hostname, "."))
+	}
+
+	for _, candidate := range candidates {
+		if candidate == "" || candidate == r.defaultHost || candidate == hostname(r.defaultHost) {
+			return Default, true
+		}
+		if _, ok := r.baseURLs[candidate]; ok {
+			return candidate, true
+		}
+	}
+	return Default, false
+}
+
+// Returns the lowercased host of the url, empty if it cannot be parsed.
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// Returns the host without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,38 @@
+package domains
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry("http://localhost:8080", []string{"https://go.brand.example/", "http://Links.Example:8081"})
+
+	for host, domain := range map[string]string{
+		"localhost:8080":        Default,
+		"localhost":             Default,
+		"go.brand.example":      "go.brand.example",
+		"GO.BRAND.EXAMPLE.:443": "go.brand.example",
+		"links.example:8081":    "links.example:8081",
+		"unknown.example":       Default,
+	} {
+		assert.Equal(t, domain, r.Resolve(host), host)
+	}
+
+	domain, err := r.Lookup("go.brand.example")
+	require.NoError(t, err)
+	assert.Equal(t, "go.brand.example", domain)
+	domain, err = r.Lookup("")
+	require.NoError(t, err)
+	assert.Equal(t, Default, domain)
+	_, err = r.Lookup("unknown.example")
+	assert.ErrorIs(t, err, utils.ErrValidation)
+
+	assert.Equal(t, "http://localhost:8080/abc", r.ShortURL(Default, "abc"))
+	assert.Equal(t, "https://go.brand.example/abc", r.ShortURL("go.brand.example", "abc"))
+	assert.Equal(t, "http://Links.Example:8081/abc", r.ShortURL("links.example:8081", "abc"))
+}
@@ -27,7 +27,7 @@ func GenerateUserIDToken() (string, error) {
 		return "", err
 	}
 
-	h := hmac.New(sha256.New, []byte(configs.Cfg.SecretKey))
+	h := hmac.New(sha256.New, []byte(configs.Get().SecretKey))
 	h.Write(id)
 	signedID := hex.EncodeToString(append(id, h.Sum(nil)...))
 
@@ -41,7 +41,7 @@ func AuthUserIDToken(userIDToken string) (bool, error) {
 		return false, err
 	}
 
-	h := hmac.New(sha256.New, []byte(configs.Cfg.SecretKey))
+	h := hmac.New(sha256.New, []byte(configs.Get().SecretKey))
 	h.Write(data[:4])
 	sign := h.Sum(nil)
 
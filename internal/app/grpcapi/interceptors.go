@@ -0,0 +1,222 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
+)
+
+// The metadata keys of the user token, the request id, the api key
+// and the time to wait for the rate limit.
+const (
+	MetadataUserID     = "user_id"
+	MetadataRequestID  = "x-request-id"
+	MetadataAPIKey     = "x-api-key"
+	MetadataRetryAfter = "retry-after"
+)
+
+// The rate limit scopes of the methods like the scopes of the http routes,
+// the methods missing here are not limited.
+var methodScopes = map[string]string{
+	"/shortener.v1.Shortener/Shorten":        ratelimit.ScopeCreate,
+	"/shortener.v1.Shortener/ShortenBatch":   ratelimit.ScopeCreate,
+	"/shortener.v1.Shortener/Resolve":        ratelimit.ScopeRedirect,
+	"/shortener.v1.Shortener/DeleteUserURLs": ratelimit.ScopeDelete,
+}
+
+type contextKey int
+
+const contextKeyUserID contextKey = iota
+
+// Returns the id of the user authenticated by UnaryAuthInterceptor.
+func userID(ctx context.Context) uint32 {
+	id, _ := ctx.Value(contextKeyUserID).(uint32)
+	return id
+}
+
+// Returns the first value of the metadata key of the incoming call.
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// UnaryAuthInterceptor authenticates the user by the token in the user_id metadata
+// like MiddlewareAuthUserHandle does by the cookie. If the token is missing
+// or invalid, a new one is generated and sent in the user_id header metadata.
+func UnaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	token := metadataValue(ctx, MetadataUserID)
+	if token != "" {
+		if isAuthentic, err := gen.AuthUserIDToken(token); err != nil || !isAuthentic {
+			token = ""
+		}
+	}
+
+	if token == "" {
+		var err error
+		if token, err = gen.GenerateUserIDToken(); err != nil {
+			return nil, internalError(ctx, err)
+		}
+		if err = grpc.SetHeader(ctx, metadata.Pairs(MetadataUserID, token)); err != nil {
+			return nil, internalError(ctx, err)
+		}
+	}
+
+	id, err := gen.GetUserID(token)
+	if err != nil {
+		return nil, internalError(ctx, err)
+	}
+
+	zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Uint32("user_id", id)
+	})
+	ctx = context.WithValue(ctx, contextKeyUserID, id)
+	ctx = audit.WithActor(ctx, audit.Actor{
+		UserID:    id,
+		RequestID: metadataValue(ctx, MetadataRequestID),
+		ClientIP:  peerIP(ctx),
+	})
+	return handler(ctx, req)
+}
+
+// UnaryRecoveryInterceptor turns a panic of the handler into the Internal status
+// and logs it with the stack, like middleware.Recoverer does for http.
+func UnaryRecoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			zerolog.Ctx(ctx).Error().
+				Interface("panic", rvr).
+				Bytes("stack", debug.Stack()).
+				Str("method", info.FullMethod).
+				Msg("panic recovered")
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// UnaryLoggingInterceptor puts the request-scoped logger into the context
+// and writes a json line per call like MiddlewareAccessLogHandle.
+// The request id is taken from the x-request-id metadata or generated.
+func UnaryLoggingInterceptor(logger zerolog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		requestID := metadataValue(ctx, MetadataRequestID)
+		if requestID == "" {
+			requestID = newRequestID()
+			md, _ := metadata.FromIncomingContext(ctx)
+			md = md.Copy()
+			md.Set(MetadataRequestID, requestID)
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+
+		l := logger.With().Str("request_id", requestID).Logger()
+		ctx = l.WithContext(ctx)
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		event := zerolog.Ctx(ctx).Info()
+		if code == codes.Internal || code == codes.Unknown || code == codes.Unavailable {
+			event = zerolog.Ctx(ctx).Error().Err(err)
+		}
+		event.
+			Str("method", info.FullMethod).
+			Str("code", code.String()).
+			Float64("latency_ms", float64(time.Since(start).Microseconds())/1000).
+			Msg("grpc call")
+		return resp, err
+	}
+}
+
+// UnaryRateLimitInterceptor limits the calls in the buckets of the store
+// like MiddlewareRateLimitHandle does for the http routes of the same scope.
+// It must follow UnaryAuthInterceptor to take the user into account.
+func UnaryRateLimitInterceptor(store ratelimit.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		scope, ok := methodScopes[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		limit := ratelimit.ScopeLimit(scope)
+		if !limit.Enabled() {
+			return handler(ctx, req)
+		}
+
+		user := ""
+		if id := userID(ctx); id != 0 {
+			user = strconv.FormatUint(uint64(id), 10)
+		}
+		keys := ratelimit.Keys(metadataValue(ctx, MetadataAPIKey), peerIP(ctx), user)
+		for i := range keys {
+			keys[i] = scope + ":" + keys[i]
+		}
+		res, err := ratelimit.TakeAll(ctx, store, keys, limit)
+		if err != nil {
+			// the limiter must not take the service down with its store.
+			zerolog.Ctx(ctx).Error().Err(err).Str("scope", scope).Msg("rate limiter is unavailable")
+			return handler(ctx, req)
+		}
+
+		if !res.Allowed {
+			retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			grpc.SetHeader(ctx, metadata.Pairs(MetadataRetryAfter, strconv.Itoa(retryAfter)))
+			return nil, status.Error(codes.ResourceExhausted, "too many requests")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// UnaryMetricsInterceptor counts the calls and observes their duration
+// by method and status code like MiddlewareMetricsHandle.
+func UnaryMetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err).String()
+	metrics.GRPCRequests.WithLabelValues(info.FullMethod, code).Inc()
+	metrics.GRPCRequestDuration.WithLabelValues(info.FullMethod, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// Returns the ip of the peer of the call without the port.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
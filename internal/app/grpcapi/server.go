@@ -0,0 +1,225 @@
+// Package grpcapi serves the shortener over gRPC, see pb/shortener.proto.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/shortener.proto
+
+import (
+	"context"
+	"errors"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/deletion"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/domains"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/grpcapi/pb"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/policy"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Server implements pb.ShortenerServer over the same repo as the http api.
+type Server struct {
+	pb.UnimplementedShortenerServer
+	repo storage.ShortURLRepo
+}
+
+// Returns a pointer to Server over the repo.
+func NewServer(repo storage.ShortURLRepo) *Server {
+	return &Server{
+		repo: repo,
+	}
+}
+
+// NewGRPCServer returns the grpc server with the Shortener service registered,
+// the calls are logged, measured, recovered from panics, authenticated, see UnaryAuthInterceptor,
+// and rate limited in the buckets of the limiter.
+func NewGRPCServer(repo storage.ShortURLRepo, limiter ratelimit.Store, logger zerolog.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		UnaryLoggingInterceptor(logger),
+		UnaryMetricsInterceptor,
+		UnaryRecoveryInterceptor,
+		UnaryAuthInterceptor,
+		UnaryRateLimitInterceptor(limiter),
+	))
+	s := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(s, NewServer(storage.Traced(repo)))
+	return s
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if !valid.IsURL(req.Url) {
+		return nil, status.Error(codes.InvalidArgument, "incorrect link")
+	}
+	link, err := policy.NewPolicy().Check(req.Url)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	domain, err := domains.Get().Lookup(req.Domain)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	shortURL := storage.ShortURL{
+		InitialLink: link,
+		UserID:      userID(ctx),
+		Domain:      domain,
+	}
+	shortened, err := s.repo.CreateShortURL(ctx, &shortURL)
+	if err != nil && !errors.Is(err, utils.ErrUniqueLink) {
+		return nil, statusError(ctx, err)
+	}
+
+	return &pb.ShortenResponse{
+		ShortUrl: domains.Get().ShortURL(domain, shortened),
+		Existed:  errors.Is(err, utils.ErrUniqueLink),
+	}, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	urlPolicy := policy.NewPolicy()
+	links := make([]storage.ShortURLByUser, len(req.Urls))
+	for i, url := range req.Urls {
+		if !valid.IsURL(url.OriginalUrl) {
+			return nil, status.Errorf(codes.InvalidArgument, "incorrect link: %s", url.CorrelationId)
+		}
+		link, err := urlPolicy.Check(url.OriginalUrl)
+		if err != nil {
+			return nil, statusError(ctx, err)
+		}
+		domain, err := domains.Get().Lookup(url.Domain)
+		if err != nil {
+			return nil, statusError(ctx, err)
+		}
+		links[i] = storage.ShortURLByUser{
+			InitialLink:   link,
+			CorrelationID: url.CorrelationId,
+			Domain:        domain,
+		}
+	}
+
+	res, err := s.repo.CreateListShortURL(ctx, links)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	resp := &pb.ShortenBatchResponse{
+		Urls: make([]*pb.BatchShortURL, len(res)),
+	}
+	for i, link := range res {
+		resp.Urls[i] = &pb.BatchShortURL{
+			CorrelationId: link.CorrelationID,
+			ShortUrl:      link.ShortLink,
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	if req.ShortUrl == "" {
+		return nil, status.Error(codes.InvalidArgument, "short url was not sent")
+	}
+
+	domain, err := domains.Get().Lookup(req.Domain)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	link, err := s.repo.GetInitialLink(ctx, domain, req.ShortUrl)
+	var blocked *utils.BlockedLinkError
+	if errors.As(err, &blocked) {
+		return &pb.ResolveResponse{OriginalUrl: blocked.Link, Blocked: true}, nil
+	}
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	return &pb.ResolveResponse{OriginalUrl: link}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	res, err := s.repo.GetAllShortURLUser(ctx, userID(ctx))
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	resp := &pb.ListUserURLsResponse{
+		Urls: make([]*pb.UserURL, len(res)),
+	}
+	for i, link := range res {
+		resp.Urls[i] = &pb.UserURL{
+			ShortUrl:    link.ShortLink,
+			OriginalUrl: link.InitialLink,
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	domain, err := domains.Get().Lookup(req.Domain)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	id := userID(ctx)
+	res, err := s.repo.CheckURLsCreatedByUser(ctx, domain, req.ShortUrls, id)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	if len(res) != 0 {
+		return nil, statusError(ctx, utils.NewNotOwnerError(res))
+	}
+
+	deletion.InBackground(ctx, s.repo, domain, req.ShortUrls, id)
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+func (s *Server) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.repo.PingDB(ctx); err != nil {
+		return nil, statusError(ctx, utils.NewUnavailableError(err))
+	}
+	return &pb.PingResponse{}, nil
+}
+
+// The status codes of the kinds of errors like the http statuses
+// of the http api, an error of no kind is internal.
+var kindCodes = map[error]codes.Code{
+	utils.ErrNotFound:    codes.NotFound,
+	utils.ErrConflict:    codes.AlreadyExists,
+	utils.ErrForbidden:   codes.PermissionDenied,
+	utils.ErrGone:        codes.NotFound,
+	utils.ErrValidation:  codes.InvalidArgument,
+	utils.ErrUnavailable: codes.Unavailable,
+}
+
+// Returns the status of the error by its kind, see kindCodes.
+// The internal errors are logged and not sent to the client.
+func statusError(ctx context.Context, err error) error {
+	var violation *utils.PolicyViolationError
+	var blocked *utils.BlockedLinkError
+	switch {
+	case errors.As(err, &violation):
+		return status.Errorf(codes.InvalidArgument, "%v: %s: %s", violation.Err, violation.Rule, violation.Reason)
+	case errors.As(err, &blocked):
+		return status.Errorf(codes.InvalidArgument, "%v: matched by %s of %s", blocked.Err, blocked.Entry, blocked.List)
+	}
+
+	code, ok := kindCodes[utils.Kind(err)]
+	switch {
+	case !ok:
+		return internalError(ctx, err)
+	case code == codes.Unavailable:
+		return status.Error(codes.Unavailable, "the service is temporarily unavailable, try again later")
+	}
+	return status.Error(code, err.Error())
+}
+
+// Logs the error and returns the Internal status without its details.
+func internalError(ctx context.Context, err error) error {
+	zerolog.Ctx(ctx).Error().Err(err).Msg("call failed")
+	return status.Error(codes.Internal, "internal error")
+}
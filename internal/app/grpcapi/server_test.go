@@ -0,0 +1,305 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/grpcapi/pb"
+	mocks "github.com/GorunovAlx/shortening_long_url/internal/app/mocks"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Starts the server over the repo on a bufconn listener and returns the client.
+func newTestClient(t *testing.T, repo storage.ShortURLRepo) pb.ShortenerClient {
+	old := *configs.Get()
+	cfg := old
+	cfg.BaseURL = "http://short.test"
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewGRPCServer(repo, ratelimit.NewInMemoryStore(), zerolog.Nop())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+// Returns the context authenticated as a new user and the id of the user.
+func userContext(t *testing.T) (context.Context, uint32) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), MetadataUserID, token), id
+}
+
+func TestShorten(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockShortURLRepo(ctrl)
+	client := newTestClient(t, repo)
+
+	t.Run("new user gets a token", func(t *testing.T) {
+		repo.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).Return("abc", nil)
+
+		var header metadata.MD
+		resp, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://example.com/page"}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, "http://short.test/abc", resp.ShortUrl)
+		assert.False(t, resp.Existed)
+
+		require.Len(t, header.Get(MetadataUserID), 1)
+		isAuthentic, err := gen.AuthUserIDToken(header.Get(MetadataUserID)[0])
+		require.NoError(t, err)
+		assert.True(t, isAuthentic)
+	})
+
+	t.Run("existing link of the user", func(t *testing.T) {
+		ctx, id := userContext(t)
+		repo.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, shortURL *storage.ShortURL) (string, error) {
+				assert.Equal(t, id, shortURL.UserID)
+				assert.Equal(t, "https://example.com/page", shortURL.InitialLink)
+				return "abc", utils.ErrUniqueLink
+			})
+
+		var header metadata.MD
+		resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/page"}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, "http://short.test/abc", resp.ShortUrl)
+		assert.True(t, resp.Existed)
+		assert.Empty(t, header.Get(MetadataUserID))
+	})
+
+	t.Run("incorrect link", func(t *testing.T) {
+		_, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "not a link"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("link rejected by the policy", func(t *testing.T) {
+		_, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "ftp://example.com/file"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "scheme")
+	})
+
+	t.Run("internal error is not sent", func(t *testing.T) {
+		repo.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).Return("", errors.New("connection to 10.0.0.5 refused"))
+
+		_, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://example.com/page"})
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Equal(t, "internal error", status.Convert(err).Message())
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockShortURLRepo(ctrl)
+	client := newTestClient(t, repo)
+
+	cfg := *configs.Get()
+	cfg.RateLimitCreateRPS = 0.001
+	cfg.RateLimitCreateBurst = 1
+	configs.Set(cfg)
+
+	repo.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).Return("abc", nil)
+	_, err := client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://example.com/page"})
+	require.NoError(t, err)
+
+	// a new user does not get a new bucket, the peer is limited by its ip.
+	var header metadata.MD
+	_, err = client.Shorten(context.Background(), &pb.ShortenRequest{Url: "https://example.com/page"}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NotEmpty(t, header.Get(MetadataRetryAfter))
+
+	// the methods out of the create scope are not limited by its bucket.
+	repo.EXPECT().PingDB(gomock.Any()).Return(nil)
+	_, err = client.Ping(context.Background(), &pb.PingRequest{})
+	require.NoError(t, err)
+}
+
+func TestShortenBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockShortURLRepo(ctrl)
+	client := newTestClient(t, repo)
+
+	repo.EXPECT().CreateListShortURL(gomock.Any(), []storage.ShortURLByUser{
+		{CorrelationID: "1", InitialLink: "https://example.com/a"},
+		{CorrelationID: "2", InitialLink: "https://example.com/b"},
+	}).Return([]storage.ShortURLByUser{
+		{CorrelationID: "1", ShortLink: "http://short.test/a"},
+		{CorrelationID: "2", ShortLink: "http://short.test/b"},
+	}, nil)
+
+	resp, err := client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Urls: []*pb.BatchURL{
+		{CorrelationId: "1", OriginalUrl: "https://example.com/a"},
+		{CorrelationId: "2", OriginalUrl: "https://example.com/b"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.Urls, 2)
+	assert.Equal(t, "2", resp.Urls[1].CorrelationId)
+	assert.Equal(t, "http://short.test/b", resp.Urls[1].ShortUrl)
+
+	_, err = client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Urls: []*pb.BatchURL{
+		{CorrelationId: "1", OriginalUrl: "https://example.com/a"},
+		{CorrelationId: "2", OriginalUrl: "not a link"},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestResolve(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockShortURLRepo(ctrl)
+	client := newTestClient(t, repo)
+
+	tests := []struct {
+		name    string
+		link    string
+		err     error
+		want    *pb.ResolveResponse
+		wantErr codes.Code
+	}{
+		{
+			name: "link",
+			link: "https://example.com",
+			want: &pb.ResolveResponse{OriginalUrl: "https://example.com"},
+		},
+		{
+			name:    "deleted link",
+			err:     utils.ErrDeletedLink,
+			wantErr: codes.NotFound,
+		},
+		{
+			name:    "disabled link",
+			err:     utils.NewDisabledLinkError("abc", "abuse", 451),
+			wantErr: codes.NotFound,
+		},
+		{
+			name: "blocked link",
+			err:  utils.NewBlockedLinkError("https://evil.test", "malware.txt", "evil.test"),
+			want: &pb.ResolveResponse{OriginalUrl: "https://evil.test", Blocked: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo.EXPECT().GetInitialLink(gomock.Any(), "", "abc").Return(tt.link, tt.err)
+
+			resp, err := client.Resolve(context.Background(), &pb.ResolveRequest{ShortUrl: "abc"})
+			if tt.wantErr != codes.OK {
+				assert.Equal(t, tt.wantErr, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.OriginalUrl, resp.OriginalUrl)
+			assert.Equal(t, tt.want.Blocked, resp.Blocked)
+		})
+	}
+}
+
+func TestListAndDeleteUserURLs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockShortURLRepo(ctrl)
+	client := newTestClient(t, repo)
+	ctx, id := userContext(t)
+
+	repo.EXPECT().GetAllShortURLUser(gomock.Any(), id).Return([]storage.ShortURLByUser{
+		{ShortLink: "http://short.test/abc", InitialLink: "https://example.com"},
+	}, nil)
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Urls, 1)
+	assert.Equal(t, "https://example.com", list.Urls[0].OriginalUrl)
+
+	repo.EXPECT().CheckURLsCreatedByUser(gomock.Any(), "", []string{"xyz"}, id).Return([]string{"xyz"}, nil)
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{ShortUrls: []string{"xyz"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	deleted := make(chan string, 1)
+	repo.EXPECT().CheckURLsCreatedByUser(gomock.Any(), "", []string{"abc"}, id).Return(nil, nil)
+	repo.EXPECT().DeleteShortURLUser(gomock.Any(), "", "abc", id).DoAndReturn(
+		func(ctx context.Context, domain, link string, id uint32) error {
+			deleted <- link
+			return nil
+		})
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{ShortUrls: []string{"abc"}})
+	require.NoError(t, err)
+	select {
+	case link := <-deleted:
+		assert.Equal(t, "abc", link)
+	case <-time.After(time.Second):
+		t.Fatal("the link is not deleted")
+	}
+}
+
+func TestAuthInterceptorClientIP(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 52044},
+	})
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &headerStream{})
+
+	var actor audit.Actor
+	_, err := UnaryAuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		actor = audit.ActorFromContext(ctx)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", actor.ClientIP)
+	assert.NotZero(t, actor.UserID)
+}
+
+// headerStream accepts the header metadata set by the interceptors called without a server.
+type headerStream struct {
+	grpc.ServerTransportStream
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	return nil
+}
+
+func TestPingAndRecovery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockShortURLRepo(ctrl)
+	client := newTestClient(t, repo)
+
+	repo.EXPECT().PingDB(gomock.Any()).Return(nil)
+	_, err := client.Ping(context.Background(), &pb.PingRequest{})
+	require.NoError(t, err)
+
+	repo.EXPECT().PingDB(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+		panic("storage is broken")
+	})
+	_, err = client.Ping(context.Background(), &pb.PingRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	// the server keeps serving after the panic.
+	repo.EXPECT().PingDB(gomock.Any()).Return(nil)
+	_, err = client.Ping(context.Background(), &pb.PingRequest{})
+	require.NoError(t, err)
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/domains"
+	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/tracing"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+const defaultAuditLimit = 100
+
+// ModerationRequest contains the reason a link is disabled
+// and the status it is served with, 451 by default.
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+	Status int    `json:"status,omitempty"`
+}
+
+// OwnerRequest contains the id of the new owner of a link.
+type OwnerRequest struct {
+	UserID uint32 `json:"user_id"`
+}
+
+// Returns a router with the endpoints available to admins only:
+// Get /urls searches links of all users by destination, owner, code or domain;
+// Post /urls/{shortURL}/disable disables the link with a moderation reason;
+// Post /urls/{shortURL}/enable enables the disabled link;
+// Put /urls/{shortURL}/owner reassigns the link to another user;
+// Get /audit returns entries of the audit trail.
+// The links of the other domains than the default one are given by the domain query parameter.
+func NewAdminRouter(repo storage.ShortURLRepo) chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(MiddlewareAdminHandle)
+
+	r.Get("/urls", tracing.HandlerFunc("SearchShortURLHandler", SearchShortURLHandler(repo)))
+	r.Post("/urls/{shortURL}/disable", tracing.HandlerFunc("DisableShortURLHandler", DisableShortURLHandler(repo)))
+	r.Post("/urls/{shortURL}/enable", tracing.HandlerFunc("EnableShortURLHandler", EnableShortURLHandler(repo)))
+	r.Put("/urls/{shortURL}/owner", tracing.HandlerFunc("ReassignShortURLHandler", ReassignShortURLHandler(repo)))
+	r.Get("/audit", tracing.HandlerFunc("GetAuditEntriesHandler", GetAuditEntriesHandler(repo)))
+
+	return r
+}
+
+// SearchShortURLHandler returns links of all users matching
+// the destination, owner, code and domain query parameters.
+func SearchShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := storage.ShortURLFilter{
+			InitialLink: query.Get("destination"),
+			ShortLink:   query.Get("code"),
+		}
+		if owner := query.Get("owner"); owner != "" {
+			id, err := strconv.ParseUint(owner, 10, 32)
+			if err != nil {
+				writeError(w, r, utils.NewValidationError("Incorrect owner"))
+				return
+			}
+			userID := uint32(id)
+			filter.UserID = &userID
+		}
+		if name := query.Get("domain"); name != "" {
+			domain, err := domains.Get().Lookup(name)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			filter.Domain = &domain
+		}
+
+		res, err := urlStorage.FindShortURLs(r.Context(), filter)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if res == nil {
+			res = []storage.ShortURL{}
+		}
+		resp, err := json.Marshal(res)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+// DisableShortURLHandler disables the link, after that it is served
+// with the status 451 or 410 and the moderation reason.
+func DisableShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ModerationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, utils.NewValidationError(err.Error()))
+			return
+		}
+
+		if req.Status == 0 {
+			req.Status = http.StatusUnavailableForLegalReasons
+		}
+		if req.Status != http.StatusUnavailableForLegalReasons && req.Status != http.StatusGone {
+			writeError(w, r, utils.NewValidationError("status must be 451 or 410"))
+			return
+		}
+		if req.Reason == "" {
+			writeError(w, r, utils.NewValidationError("reason was not sent"))
+			return
+		}
+
+		moderation := storage.Moderation{
+			Disabled: true,
+			Reason:   req.Reason,
+			Status:   req.Status,
+		}
+		moderateShortURL(w, r, urlStorage, moderation)
+	}
+}
+
+// EnableShortURLHandler enables the link disabled by moderation.
+func EnableShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		moderateShortURL(w, r, urlStorage, storage.Moderation{})
+	}
+}
+
+// ReassignShortURLHandler transfers the link to the user from the request body.
+func ReassignShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OwnerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, utils.NewValidationError(err.Error()))
+			return
+		}
+
+		domain, err := queryDomain(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		err = urlStorage.ReassignShortURL(r.Context(), domain, chi.URLParam(r, "shortURL"), req.UserID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetAuditEntriesHandler returns the last entries of the audit trail
+// matching the actor, action, short_url and limit query parameters.
+func GetAuditEntriesHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := audit.Filter{
+			Action:   query.Get("action"),
+			ShortURL: query.Get("short_url"),
+			Limit:    defaultAuditLimit,
+		}
+		if actor := query.Get("actor"); actor != "" {
+			id, err := strconv.ParseUint(actor, 10, 32)
+			if err != nil {
+				writeError(w, r, utils.NewValidationError("Incorrect actor"))
+				return
+			}
+			userID := uint32(id)
+			filter.Actor = &userID
+		}
+		if limit := query.Get("limit"); limit != "" {
+			l, err := strconv.Atoi(limit)
+			if err != nil || l <= 0 {
+				writeError(w, r, utils.NewValidationError("Incorrect limit"))
+				return
+			}
+			filter.Limit = l
+		}
+
+		res, err := urlStorage.FindAuditEntries(r.Context(), filter)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if res == nil {
+			res = []audit.Entry{}
+		}
+		resp, err := json.Marshal(res)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+func moderateShortURL(w http.ResponseWriter, r *http.Request, urlStorage storage.ShortURLRepo, m storage.Moderation) {
+	domain, err := queryDomain(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = urlStorage.ModerateShortURL(r.Context(), domain, chi.URLParam(r, "shortURL"), m)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the domain of the domain query parameter, the default domain without it.
+func queryDomain(r *http.Request) (string, error) {
+	return domains.Get().Lookup(r.URL.Query().Get("domain"))
+}
+
+// Returns the id of the user authenticated by MiddlewareAuthUserHandle.
+func getUserID(r *http.Request) (uint32, error) {
+	token := r.Context().Value(contextKeyRequestID).(string)
+	return gen.GetUserID(token)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	mocks "github.com/GorunovAlx/shortening_long_url/internal/app/mocks"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The admin token of the tests, see setAdmins.
+const testAdminToken = "test-admin-token-0123456789"
+
+// Test admin request execution with the user_id cookie and the admin token.
+func testAdminRequest(t *testing.T, ts *httptest.Server, method, path, body, token string) *http.Response {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: token})
+	req.Header.Set("X-Admin-Token", testAdminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestMiddlewareAdminHandle(t *testing.T) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		admins     []uint32
+		adminToken string
+		statusCode int
+	}{
+		{
+			name:       "user without admin role",
+			admins:     nil,
+			adminToken: testAdminToken,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "user with admin role",
+			admins:     []uint32{id},
+			adminToken: testAdminToken,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "user with admin role without admin token",
+			admins:     []uint32{id},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "user with admin role with wrong admin token",
+			admins:     []uint32{id},
+			adminToken: "wrong",
+			statusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setAdmins(t, tt.admins...)
+
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			request := httptest.NewRequest(http.MethodGet, "/api/admin/urls", nil)
+			if tt.adminToken != "" {
+				request.Header.Set("X-Admin-Token", tt.adminToken)
+			}
+			ctx := context.WithValue(request.Context(), contextKeyRequestID, token)
+			w := httptest.NewRecorder()
+			MiddlewareAdminHandle(nextHandler).ServeHTTP(w, request.WithContext(ctx))
+			result := w.Result()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			require.NoError(t, result.Body.Close())
+		})
+	}
+}
+
+func TestSearchShortURLHandler(t *testing.T) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+	setAdmins(t, id)
+
+	owner := uint32(42)
+	found := []storage.ShortURL{
+		{
+			InitialLink: "https://example.com/phishing",
+			ShortLink:   "abc",
+			UserID:      owner,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+	mockStorage.EXPECT().FindShortURLs(gomock.Any(), storage.ShortURLFilter{
+		InitialLink: "example.com",
+		UserID:      &owner,
+	}).Return(found, nil)
+
+	ts := httptest.NewServer(NewRouter(mockStorage))
+	defer ts.Close()
+
+	result := testAdminRequest(t, ts, http.MethodGet, "/api/admin/urls?destination=example.com&owner=42", "", token)
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	var links []storage.ShortURL
+	body, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(body, &links))
+	assert.Equal(t, found, links)
+}
+
+func TestDisableShortURLHandler(t *testing.T) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+	setAdmins(t, id)
+
+	tests := []struct {
+		name       string
+		body       string
+		moderation *storage.Moderation
+		err        error
+		statusCode int
+	}{
+		{
+			name: "disable with default status",
+			body: `{"reason":"phishing"}`,
+			moderation: &storage.Moderation{
+				Disabled: true,
+				Reason:   "phishing",
+				Status:   http.StatusUnavailableForLegalReasons,
+			},
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "disable with wrong status",
+			body:       `{"reason":"phishing","status":404}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name: "disable missing link",
+			body: `{"reason":"spam","status":410}`,
+			moderation: &storage.Moderation{
+				Disabled: true,
+				Reason:   "spam",
+				Status:   http.StatusGone,
+			},
+			err:        utils.ErrLinkNotFound,
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockStorage := mocks.NewMockShortURLRepo(ctrl)
+			if tt.moderation != nil {
+				mockStorage.EXPECT().ModerateShortURL(gomock.Any(), "", "abc", *tt.moderation).Return(tt.err)
+			}
+
+			ts := httptest.NewServer(NewRouter(mockStorage))
+			defer ts.Close()
+
+			result := testAdminRequest(t, ts, http.MethodPost, "/api/admin/urls/abc/disable", tt.body, token)
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+}
+
+func TestGetInitialLinkHandlerDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+	mockStorage.EXPECT().GetInitialLink(gomock.Any(), "", "abc").
+		Return("", utils.NewDisabledLinkError("abc", "phishing", http.StatusUnavailableForLegalReasons))
+
+	ts := httptest.NewServer(NewRouter(mockStorage))
+	defer ts.Close()
+
+	result := testRequest(t, ts, http.MethodGet, "/abc", nil)
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, result.StatusCode)
+	assert.Equal(t, "", result.Header.Get("Location"))
+	assert.Equal(t, "application/problem+json", result.Header.Get("Content-Type"))
+	var problem Problem
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&problem))
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, problem.Status)
+	assert.Equal(t, "phishing", problem.Detail)
+}
+
+// Sets the admins in the current config until the end of the test.
+func setAdmins(t *testing.T, ids ...uint32) {
+	old := *configs.Get()
+	cfg := old
+	cfg.AdminUserIDs = ids
+	cfg.AdminToken = testAdminToken
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+}
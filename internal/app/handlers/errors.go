@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Problem is an RFC 7807 problem details response.
+type Problem struct {
+	Type     string   `json:"type"`
+	Title    string   `json:"title"`
+	Status   int      `json:"status"`
+	Detail   string   `json:"detail,omitempty"`
+	Instance string   `json:"instance,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// The http statuses of the kinds of errors, an error of no kind is internal.
+var kindStatuses = map[error]int{
+	utils.ErrNotFound:    http.StatusNotFound,
+	utils.ErrConflict:    http.StatusConflict,
+	utils.ErrForbidden:   http.StatusForbidden,
+	utils.ErrGone:        http.StatusGone,
+	utils.ErrValidation:  http.StatusBadRequest,
+	utils.ErrUnavailable: http.StatusServiceUnavailable,
+}
+
+// Returns the http status of the kind of err.
+func errorStatus(err error) int {
+	if status, ok := kindStatuses[utils.Kind(err)]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Writes err as problem+json with the status of its kind, a link rejected
+// by the url policy is written by writePolicyViolation. The unavailable and internal
+// errors are logged, their causes are not sent to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var violation *utils.PolicyViolationError
+	var blocked *utils.BlockedLinkError
+	if errors.As(err, &violation) || errors.As(err, &blocked) {
+		writePolicyViolation(w, r, err)
+		return
+	}
+
+	status := errorStatus(err)
+	problem := Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+	}
+	switch status {
+	case http.StatusServiceUnavailable:
+		problem.Detail = "the service is temporarily unavailable, try again later"
+	case http.StatusInternalServerError:
+		problem.Detail = "internal error"
+	}
+	if status >= http.StatusInternalServerError {
+		zerolog.Ctx(r.Context()).Error().Err(err).Int("status", status).Msg("request failed")
+	}
+
+	writeProblem(w, problem)
+}
+
+// Writes the problem as application/problem+json, the type defaults to about:blank.
+func writeProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -3,7 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"html/template"
 	"io/ioutil"
 
 	"io"
@@ -13,70 +13,177 @@ import (
 	valid "github.com/asaskevich/govalidator"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
 
 	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/deletion"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/domains"
 	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/policy"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/tracing"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
 )
 
+// RouterOption configures the router returned by NewRouter.
+type RouterOption func(*routerOptions)
+
+type routerOptions struct {
+	rateLimitStore ratelimit.Store
+	logger         zerolog.Logger
+}
+
+// WithLogger sets the logger of the access log and the requests,
+// utils.Logger is used by default.
+func WithLogger(logger zerolog.Logger) RouterOption {
+	return func(o *routerOptions) {
+		o.logger = logger
+	}
+}
+
+// WithRateLimitStore sets the store of the rate limiter buckets,
+// the in memory store is used by default.
+func WithRateLimitStore(store ratelimit.Store) RouterOption {
+	return func(o *routerOptions) {
+		o.rateLimitStore = store
+	}
+}
+
 // Returns a pointer to a chi.Mux with endpoints:
-// Get /{shortURL} returns the initial link from storage by shortened link.
+// Get /{shortURL} returns the initial link from storage by shortened link
+// of the domain the request is sent to.
 // Post / sends initial link in the body and get shortened link in the response body.
+// The links are created in the domain of the domain parameter or of the host
+// the request is sent to, see requestDomain.
 // Post /api/shorten sends json with initial link in the body
 // and get json with shortened link in the response body.
-func NewRouter(repo storage.ShortURLRepo) *chi.Mux {
+// /api/admin/* moderates links of all users, see NewAdminRouter.
+// Get /healthz and Get /readyz report liveness and readiness, see GetReadinessHandler.
+// Get /metrics exposes prometheus metrics unless they are served on a separate address.
+// Get /api/openapi.json describes the routes, the requests not matching it are rejected.
+// Creating, redirecting and deleting are rate limited per client.
+// Requests, handlers and storage calls are traced.
+// The responses served over https have the HSTS header if it is configured.
+func NewRouter(repo storage.ShortURLRepo, opts ...RouterOption) *chi.Mux {
+	repo = storage.Traced(repo)
+	o := routerOptions{
+		rateLimitStore: ratelimit.NewInMemoryStore(),
+		logger:         utils.Logger,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	limit := func(scope string) func(http.Handler) http.Handler {
+		return MiddlewareRateLimitHandle(o.rateLimitStore, scope, func() ratelimit.Limit {
+			return ratelimit.ScopeLimit(scope)
+		})
+	}
+	createLimit := limit(ratelimit.ScopeCreate)
+	redirectLimit := limit(ratelimit.ScopeRedirect)
+	deleteLimit := limit(ratelimit.ScopeDelete)
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(MiddlewareTracingHandle)
+	r.Use(MiddlewareMetricsHandle)
+	r.Use(MiddlewareAccessLogHandle(o.logger))
 	r.Use(middleware.Recoverer)
+	r.Use(MiddlewareHSTSHandle)
 	r.Use(middleware.Timeout(60 * time.Second))
 
 	r.Use(MiddlewareGzipWriterHandle)
 	r.Use(MiddlewareGzipReaderHandle)
 	r.Use(MiddlewareAuthUserHandle)
-
-	r.Get("/{shortURL}", GetInitialLinkHandler(repo))
-	r.Get("/api/user/urls", GetAllShortURLUserHandler(repo))
-	r.Get("/ping", GetPingToDBHandle(repo))
-	r.Post("/", CreateShortURLHandler(repo))
-	r.Post("/api/shorten", CreateShortURLJSONHandler(repo))
-	r.Post("/api/shorten/batch", CreateListShortURLHandler(repo))
-	r.Delete("/api/user/urls", DeleteListURLHandler(repo))
+	r.Use(MiddlewareValidateRequestHandle)
+
+	r.With(redirectLimit).Get("/{shortURL}", tracing.HandlerFunc("GetInitialLinkHandler", GetInitialLinkHandler(repo)))
+	r.Get("/api/user/urls", tracing.HandlerFunc("GetAllShortURLUserHandler", GetAllShortURLUserHandler(repo)))
+	r.Get("/ping", tracing.HandlerFunc("GetPingToDBHandle", GetPingToDBHandle(repo)))
+	r.Get("/api/openapi.json", GetOpenAPIHandler())
+	r.Get("/healthz", GetLivenessHandler())
+	r.Get("/readyz", GetReadinessHandler(repo, healthComponents(o)...))
+	r.With(createLimit).Post("/", tracing.HandlerFunc("CreateShortURLHandler", CreateShortURLHandler(repo)))
+	r.With(createLimit).Post("/api/shorten", tracing.HandlerFunc("CreateShortURLJSONHandler", CreateShortURLJSONHandler(repo)))
+	r.With(createLimit).Post("/api/shorten/batch", tracing.HandlerFunc("CreateListShortURLHandler", CreateListShortURLHandler(repo)))
+	r.With(deleteLimit).Delete("/api/user/urls", tracing.HandlerFunc("DeleteListURLHandler", DeleteListURLHandler(repo)))
+	r.Mount("/api/admin", NewAdminRouter(repo))
+	if configs.Get().MetricsAddress == "" {
+		r.Method(http.MethodGet, "/metrics", metrics.Handler())
+	}
 
 	return r
 }
 
+// Returns the components the readiness of the service depends on besides
+// the storage: the deletion queue and the rate limiter store if it can be checked.
+func healthComponents(o routerOptions) []health.Component {
+	components := []health.Component{
+		{Name: "deletion_queue", Checker: health.CheckerFunc(deletion.CheckQueue)},
+	}
+	if checker, ok := o.rateLimitStore.(health.Checker); ok {
+		components = append(components, health.Component{Name: "rate_limit_store", Checker: checker})
+	}
+	return components
+}
+
+// ShortenRequest contains the link to shorten and the domain it is shortened in,
+// the other fields of the link are never taken from the client.
+type ShortenRequest struct {
+	URL    string `json:"url"`
+	Domain string `json:"domain,omitempty"`
+}
+
 // Post a json with an initial link in the request and returns a json
 // with a shortened link in the response.
 func CreateShortURLJSONHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var url storage.ShortURL
-		if err := json.NewDecoder(r.Body).Decode(&url); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		var req ShortenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, utils.NewValidationError(err.Error()))
 			return
 		}
+		url := storage.ShortURL{
+			InitialLink: req.URL,
+			Domain:      req.Domain,
+		}
 
 		isURL := valid.IsURL(url.InitialLink)
 		if !isURL {
-			http.Error(w, "Incorrect link", http.StatusBadRequest)
+			writeError(w, r, utils.NewValidationError("Incorrect link"))
+			return
+		}
+
+		link, err := policy.NewPolicy().Check(url.InitialLink)
+		if err != nil {
+			writePolicyViolation(w, r, err)
+			return
+		}
+		url.InitialLink = link
+
+		url.Domain, err = requestDomain(r, url.Domain)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
 		token := r.Context().Value(contextKeyRequestID).(string)
 		id, err := gen.GetUserID(token)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 		url.UserID = id
 
-		shortURL, err := urlStorage.CreateShortURL(&url)
-		shortURL = configs.Cfg.BaseURL + "/" + shortURL
+		shortURL, err := urlStorage.CreateShortURL(r.Context(), &url)
+		shortURL = domains.Get().ShortURL(url.Domain, shortURL)
 		if err != nil && err != utils.ErrUniqueLink {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 
@@ -92,7 +199,7 @@ func CreateShortURLJSONHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc
 		}
 		resp, e := json.Marshal(res)
 		if e != nil {
-			http.Error(w, e.Error(), http.StatusBadRequest)
+			writeError(w, r, e)
 			return
 		}
 
@@ -105,35 +212,49 @@ func CreateShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		b, err := io.ReadAll(r.Body)
 		if err != nil || len(b) == 0 {
-			http.Error(w, "Incorrect request", http.StatusBadRequest)
+			writeError(w, r, utils.NewValidationError("Incorrect request"))
 			return
 		}
 
 		isURL := valid.IsURL(string(b))
 		if !isURL {
-			http.Error(w, "Incorrect link", http.StatusBadRequest)
+			writeError(w, r, utils.NewValidationError("Incorrect link"))
+			return
+		}
+
+		link, err := policy.NewPolicy().Check(string(b))
+		if err != nil {
+			writePolicyViolation(w, r, err)
+			return
+		}
+
+		domain, err := requestDomain(r, "")
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
 		token := r.Context().Value(contextKeyRequestID).(string)
 		id, err := gen.GetUserID(token)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
 		shortURL := storage.ShortURL{
-			InitialLink: string(b),
+			InitialLink: link,
 			UserID:      id,
+			Domain:      domain,
 		}
 
-		shortened, err := urlStorage.CreateShortURL(&shortURL)
-		shortened = configs.Cfg.BaseURL + "/" + shortened
+		shortened, err := urlStorage.CreateShortURL(r.Context(), &shortURL)
+		shortened = domains.Get().ShortURL(domain, shortened)
 		if err != nil && err != utils.ErrUniqueLink {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 
+		w.Header().Set("Content-type", "text/plain; charset=utf-8")
 		if errors.Is(err, utils.ErrUniqueLink) {
 			w.WriteHeader(http.StatusConflict)
 		} else {
@@ -146,25 +267,46 @@ func CreateShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 
 // GetInitialLinkHandler returns a http.HandlerFunc that takes shortURL parameter
 // containing a short url and returns the initial link in the location header.
+// The short url is looked up in the domain of the Host header.
 func GetInitialLinkHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		shortURL := chi.URLParam(r, "shortURL")
 		if shortURL == "" {
-			http.Error(w, "short url was not sent", http.StatusBadRequest)
+			writeError(w, r, utils.NewValidationError("short url was not sent"))
 			return
 		}
 
-		link, err := urlStorage.GetInitialLink(shortURL)
-		if err != nil && err != utils.ErrDeletedLink {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		link, err := urlStorage.GetInitialLink(r.Context(), domains.Get().Resolve(r.Host), shortURL)
+		var disabled *utils.DisabledLinkError
+		if errors.As(err, &disabled) {
+			metrics.RedirectHits.WithLabelValues("disabled").Inc()
+			writeProblem(w, Problem{
+				Title:  http.StatusText(disabled.Status),
+				Status: disabled.Status,
+				Detail: disabled.Reason,
+			})
+			return
+		}
+		var blocked *utils.BlockedLinkError
+		if errors.As(err, &blocked) {
+			metrics.RedirectHits.WithLabelValues("blocked").Inc()
+			writeInterstitial(w, blocked)
 			return
 		}
-
 		if errors.Is(err, utils.ErrDeletedLink) {
-			w.WriteHeader(http.StatusGone)
+			metrics.RedirectHits.WithLabelValues("deleted").Inc()
+			writeError(w, r, err)
+			return
+		}
+		if err != nil {
+			if errors.Is(err, utils.ErrNotFound) {
+				metrics.RedirectHits.WithLabelValues("not_found").Inc()
+			}
+			writeError(w, r, err)
 			return
 		}
 
+		metrics.RedirectHits.WithLabelValues("redirected").Inc()
 		w.Header().Add("Location", link)
 		w.WriteHeader(307)
 	}
@@ -178,13 +320,13 @@ func GetAllShortURLUserHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc
 		}
 		id, err := gen.GetUserID(token)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
-		res, err := urlStorage.GetAllShortURLUser(id)
+		res, err := urlStorage.GetAllShortURLUser(r.Context(), id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
@@ -195,7 +337,7 @@ func GetAllShortURLUserHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc
 
 		resp, err := json.MarshalIndent(res, "", " ")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 
@@ -207,9 +349,9 @@ func GetAllShortURLUserHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc
 
 func GetPingToDBHandle(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := urlStorage.PingDB()
+		err := urlStorage.PingDB(r.Context())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
@@ -222,25 +364,41 @@ func CreateListShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc
 		var links []storage.ShortURLByUser
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
 		err = json.Unmarshal(body, &links)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, utils.NewValidationError(err.Error()))
 			return
 		}
 
-		res, err := urlStorage.CreateListShortURL(links)
+		urlPolicy := policy.NewPolicy()
+		for i := range links {
+			link, err := urlPolicy.Check(links[i].InitialLink)
+			if err != nil {
+				writePolicyViolation(w, r, err)
+				return
+			}
+			links[i].InitialLink = link
+
+			links[i].Domain, err = requestDomain(r, links[i].Domain)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		res, err := urlStorage.CreateListShortURL(r.Context(), links)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 
 		resp, err := json.Marshal(res)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 
@@ -250,8 +408,84 @@ func CreateListShortURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc
 	}
 }
 
-type Job struct {
-	shortURL string
+// PolicyViolation is the problem of a link rejected by the url policy
+// with the rule, the reason and the link as extension members.
+type PolicyViolation struct {
+	Problem
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+	Link   string `json:"url"`
+}
+
+// Responds 422 with the rule of the url policy the link is rejected by,
+// a link matched by the blocklist is rejected by the blocklist rule.
+func writePolicyViolation(w http.ResponseWriter, r *http.Request, err error) {
+	res := PolicyViolation{
+		Problem: Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(http.StatusUnprocessableEntity),
+			Status: http.StatusUnprocessableEntity,
+		},
+	}
+	var violation *utils.PolicyViolationError
+	var blocked *utils.BlockedLinkError
+	switch {
+	case errors.As(err, &violation):
+		res.Detail = violation.Err.Error()
+		res.Rule = violation.Rule
+		res.Reason = violation.Reason
+		res.Link = violation.Link
+	case errors.As(err, &blocked):
+		res.Detail = blocked.Err.Error()
+		res.Rule = "blocklist"
+		res.Reason = "matched by " + blocked.Entry + " of " + blocked.List
+		res.Link = blocked.Link
+	default:
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(res)
+}
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex, nofollow">
+<title>Warning: dangerous link</title>
+</head>
+<body>
+<h1>Warning: this link may be dangerous</h1>
+<p>The destination of this short link is listed as malware or phishing site,
+visiting it may harm your computer or steal your personal data.</p>
+<p>Destination: <code>{{.Link}}</code></p>
+<p><a href="{{.Link}}" rel="noopener noreferrer nofollow">Continue at my own risk</a></p>
+</body>
+</html>
+`))
+
+// Serves the warning page instead of redirecting to the blocked link.
+func writeInterstitial(w http.ResponseWriter, blocked *utils.BlockedLinkError) {
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	interstitialTemplate.Execute(w, blocked)
+}
+
+// Returns the domain the link is created, deleted or looked up in: the requested one,
+// the one of the domain query parameter or else the domain of the host the request is sent to.
+// The error is of the utils.ErrValidation kind if the requested domain is unknown.
+func requestDomain(r *http.Request, requested string) (string, error) {
+	if requested == "" {
+		requested = r.URL.Query().Get("domain")
+	}
+	if requested == "" {
+		return domains.Get().Resolve(r.Host), nil
+	}
+	return domains.Get().Lookup(requested)
 }
 
 func DeleteListURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
@@ -259,12 +493,12 @@ func DeleteListURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 		var links []string
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 		err = json.Unmarshal(body, &links)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, utils.NewValidationError(err.Error()))
 			return
 		}
 
@@ -274,36 +508,27 @@ func DeleteListURLHandler(urlStorage storage.ShortURLRepo) http.HandlerFunc {
 		}
 		id, err := gen.GetUserID(token)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
-		res, err := urlStorage.CheckURLsCreatedByUser(links, id)
+		domain, err := requestDomain(r, "")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
-		if len(res) != 0 {
-			noRightsError := fmt.Errorf("you cannot delete this links: %v. you have not rights", res)
-			http.Error(w, noRightsError.Error(), http.StatusMethodNotAllowed)
+
+		res, err := urlStorage.CheckURLsCreatedByUser(r.Context(), domain, links, id)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
-
-		workersCount := 3
-
-		jobCh := make(chan *Job)
-		for i := 0; i < workersCount; i++ {
-			go func() {
-				for job := range jobCh {
-					urlStorage.DeleteShortURLUser(job.shortURL, id)
-				}
-			}()
+		if len(res) != 0 {
+			writeError(w, r, utils.NewNotOwnerError(res))
+			return
 		}
 
-		for i := 0; i < len(links); i++ {
-			job := &Job{shortURL: links[i]}
-			jobCh <- job
-		}
+		deletion.InBackground(r.Context(), urlStorage, domain, links, id)
 
 		w.WriteHeader(http.StatusAccepted)
 	}
@@ -13,9 +13,13 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
 	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
 	mocks "github.com/GorunovAlx/shortening_long_url/internal/app/mocks"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,39 +42,59 @@ func (ms *mockStorage) idInkrement() error {
 }
 
 // Imitating ShortURLRepo.GetInitialLink.
-func (ms *mockStorage) GetInitialLink(shortLink string) (string, error) {
+func (ms *mockStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
 	link := ms.storage[shortLink]
 	if link == "" {
-		return "", errors.New("the url with this value does not exist")
+		return "", utils.ErrLinkNotFound
 	}
 	return link, nil
 }
 
 // Imitating ShortURLRepo.CreateShortURL.
-func (ms *mockStorage) CreateShortURL(shortURL *storage.ShortURL) (string, error) {
+func (ms *mockStorage) CreateShortURL(ctx context.Context, shortURL *storage.ShortURL) (string, error) {
 	ms.storage[ms.id] = shortURL.InitialLink
 	defer ms.idInkrement()
 
 	return ms.id, nil
 }
 
-func (ms *mockStorage) GetAllShortURLUser(id uint32) ([]storage.ShortURLByUser, error) {
+func (ms *mockStorage) GetAllShortURLUser(ctx context.Context, id uint32) ([]storage.ShortURLByUser, error) {
 	return nil, nil
 }
 
-func (ms *mockStorage) PingDB() error {
+func (ms *mockStorage) PingDB(ctx context.Context) error {
 	return nil
 }
 
-func (ms *mockStorage) CreateListShortURL(links []storage.ShortURLByUser) ([]storage.ShortURLByUser, error) {
+func (ms *mockStorage) HealthComponents() []health.Component {
+	return nil
+}
+
+func (ms *mockStorage) CreateListShortURL(ctx context.Context, links []storage.ShortURLByUser) ([]storage.ShortURLByUser, error) {
 	return nil, nil
 }
 
-func (ms *mockStorage) DeleteShortURLUser(link string, id uint32) error {
+func (ms *mockStorage) DeleteShortURLUser(ctx context.Context, domain, link string, id uint32) error {
 	return nil
 }
 
-func (ms *mockStorage) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
+func (ms *mockStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	return nil, nil
+}
+
+func (ms *mockStorage) FindShortURLs(ctx context.Context, filter storage.ShortURLFilter) ([]storage.ShortURL, error) {
+	return nil, nil
+}
+
+func (ms *mockStorage) ModerateShortURL(ctx context.Context, domain, link string, m storage.Moderation) error {
+	return nil
+}
+
+func (ms *mockStorage) ReassignShortURL(ctx context.Context, domain, link string, id uint32) error {
+	return nil
+}
+
+func (ms *mockStorage) FindAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
 	return nil, nil
 }
 
@@ -185,7 +209,7 @@ func TestGetLinkHandler(t *testing.T) {
 			name: "simple test #2(Get)",
 			path: "/5",
 			want: want{
-				statusCode: 400,
+				statusCode: 404,
 				link:       "",
 			},
 		},
@@ -300,7 +324,7 @@ func TestCreateShortURLHandler(t *testing.T) {
 
 			shortURL.UserID = id
 
-			mockStorage.EXPECT().CreateShortURL(&shortURL).Return(tt.want.link[1:], nil)
+			mockStorage.EXPECT().CreateShortURL(gomock.Any(), &shortURL).Return(tt.want.link[1:], nil)
 
 			ctx := request.Context()
 			ctx = context.WithValue(ctx, contextKeyRequestID, token)
@@ -362,7 +386,7 @@ func TestGetAllShortURLUserHandler(t *testing.T) {
 			id, err := gen.GetUserID(token)
 			require.NoError(t, err)
 
-			mockStorage.EXPECT().GetAllShortURLUser(id).Return(tt.want.shorts, nil)
+			mockStorage.EXPECT().GetAllShortURLUser(gomock.Any(), id).Return(tt.want.shorts, nil)
 
 			ctx := request.Context()
 			ctx = context.WithValue(ctx, contextKeyRequestID, token)
@@ -407,7 +431,7 @@ func TestGetPingToDBHandle(t *testing.T) {
 			w := httptest.NewRecorder()
 			h := http.HandlerFunc(GetPingToDBHandle(mockStorage))
 
-			mockStorage.EXPECT().PingDB().Return(nil)
+			mockStorage.EXPECT().PingDB(gomock.Any()).Return(nil)
 
 			h.ServeHTTP(w, request)
 			result := w.Result()
@@ -481,7 +505,7 @@ func TestCreateListShortURLHandler(t *testing.T) {
 			w := httptest.NewRecorder()
 			h := http.HandlerFunc(CreateListShortURLHandler(mockStorage))
 
-			mockStorage.EXPECT().CreateListShortURL(tt.shortsFor).Return(tt.want.shorts, nil)
+			mockStorage.EXPECT().CreateListShortURL(gomock.Any(), tt.shortsFor).Return(tt.want.shorts, nil)
 
 			h.ServeHTTP(w, request)
 			result := w.Result()
@@ -499,3 +523,202 @@ func TestCreateListShortURLHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateShortURLHandlerPolicyViolation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("http://127.0.0.1/admin"))
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(CreateShortURLHandler(mockStorage))
+
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	ctx := context.WithValue(request.Context(), contextKeyRequestID, token)
+	h.ServeHTTP(w, request.WithContext(ctx))
+	result := w.Result()
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, result.StatusCode)
+	assert.Equal(t, "application/problem+json", result.Header.Get("Content-Type"))
+
+	var violation PolicyViolation
+	err = json.NewDecoder(result.Body).Decode(&violation)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, violation.Status)
+	assert.Equal(t, "private_ip", violation.Rule)
+	assert.Equal(t, "http://127.0.0.1/admin", violation.Link)
+}
+
+func TestGetInitialLinkHandlerBlocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+	mockStorage.EXPECT().GetInitialLink(gomock.Any(), "", "abc").
+		Return("", utils.NewBlockedLinkError("http://evil.com/", "malware.txt", "evil.com"))
+
+	ts := httptest.NewServer(NewRouter(mockStorage))
+	defer ts.Close()
+
+	result := testRequest(t, ts, http.MethodGet, "/abc", nil)
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "", result.Header.Get("Location"))
+	assert.Equal(t, "text/html; charset=utf-8", result.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(result.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "<code>http://evil.com/</code>")
+}
+
+// Sets the config with the go.brand.example domain besides the base url.
+func setBrandDomain(t *testing.T) {
+	old := *configs.Get()
+	cfg := old
+	cfg.BaseURL = "http://localhost:8080"
+	cfg.Domains = []string{"https://go.brand.example"}
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+}
+
+func TestCreateShortURLJSONHandlerDomain(t *testing.T) {
+	setBrandDomain(t)
+
+	tests := []struct {
+		name       string
+		target     string
+		host       string
+		body       string
+		domain     string
+		statusCode int
+		expected   string
+	}{
+		{
+			name:       "default domain",
+			target:     "/api/shorten",
+			body:       `{"url":"https://example.com"}`,
+			statusCode: http.StatusCreated,
+			expected:   `{"result":"http://localhost:8080/abc"}`,
+		},
+		{
+			name:       "domain of the body",
+			target:     "/api/shorten",
+			body:       `{"url":"https://example.com","domain":"go.brand.example"}`,
+			domain:     "go.brand.example",
+			statusCode: http.StatusCreated,
+			expected:   `{"result":"https://go.brand.example/abc"}`,
+		},
+		{
+			name:       "domain of the query",
+			target:     "/api/shorten?domain=GO.BRAND.EXAMPLE",
+			body:       `{"url":"https://example.com"}`,
+			domain:     "go.brand.example",
+			statusCode: http.StatusCreated,
+			expected:   `{"result":"https://go.brand.example/abc"}`,
+		},
+		{
+			name:       "domain of the host",
+			target:     "/api/shorten",
+			host:       "go.brand.example:443",
+			body:       `{"url":"https://example.com"}`,
+			domain:     "go.brand.example",
+			statusCode: http.StatusCreated,
+			expected:   `{"result":"https://go.brand.example/abc"}`,
+		},
+		{
+			name:       "unknown domain",
+			target:     "/api/shorten",
+			body:       `{"url":"https://example.com","domain":"go.other.example"}`,
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockStorage := mocks.NewMockShortURLRepo(ctrl)
+			if tt.statusCode == http.StatusCreated {
+				mockStorage.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, shortURL *storage.ShortURL) (string, error) {
+						assert.Equal(t, tt.domain, shortURL.Domain)
+						return "abc", nil
+					})
+			}
+
+			request := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.host != "" {
+				request.Host = tt.host
+			}
+			token, err := gen.GenerateUserIDToken()
+			require.NoError(t, err)
+			ctx := context.WithValue(request.Context(), contextKeyRequestID, token)
+			w := httptest.NewRecorder()
+			CreateShortURLJSONHandler(mockStorage).ServeHTTP(w, request.WithContext(ctx))
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.expected != "" {
+				body, err := ioutil.ReadAll(result.Body)
+				require.NoError(t, err)
+				assert.JSONEq(t, tt.expected, string(body))
+			}
+		})
+	}
+}
+
+// The short url is looked up in the domain of the Host header.
+func TestGetInitialLinkHandlerDomain(t *testing.T) {
+	setBrandDomain(t)
+
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+	mockStorage.EXPECT().GetInitialLink(gomock.Any(), "go.brand.example", "abc").Return("https://example.com", nil)
+	mockStorage.EXPECT().GetInitialLink(gomock.Any(), "", "abc").Return("https://example.org", nil)
+
+	ts := httptest.NewServer(NewRouter(mockStorage))
+	defer ts.Close()
+
+	for host, location := range map[string]string{
+		"go.brand.example": "https://example.com",
+		"localhost:8080":   "https://example.org",
+	} {
+		request, err := http.NewRequest(http.MethodGet, ts.URL+"/abc", nil)
+		require.NoError(t, err)
+		request.Host = host
+		result, err := ts.Client().Transport.RoundTrip(request)
+		require.NoError(t, err)
+		result.Body.Close()
+
+		assert.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+		assert.Equal(t, location, result.Header.Get("Location"))
+	}
+}
+
+// Only the url and the domain of the request are taken, the link is never created moderated or deleted.
+func TestCreateShortURLJSONHandlerIgnoresLinkFields(t *testing.T) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+	mockStorage.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, shortURL *storage.ShortURL) (string, error) {
+			assert.Equal(t, "https://example.com", shortURL.InitialLink)
+			assert.Equal(t, id, shortURL.UserID)
+			assert.False(t, shortURL.Deleted)
+			assert.Zero(t, shortURL.DisabledStatus)
+			assert.Empty(t, shortURL.DisabledReason)
+			return "abc", nil
+		})
+
+	body := `{"url":"https://example.com","deleted":true,"disabled_status":451,"disabled_reason":"x","user_id":1}`
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	ctx := context.WithValue(request.Context(), contextKeyRequestID, token)
+	w := httptest.NewRecorder()
+	CreateShortURLJSONHandler(mockStorage).ServeHTTP(w, request.WithContext(ctx))
+	result := w.Result()
+	defer result.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, result.StatusCode)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+)
+
+// GetLivenessHandler reports that the process serves requests,
+// the dependencies are not checked so that their failure does not restart it.
+func GetLivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, health.Report{Status: health.StatusOK})
+	}
+}
+
+// GetReadinessHandler checks the components of the storage and the other
+// components and responds 200 with their statuses if all of them are ok or 503 otherwise.
+// The errors of the failed components are logged, not sent.
+func GetReadinessHandler(urlStorage storage.ShortURLRepo, components ...health.Component) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout := configs.Get().HealthCheckTimeout; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		all := append(urlStorage.HealthComponents(), components...)
+		report := health.Check(ctx, all...)
+		for _, component := range report.Components {
+			if component.Status != health.StatusOK {
+				zerolog.Ctx(r.Context()).Error().
+					Str("component", component.Name).
+					Str("error", component.Error).
+					Msg("health check failed")
+			}
+		}
+		writeHealthReport(w, report)
+	}
+}
+
+func writeHealthReport(w http.ResponseWriter, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != health.StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+	mocks "github.com/GorunovAlx/shortening_long_url/internal/app/mocks"
+)
+
+func TestGetLivenessHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	GetLivenessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestGetReadinessHandler(t *testing.T) {
+	ok := health.CheckerFunc(func(ctx context.Context) error { return nil })
+	fail := health.CheckerFunc(func(ctx context.Context) error { return errors.New("file is read-only") })
+
+	tests := []struct {
+		name       string
+		storage    health.Checker
+		statusCode int
+		statuses   map[string]string
+	}{
+		{
+			name:       "all components are ok",
+			storage:    ok,
+			statusCode: http.StatusOK,
+			statuses:   map[string]string{"storage": "ok", "deletion_queue": "ok"},
+		},
+		{
+			name:       "storage fails",
+			storage:    fail,
+			statusCode: http.StatusServiceUnavailable,
+			statuses:   map[string]string{"storage": "fail", "deletion_queue": "ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockStorage := mocks.NewMockShortURLRepo(ctrl)
+			mockStorage.EXPECT().HealthComponents().Return([]health.Component{
+				{Name: "storage", Checker: tt.storage},
+			})
+
+			w := httptest.NewRecorder()
+			NewRouter(mockStorage).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			var report health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			statuses := make(map[string]string)
+			for _, component := range report.Components {
+				statuses[component.Name] = component.Status
+			}
+			assert.Equal(t, tt.statuses, statuses)
+			assert.NotContains(t, w.Body.String(), "read-only")
+		})
+	}
+}
@@ -4,11 +4,28 @@ import (
 	"compress/gzip"
 	"context"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
 	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/tracing"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
 )
 
 type gzipWriter struct {
@@ -64,7 +81,7 @@ func MiddlewareGzipReaderHandle(next http.Handler) http.Handler {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
 			gz, err := gzip.NewReader(r.Body)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, utils.NewValidationError(err.Error()))
 				return
 			}
 			reader = gz
@@ -91,12 +108,15 @@ func MiddlewareAuthUserHandle(next http.Handler) http.Handler {
 		if len(userIDToken) != 0 {
 			isAuthentic, err := gen.AuthUserIDToken(userIDToken)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, err)
 				return
 			}
 			if isAuthentic {
-				ctx := r.Context()
-				ctx = context.WithValue(ctx, contextKeyRequestID, userIDToken)
+				ctx, err := withUser(r, userIDToken)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
@@ -104,7 +124,7 @@ func MiddlewareAuthUserHandle(next http.Handler) http.Handler {
 
 		userIDToken, err := gen.GenerateUserIDToken()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
@@ -116,13 +136,232 @@ func MiddlewareAuthUserHandle(next http.Handler) http.Handler {
 			Expires: expiration,
 		}
 
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, contextKeyRequestID, userIDToken)
+		ctx, err := withUser(r, userIDToken)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 		http.SetCookie(w, &cookie)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// MiddlewareAdminHandle lets through only users with the admin role
+// sending the admin token in the X-Admin-Token header,
+// it must be used after MiddlewareAuthUserHandle.
+func MiddlewareAdminHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !configs.Get().IsAdminToken(r.Header.Get("X-Admin-Token")) {
+			writeError(w, r, utils.NewKindError(utils.ErrForbidden, "admin token required"))
+			return
+		}
+
+		id, err := getUserID(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if !configs.Get().IsAdmin(id) {
+			writeError(w, r, utils.NewKindError(utils.ErrForbidden, "admin role required"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the request context carrying the user token and the audit actor:
+// the user, the id of the request and the client ip.
+func withUser(r *http.Request, userIDToken string) (context.Context, error) {
+	id, err := gen.GetUserID(userIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logger is shared with MiddlewareAccessLogHandle, so its line gets the user too.
+	zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Uint32("user_id", id)
+	})
+
+	ctx := context.WithValue(r.Context(), contextKeyRequestID, userIDToken)
+	ctx = audit.WithActor(ctx, audit.Actor{
+		UserID:    id,
+		RequestID: middleware.GetReqID(r.Context()),
+		ClientIP:  clientIP(r),
+	})
+	return ctx, nil
+}
+
+// Returns the ip of the client, set by middleware.RealIP.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// MiddlewareRateLimitHandle takes a token from the buckets of the client
+// for every request and responds 429 if a bucket is empty, see rateLimitKeys.
+// The scope separates the buckets of route groups, it must be used after MiddlewareAuthUserHandle.
+func MiddlewareRateLimitHandle(store ratelimit.Store, scope string, limit func() ratelimit.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := limit()
+			if !limit.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			keys := rateLimitKeys(r)
+			for i := range keys {
+				keys[i] = scope + ":" + keys[i]
+			}
+			res, err := ratelimit.TakeAll(r.Context(), store, keys, limit)
+			if err != nil {
+				// the limiter must not take the service down with its store.
+				zerolog.Ctx(r.Context()).Error().Err(err).Str("scope", scope).Msg("rate limiter is unavailable")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				retryAfter := ceilSeconds(res.RetryAfter)
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeProblem(w, Problem{
+					Title:  http.StatusText(http.StatusTooManyRequests),
+					Status: http.StatusTooManyRequests,
+					Detail: "too many requests",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Start the server span of the request continuing the trace from
+// the W3C traceparent header. The span is named by the chi route pattern
+// once the request is routed.
+func MiddlewareTracingHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", "", r)...),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRouteKey.String(rctx.RoutePattern()))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	})
+}
+
+// Write a json access log line for every request with the request id,
+// user id, route, status and latency. The request logger with the request
+// and trace ids is put into the request context for the handlers and storage,
+// see zerolog.Ctx; the user id is added to it once the user is authenticated.
+func MiddlewareAccessLogHandle(logger zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			reqLogger := logger.With().Str("request_id", middleware.GetReqID(r.Context())).Logger()
+			if traceID := tracing.TraceID(r.Context()); traceID != "" {
+				reqLogger = reqLogger.With().Str("trace_id", traceID).Logger()
+			}
+			ctx := reqLogger.WithContext(r.Context())
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			event := zerolog.Ctx(ctx).Info()
+			if status >= http.StatusInternalServerError {
+				event = zerolog.Ctx(ctx).Error()
+			}
+			route := ""
+			if rctx := chi.RouteContext(ctx); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			event.
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("route", route).
+				Int("status", status).
+				Int("bytes", ww.BytesWritten()).
+				Float64("latency_ms", float64(time.Since(start).Microseconds())/1000).
+				Str("client_ip", clientIP(r)).
+				Str("user_agent", r.UserAgent()).
+				Msg("request")
+		})
+	}
+}
+
+// Count the requests and observe their duration by chi route pattern,
+// method and status. Requests not matched by a route share the "unmatched" route
+// so that random paths do not create new series.
+func MiddlewareMetricsHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{route, r.Method, strconv.Itoa(status)}
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Returns the keys of the buckets the client is limited by, see ratelimit.Keys:
+// the api key of the X-API-Key header, the ip and the user authenticated by the cookie.
+func rateLimitKeys(r *http.Request) []string {
+	user := ""
+	token := getCookieByName("user_id", r)
+	if token != "" && token == r.Context().Value(contextKeyRequestID) {
+		if id, err := gen.GetUserID(token); err == nil {
+			user = strconv.FormatUint(uint64(id), 10)
+		}
+	}
+	return ratelimit.Keys(r.Header.Get("X-API-Key"), clientIP(r), user)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
 func getCookieByName(cName string, r *http.Request) string {
 	receivedCookie := r.Cookies()
 	var value string
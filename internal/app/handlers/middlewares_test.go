@@ -3,14 +3,32 @@ package handlers
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	mocks "github.com/GorunovAlx/shortening_long_url/internal/app/mocks"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/ratelimit"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestMiddlewareGzipWriterHandle(t *testing.T) {
@@ -78,6 +96,103 @@ func TestMiddlewareAuthUserHandle(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestMiddlewareRateLimitHandle(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := MiddlewareRateLimitHandle(ratelimit.NewInMemoryStore(), "create", func() ratelimit.Limit {
+		return ratelimit.Limit{Rate: 0.5, Burst: 2}
+	})(nextHandler)
+
+	wants := []struct {
+		status    int
+		remaining string
+	}{
+		{status: http.StatusOK, remaining: "1"},
+		{status: http.StatusOK, remaining: "0"},
+		{status: http.StatusTooManyRequests, remaining: "0"},
+	}
+	for _, want := range wants {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		result := w.Result()
+
+		assert.Equal(t, want.status, result.StatusCode)
+		assert.Equal(t, "2", result.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, want.remaining, result.Header.Get("RateLimit-Remaining"))
+		if want.status == http.StatusTooManyRequests {
+			assert.Equal(t, "2", result.Header.Get("Retry-After"))
+		}
+		err := result.Body.Close()
+		require.NoError(t, err)
+	}
+
+	// another client has its own bucket.
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.RemoteAddr = "192.0.2.2:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	result := w.Result()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	err := result.Body.Close()
+	require.NoError(t, err)
+}
+
+// A new cookie on every request does not give a new bucket, the ip is limited too.
+func TestMiddlewareRateLimitHandleNewUsers(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := MiddlewareRateLimitHandle(ratelimit.NewInMemoryStore(), "create", func() ratelimit.Limit {
+		return ratelimit.Limit{Rate: 0.5, Burst: 2}
+	})(nextHandler)
+
+	for _, status := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		token, err := gen.GenerateUserIDToken()
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		request.AddCookie(&http.Cookie{Name: "user_id", Value: token})
+		ctx := context.WithValue(request.Context(), contextKeyRequestID, token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request.WithContext(ctx))
+		result := w.Result()
+
+		assert.Equal(t, status, result.StatusCode)
+		require.NoError(t, result.Body.Close())
+	}
+}
+
+func TestMiddlewareRateLimitHandleReload(t *testing.T) {
+	limit := ratelimit.Limit{}
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := MiddlewareRateLimitHandle(ratelimit.NewInMemoryStore(), "create", func() ratelimit.Limit {
+		return limit
+	})(nextHandler)
+
+	serve := func() *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request)
+		return w.Result()
+	}
+
+	// the disabled limit does not take tokens.
+	result := serve()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Empty(t, result.Header.Get("RateLimit-Limit"))
+	require.NoError(t, result.Body.Close())
+
+	limit = ratelimit.Limit{Rate: 0.5, Burst: 1}
+	result = serve()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "1", result.Header.Get("RateLimit-Limit"))
+	require.NoError(t, result.Body.Close())
+
+	result = serve()
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	require.NoError(t, result.Body.Close())
+}
+
 func compress(data []byte) ([]byte, error) {
 	var b bytes.Buffer
 	w := gzip.NewWriter(&b)
@@ -95,3 +210,110 @@ func compress(data []byte) ([]byte, error) {
 
 	return b.Bytes(), nil
 }
+
+func TestMiddlewareMetricsHandle(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(MiddlewareMetricsHandle)
+	r.Get("/{shortURL}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+
+	redirects := metrics.HTTPRequests.WithLabelValues("/{shortURL}", http.MethodGet, "307")
+	unmatched := metrics.HTTPRequests.WithLabelValues("unmatched", http.MethodPost, "405")
+	before, beforeUnmatched := testutil.ToFloat64(redirects), testutil.ToFloat64(unmatched)
+
+	for _, path := range []string{"/abc", "/xyz"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/abc", nil))
+
+	assert.Equal(t, before+2, testutil.ToFloat64(redirects))
+	assert.Equal(t, beforeUnmatched+1, testutil.ToFloat64(unmatched))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `shortener_http_request_duration_seconds_count{method="GET",route="/{shortURL}",status="307"}`)
+}
+
+func TestMiddlewareTracingHandle(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ctrl := gomock.NewController(t)
+	mockStorage := mocks.NewMockShortURLRepo(ctrl)
+	mockStorage.EXPECT().GetInitialLink(gomock.Any(), "", "abc").
+		DoAndReturn(func(ctx context.Context, domain, shortLink string) (string, error) {
+			assert.True(t, trace.SpanContextFromContext(ctx).IsValid())
+			return "https://example.com", nil
+		})
+
+	request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	NewRouter(mockStorage).ServeHTTP(w, request)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		byName[span.Name()] = span
+	}
+
+	server, handler, repo := byName["GET /{shortURL}"], byName["GetInitialLinkHandler"], byName["ShortURLStorage.GetInitialLink"]
+	require.NotNil(t, server)
+	require.NotNil(t, handler)
+	require.NotNil(t, repo)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.True(t, server.Parent().IsRemote())
+	assert.Equal(t, server.SpanContext().SpanID(), handler.Parent().SpanID())
+	assert.Equal(t, handler.SpanContext().SpanID(), repo.Parent().SpanID())
+	assert.Contains(t, server.Attributes(), semconv.HTTPStatusCodeKey.Int(http.StatusTemporaryRedirect))
+}
+
+func TestMiddlewareAccessLogHandle(t *testing.T) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(MiddlewareAccessLogHandle(zerolog.New(&buf)))
+	r.Use(MiddlewareAuthUserHandle)
+	r.Get("/{shortURL}", func(w http.ResponseWriter, r *http.Request) {
+		zerolog.Ctx(r.Context()).Debug().Msg("handler")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	request.AddCookie(&http.Cookie{Name: "user_id", Value: token})
+	r.ServeHTTP(httptest.NewRecorder(), request)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var handler, access map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &handler))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &access))
+
+	assert.Equal(t, "handler", handler["message"])
+	assert.Equal(t, float64(id), handler["user_id"])
+	assert.Equal(t, handler["request_id"], access["request_id"])
+	assert.NotEmpty(t, access["request_id"])
+	assert.Equal(t, float64(id), access["user_id"])
+	assert.Equal(t, "/{shortURL}", access["route"])
+	assert.Equal(t, "/abc", access["path"])
+	assert.Equal(t, float64(http.StatusTemporaryRedirect), access["status"])
+	assert.Contains(t, access, "latency_ms")
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	_ "embed"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+// The OpenAPI 3 document of the routes of NewRouter.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+var (
+	openAPIOnce   sync.Once
+	openAPIRouter routers.Router
+	openAPIErr    error
+)
+
+// OpenAPIRouter returns the router finding the operations of the OpenAPI document,
+// the document is loaded and validated once.
+func OpenAPIRouter() (routers.Router, error) {
+	openAPIOnce.Do(func() {
+		var doc *openapi3.T
+		if doc, openAPIErr = openAPIDoc(); openAPIErr != nil {
+			return
+		}
+		openAPIRouter, openAPIErr = legacy.NewRouter(doc)
+	})
+	return openAPIRouter, openAPIErr
+}
+
+func openAPIDoc() (*openapi3.T, error) {
+	return openapi3.NewLoader().LoadFromData(openAPISpec)
+}
+
+// GetOpenAPIHandler serves the OpenAPI document.
+func GetOpenAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(openAPISpec)
+	}
+}
+
+// Reject the requests not matching the parameters and the body of their
+// operation in the OpenAPI document with 400 and problem+json listing
+// the violations. The requests to routes missing in the document are passed
+// to the router, which responds 404 or 405, like the requests with an empty path
+// parameter, which the router does not match with the parameter. A request without Content-Type
+// is validated as the only media type of the operation and a plain text
+// body is taken as is, like CreateShortURLHandler does.
+// It must be used after MiddlewareGzipReaderHandle.
+func MiddlewareValidateRequestHandle(next http.Handler) http.Handler {
+	router, err := OpenAPIRouter()
+	if err != nil {
+		panic(err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := router.FindRoute(r)
+		if err != nil || hasEmptyValue(pathParams) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if body := route.Operation.RequestBody; body != nil && len(body.Value.Content) == 1 {
+			for mediaType := range body.Value.Content {
+				if r.Header.Get("Content-Type") == "" || mediaType == "text/plain" {
+					r.Header.Set("Content-Type", mediaType)
+				}
+			}
+		}
+
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		})
+		if err != nil {
+			violations := validationErrors(err)
+			writeProblem(w, Problem{
+				Title:  "request does not match the api schema",
+				Status: http.StatusBadRequest,
+				Detail: violations[0],
+				Errors: violations,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasEmptyValue(params map[string]string) bool {
+	for _, value := range params {
+		if value == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the violations of the schema, one per error of the multi error.
+func validationErrors(err error) []string {
+	var multi openapi3.MultiError
+	if !errors.As(err, &multi) {
+		return []string{err.Error()}
+	}
+
+	res := make([]string, 0, len(multi))
+	for _, e := range multi {
+		res = append(res, validationErrors(e)...)
+	}
+	return res
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	mocks "github.com/GorunovAlx/shortening_long_url/internal/app/mocks"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// The routes of NewRouter and the operations of the document must be the same.
+func TestOpenAPIRoutes(t *testing.T) {
+	router, err := OpenAPIRouter()
+	require.NoError(t, err)
+
+	var routes []string
+	r := NewRouter(mocks.NewMockShortURLRepo(gomock.NewController(t)))
+	err = chi.Walk(r, func(method, route string, h http.Handler, m ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	var operations []string
+	doc, err := openAPIDoc()
+	require.NoError(t, err)
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			operations = append(operations, method+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(operations)
+	assert.Equal(t, operations, routes)
+	assert.NotNil(t, router)
+}
+
+// contractCase is a request to NewRouter with the storage calls it makes.
+type contractCase struct {
+	name   string
+	method string
+	path   string
+	body   string
+	admin  bool
+	expect func(m *mocks.MockShortURLRepo)
+	status int
+}
+
+// The responses of the handlers must match the document, every operation
+// of the document must be covered by a case.
+func TestOpenAPIContract(t *testing.T) {
+	token, err := gen.GenerateUserIDToken()
+	require.NoError(t, err)
+	id, err := gen.GetUserID(token)
+	require.NoError(t, err)
+	setAdmins(t, id)
+
+	cases := []contractCase{
+		{
+			name: "shorten text", method: http.MethodPost, path: "/", body: "https://example.com",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).Return("abc", nil)
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "shorten existing text", method: http.MethodPost, path: "/", body: "https://example.com",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).Return("abc", utils.ErrUniqueLink)
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "shorten text rejected by the policy", method: http.MethodPost, path: "/", body: "https://127.0.0.1/",
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "shorten json", method: http.MethodPost, path: "/api/shorten", body: `{"url":"https://example.com"}`,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CreateShortURL(gomock.Any(), gomock.Any()).Return("abc", nil)
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "shorten json without url", method: http.MethodPost, path: "/api/shorten", body: `{"link":"https://example.com"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "shorten json with moderation", method: http.MethodPost, path: "/api/shorten",
+			body:   `{"url":"https://example.com","deleted":true,"disabled_status":451,"disabled_reason":"x"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "shorten batch", method: http.MethodPost, path: "/api/shorten/batch",
+			body: `[{"correlation_id":"1","original_url":"https://example.com"}]`,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CreateListShortURL(gomock.Any(), gomock.Any()).
+					Return([]storage.ShortURLByUser{{CorrelationID: "1", ShortLink: "http://localhost:8080/abc"}}, nil)
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "redirect", method: http.MethodGet, path: "/abc",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetInitialLink(gomock.Any(), "", "abc").Return("https://example.com", nil)
+			},
+			status: http.StatusTemporaryRedirect,
+		},
+		{
+			name: "deleted link", method: http.MethodGet, path: "/abc",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetInitialLink(gomock.Any(), "", "abc").Return("", utils.ErrDeletedLink)
+			},
+			status: http.StatusGone,
+		},
+		{
+			name: "missing link", method: http.MethodGet, path: "/abc",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetInitialLink(gomock.Any(), "", "abc").Return("", utils.ErrLinkNotFound)
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "link disabled for legal reasons", method: http.MethodGet, path: "/abc",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetInitialLink(gomock.Any(), "", "abc").
+					Return("", utils.NewDisabledLinkError("abc", "phishing", http.StatusUnavailableForLegalReasons))
+			},
+			status: http.StatusUnavailableForLegalReasons,
+		},
+		{
+			name: "blocked link", method: http.MethodGet, path: "/abc",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetInitialLink(gomock.Any(), "", "abc").
+					Return("", utils.NewBlockedLinkError("https://evil.test", "malware.txt", "evil.test"))
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "user urls", method: http.MethodGet, path: "/api/user/urls",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetAllShortURLUser(gomock.Any(), gomock.Any()).
+					Return([]storage.ShortURLByUser{{ShortLink: "http://localhost:8080/abc", InitialLink: "https://example.com"}}, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "no user urls", method: http.MethodGet, path: "/api/user/urls",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetAllShortURLUser(gomock.Any(), gomock.Any()).Return(nil, nil)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "user urls while the storage is unavailable", method: http.MethodGet, path: "/api/user/urls",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().GetAllShortURLUser(gomock.Any(), gomock.Any()).
+					Return(nil, utils.NewUnavailableError(errors.New("connection refused")))
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "delete user urls", method: http.MethodDelete, path: "/api/user/urls", body: `["abc"]`,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CheckURLsCreatedByUser(gomock.Any(), "", []string{"abc"}, gomock.Any()).Return(nil, nil)
+				m.EXPECT().DeleteShortURLUser(gomock.Any(), "", "abc", gomock.Any()).Return(nil).AnyTimes()
+			},
+			status: http.StatusAccepted,
+		},
+		{
+			name: "delete urls of another user", method: http.MethodDelete, path: "/api/user/urls", body: `["abc"]`,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CheckURLsCreatedByUser(gomock.Any(), "", []string{"abc"}, gomock.Any()).Return([]string{"abc"}, nil)
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "ping", method: http.MethodGet, path: "/ping",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().PingDB(gomock.Any()).Return(nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "ping while the storage is unavailable", method: http.MethodGet, path: "/ping",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().PingDB(gomock.Any()).Return(utils.NewUnavailableError(errors.New("connection refused")))
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "batch with an existing link", method: http.MethodPost, path: "/api/shorten/batch",
+			body: `[{"correlation_id":"1","original_url":"https://example.com"}]`,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().CreateListShortURL(gomock.Any(), gomock.Any()).Return(nil, utils.ErrUniqueLink)
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "liveness", method: http.MethodGet, path: "/healthz",
+			status: http.StatusOK,
+		},
+		{
+			name: "readiness", method: http.MethodGet, path: "/readyz",
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().HealthComponents().Return(nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "metrics", method: http.MethodGet, path: "/metrics",
+			status: http.StatusOK,
+		},
+		{
+			name: "openapi", method: http.MethodGet, path: "/api/openapi.json",
+			status: http.StatusOK,
+		},
+		{
+			name: "admin search", method: http.MethodGet, path: "/api/admin/urls?owner=42", admin: true,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().FindShortURLs(gomock.Any(), gomock.Any()).
+					Return([]storage.ShortURL{{InitialLink: "https://example.com", ShortLink: "abc", UserID: 42}}, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "admin search with incorrect owner", method: http.MethodGet, path: "/api/admin/urls?owner=me", admin: true,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "search by not admin", method: http.MethodGet, path: "/api/admin/urls",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "disable", method: http.MethodPost, path: "/api/admin/urls/abc/disable", body: `{"reason":"phishing"}`, admin: true,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().ModerateShortURL(gomock.Any(), "", "abc", gomock.Any()).Return(nil)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "enable missing link", method: http.MethodPost, path: "/api/admin/urls/abc/enable", admin: true,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().ModerateShortURL(gomock.Any(), "", "abc", gomock.Any()).Return(utils.ErrLinkNotFound)
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "reassign", method: http.MethodPut, path: "/api/admin/urls/abc/owner", body: `{"user_id":7}`, admin: true,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().ReassignShortURL(gomock.Any(), "", "abc", uint32(7)).Return(nil)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "audit", method: http.MethodGet, path: "/api/admin/audit?limit=10", admin: true,
+			expect: func(m *mocks.MockShortURLRepo) {
+				m.EXPECT().FindAuditEntries(gomock.Any(), gomock.Any()).Return([]audit.Entry{{
+					Seq: 1, Time: time.Now(), Actor: 42, Action: "create", ShortURL: "abc", PrevHash: "0", Hash: "1",
+				}}, nil)
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	// the warning page of a blocked link is validated as a string.
+	openapi3filter.RegisterBodyDecoder("text/html", openapi3filter.FileBodyDecoder)
+	defer openapi3filter.UnregisterBodyDecoder("text/html")
+
+	router, err := OpenAPIRouter()
+	require.NoError(t, err)
+	covered := make(map[string]bool)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mocks.NewMockShortURLRepo(gomock.NewController(t))
+			if tc.expect != nil {
+				tc.expect(repo)
+			}
+
+			request := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.admin {
+				request.AddCookie(&http.Cookie{Name: "user_id", Value: token})
+				request.Header.Set("X-Admin-Token", testAdminToken)
+			}
+			w := httptest.NewRecorder()
+			NewRouter(repo).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.status, result.StatusCode, string(body))
+
+			// the request is recreated, the body of the first one has been read.
+			request = httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			route, pathParams, err := router.FindRoute(request)
+			require.NoError(t, err)
+			covered[route.Method+" "+route.Path] = true
+
+			err = openapi3filter.ValidateResponse(request.Context(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{
+					Request:    request,
+					PathParams: pathParams,
+					Route:      route,
+				},
+				Status: result.StatusCode,
+				Header: result.Header,
+				Body:   io.NopCloser(bytes.NewReader(body)),
+				Options: &openapi3filter.Options{
+					IncludeResponseStatus: true,
+				},
+			})
+			assert.NoError(t, err)
+		})
+	}
+
+	doc, err := openAPIDoc()
+	require.NoError(t, err)
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			assert.True(t, covered[method+" "+path], "%s %s is not covered", method, path)
+		}
+	}
+}
+
+func TestMiddlewareValidateRequestHandle(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		statusCode  int
+		violations  int
+	}{
+		{
+			name:       "valid request without content type",
+			method:     http.MethodPost,
+			path:       "/api/shorten",
+			body:       `{"url":"https://example.com"}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "plain text with another content type",
+			method:     http.MethodPost,
+			path:       "/",
+			body:       "https://example.com",
+			statusCode: http.StatusOK,
+			// curl -d sends application/x-www-form-urlencoded.
+			contentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name:        "malformed json",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":`,
+			statusCode:  http.StatusBadRequest,
+			violations:  1,
+		},
+		{
+			name:        "wrong content type",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "text/xml",
+			body:        `<url>https://example.com</url>`,
+			statusCode:  http.StatusBadRequest,
+			violations:  1,
+		},
+		{
+			name:       "incorrect query parameters",
+			method:     http.MethodGet,
+			path:       "/api/admin/audit?actor=me&limit=0",
+			statusCode: http.StatusBadRequest,
+			violations: 2,
+		},
+		{
+			name:       "route missing in the document",
+			method:     http.MethodGet,
+			path:       "/api/missing/route",
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			MiddlewareValidateRequestHandle(nextHandler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/problem+json", result.Header.Get("Content-Type"))
+			var problem Problem
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&problem))
+			assert.Equal(t, http.StatusBadRequest, problem.Status)
+			assert.Len(t, problem.Errors, tt.violations)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+// Set the Strict-Transport-Security header on the responses served over https,
+// so that browsers use https for the host for the configured max age.
+func MiddlewareHSTSHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := configs.Get()
+		if r.TLS != nil && cfg.HSTSMaxAge > 0 {
+			value := "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge.Seconds()), 10)
+			if cfg.HSTSIncludeSubdomains {
+				value += "; includeSubDomains"
+			}
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RedirectToHTTPSHandler permanently redirects the plain http requests to
+// the same host, path and query served over https on the port of the address.
+func RedirectToHTTPSHandler(address string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(address)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}
+}
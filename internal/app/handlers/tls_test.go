@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+func TestMiddlewareHSTSHandle(t *testing.T) {
+	old := *configs.Get()
+	defer configs.Set(old)
+	cfg := old
+	cfg.HSTSMaxAge = 24 * time.Hour
+	cfg.HSTSIncludeSubdomains = true
+	configs.Set(cfg)
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := MiddlewareHSTSHandle(nextHandler)
+
+	request := httptest.NewRequest(http.MethodGet, "https://short.test/", nil)
+	request.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	assert.Equal(t, "max-age=86400; includeSubDomains", w.Result().Header.Get("Strict-Transport-Security"))
+	require.NoError(t, w.Result().Body.Close())
+
+	// the header is not sent over plain http.
+	request = httptest.NewRequest(http.MethodGet, "http://short.test/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+	assert.Empty(t, w.Result().Header.Get("Strict-Transport-Security"))
+	require.NoError(t, w.Result().Body.Close())
+}
+
+func TestRedirectToHTTPSHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		target  string
+		want    string
+	}{
+		{
+			name:    "default https port",
+			address: ":443",
+			target:  "http://short.test/abc?x=1",
+			want:    "https://short.test/abc?x=1",
+		},
+		{
+			name:    "custom https port",
+			address: ":8443",
+			target:  "http://short.test:8080/api/user/urls",
+			want:    "https://short.test:8443/api/user/urls",
+		},
+		{
+			name:    "ipv6 host",
+			address: ":443",
+			target:  "http://[::1]:8080/abc",
+			want:    "https://[::1]/abc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+			RedirectToHTTPSHandler(tt.address)(w, request)
+			result := w.Result()
+
+			assert.Equal(t, http.StatusPermanentRedirect, result.StatusCode)
+			assert.Equal(t, tt.want, result.Header.Get("Location"))
+			require.NoError(t, result.Body.Close())
+		})
+	}
+}
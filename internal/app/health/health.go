@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// Statuses of a component and of the whole report.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Checker is implemented by the storage backends and the other components
+// the service depends on, CheckHealth returns an error if the component
+// cannot serve requests.
+type Checker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckerFunc is a function implementing Checker.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Component is a named Checker.
+type Component struct {
+	Name    string
+	Checker Checker
+}
+
+// ComponentStatus contains the result of the check of a component.
+// The error is not encoded, it is meant for the logs only.
+type ComponentStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Error     string  `json:"-"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Report contains the statuses of the components,
+// the status of the report is ok if all the components are ok.
+type Report struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components,omitempty"`
+}
+
+// Check checks the components concurrently and returns the report
+// with the statuses in the order of the components.
+func Check(ctx context.Context, components ...Component) Report {
+	report := Report{
+		Status:     StatusOK,
+		Components: make([]ComponentStatus, len(components)),
+	}
+
+	var wg sync.WaitGroup
+	for i, component := range components {
+		wg.Add(1)
+		go func(i int, component Component) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := component.Checker.CheckHealth(ctx)
+			status := ComponentStatus{
+				Name:      component.Name,
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Status = StatusFail
+				status.Error = err.Error()
+			}
+			report.Components[i] = status
+		}(i, component)
+	}
+	wg.Wait()
+
+	for _, status := range report.Components {
+		if status.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// CheckWritable checks that the file can be opened for appending,
+// creating it if it does not exist yet.
+func CheckWritable(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,39 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	report := Check(context.Background(),
+		Component{Name: "db", Checker: CheckerFunc(func(ctx context.Context) error { return errors.New("connection refused") })},
+		Component{Name: "file", Checker: CheckerFunc(func(ctx context.Context) error { return nil })},
+	)
+
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "db", report.Components[0].Name)
+	assert.Equal(t, StatusFail, report.Components[0].Status)
+	assert.Equal(t, "connection refused", report.Components[0].Error)
+	assert.Equal(t, "file", report.Components[1].Name)
+	assert.Equal(t, StatusOK, report.Components[1].Status)
+
+	assert.Equal(t, StatusOK, Check(context.Background()).Status)
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, CheckWritable(filepath.Join(dir, "links.txt")))
+	assert.Error(t, CheckWritable(filepath.Join(dir, "missing", "links.txt")))
+
+	if os.Getuid() != 0 {
+		readOnly := filepath.Join(dir, "read-only.txt")
+		assert.NoError(t, os.WriteFile(readOnly, nil, 0444))
+		assert.Error(t, CheckWritable(readOnly))
+	}
+}
@@ -0,0 +1,160 @@
+// Package loadtest sends concurrent redirect requests to a running shortener
+// and reports the throughput and the latencies of the redirect path.
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options contains the settings of a load test.
+type Options struct {
+	// BaseURL is the address of the shortener like http://localhost:8080.
+	BaseURL string
+	// Links is the number of the links created before the test,
+	// the redirects request them at random.
+	Links int
+	// Concurrency is the number of the clients sending the redirects at once.
+	Concurrency int
+	// Duration is how long the redirects are sent.
+	Duration time.Duration
+}
+
+// Report contains the results of a load test.
+type Report struct {
+	// Requests is the number of the redirects sent, Errors is the number
+	// of them failed or answered with another status than 307.
+	Requests int
+	Errors   int
+	Duration time.Duration
+	// The percentiles and the max of the latencies of the redirects.
+	P50, P90, P99, Max time.Duration
+}
+
+// RPS returns the number of the redirects per second.
+func (r Report) RPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// Run creates the links and sends the redirects of them with the concurrency
+// for the duration. The rate limits of the shortener must allow the traffic.
+func Run(ctx context.Context, opts Options) (Report, error) {
+	if opts.Links <= 0 || opts.Concurrency <= 0 || opts.Duration <= 0 {
+		return Report{}, fmt.Errorf("the links, the concurrency and the duration must be positive")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{MaxIdleConnsPerHost: opts.Concurrency},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 10 * time.Second,
+	}
+	baseURL := strings.TrimSuffix(opts.BaseURL, "/")
+
+	paths, err := createLinks(ctx, client, baseURL, opts.Links)
+	if err != nil {
+		return Report{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	latencies := make([][]time.Duration, opts.Concurrency)
+	errs := make([]int, opts.Concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(i)))
+			for ctx.Err() == nil {
+				begin := time.Now()
+				ok := redirect(ctx, client, baseURL+paths[rnd.Intn(len(paths))])
+				if ctx.Err() != nil {
+					return
+				}
+				latencies[i] = append(latencies[i], time.Since(begin))
+				if !ok {
+					errs[i]++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Duration: time.Since(start)}
+	var all []time.Duration
+	for i := range latencies {
+		all = append(all, latencies[i]...)
+		report.Errors += errs[i]
+	}
+	report.Requests = len(all)
+	if len(all) > 0 {
+		sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+		percentile := func(p int) time.Duration {
+			return all[(len(all)-1)*p/100]
+		}
+		report.P50, report.P90, report.P99, report.Max = percentile(50), percentile(90), percentile(99), all[len(all)-1]
+	}
+	return report, nil
+}
+
+// Creates the links and returns their paths.
+func createLinks(ctx context.Context, client *http.Client, baseURL string, n int) ([]string, error) {
+	run := time.Now().UnixNano()
+	paths := make([]string, n)
+	for i := range paths {
+		destination := fmt.Sprintf("https://example.com/loadtest/%d/%d", run, i)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/", strings.NewReader(destination))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("create a link: %w", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("create a link: %w", err)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return nil, fmt.Errorf("create a link: unexpected status %d: %s", resp.StatusCode, body)
+		}
+
+		link, err := url.Parse(strings.TrimSpace(string(body)))
+		if err != nil {
+			return nil, fmt.Errorf("create a link: %w", err)
+		}
+		paths[i] = link.Path
+	}
+	return paths, nil
+}
+
+// Requests the link and reports whether it is redirected.
+func redirect(ctx context.Context, client *http.Client, link string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusTemporaryRedirect
+}
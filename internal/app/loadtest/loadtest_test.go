@@ -0,0 +1,69 @@
+package loadtest
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/handlers"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+)
+
+func TestRun(t *testing.T) {
+	old := *configs.Get()
+	cfg, err := configs.LoadConfig(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	require.NoError(t, err)
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+
+	repo, err := storage.NewStorage(zerolog.Nop())
+	require.NoError(t, err)
+	ts := httptest.NewServer(handlers.NewRouter(repo, handlers.WithLogger(zerolog.Nop())))
+	defer ts.Close()
+
+	report, err := Run(context.Background(), Options{BaseURL: ts.URL, Links: 20, Concurrency: 4, Duration: 200 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Positive(t, report.Requests)
+	assert.Zero(t, report.Errors)
+	assert.Positive(t, report.RPS())
+	assert.LessOrEqual(t, report.P50, report.P99)
+	assert.LessOrEqual(t, report.P99, report.Max)
+}
+
+func TestRunErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("http://short.example/abc"))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	report, err := Run(context.Background(), Options{BaseURL: ts.URL, Links: 1, Concurrency: 2, Duration: 50 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Positive(t, report.Requests)
+	assert.Equal(t, report.Requests, report.Errors)
+
+	_, err = Run(context.Background(), Options{BaseURL: ts.URL, Links: 1, Concurrency: 0, Duration: time.Second})
+	assert.Error(t, err)
+}
+
+func TestRunCreateFails(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	_, err := Run(context.Background(), Options{BaseURL: ts.URL, Links: 1, Concurrency: 1, Duration: time.Second})
+	assert.EqualError(t, err, "create a link: unexpected status 429: too many requests\n")
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// Registry contains the metrics of the service
+// along with the go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts the handled requests by chi route pattern, method and status.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "The number of handled http requests.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestDuration observes the handling time by chi route pattern, method and status.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The time of handling http requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// GRPCRequests counts the handled grpc calls by full method and status code.
+	GRPCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "The number of handled grpc calls.",
+	}, []string{"method", "code"})
+
+	// GRPCRequestDuration observes the handling time by full method and status code.
+	GRPCRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "The time of handling grpc calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	// StorageOperationDuration observes the time of storage operations by backend and method.
+	StorageOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "The time of storage operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"backend", "method"})
+
+	// StorageOperationErrors counts the failed storage operations by backend and method.
+	StorageOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_errors_total",
+		Help:      "The number of failed storage operations.",
+	}, []string{"backend", "method"})
+
+	// RedirectHits counts the requests of short links by result:
+	// redirected, deleted, disabled, blocked, not_found.
+	RedirectHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirect_hits_total",
+		Help:      "The number of short link requests by result.",
+	}, []string{"result"})
+
+	// CacheRequests counts the lookups of the redirect cache by result: hit or miss,
+	// the hit ratio is rate of hits over rate of all lookups.
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "The number of lookups of the redirect cache by result.",
+	}, []string{"result"})
+
+	// CacheEvictions counts the entries evicted from the redirect cache to stay within its size.
+	CacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "The number of entries evicted from the redirect cache.",
+	})
+
+	// CacheEntries is the number of entries in the redirect cache.
+	CacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "The number of entries in the redirect cache.",
+	})
+
+	// DeletionQueueDepth is the number of links waiting to be deleted by the workers.
+	DeletionQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_queue_depth",
+		Help:      "The number of links waiting to be deleted.",
+	})
+
+	// AuditWriteErrors counts the entries that could not be written to the audit trail,
+	// the requests of these entries fail.
+	AuditWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_write_errors_total",
+		Help:      "The number of failed writes to the audit trail.",
+	})
+
+	// ReplicaHealthy is 1 for the Postgres replicas the reads are sent to and 0 for the failed ones.
+	ReplicaHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pgx_replica_healthy",
+		Help:      "Whether the Postgres replica is healthy.",
+	}, []string{"pool"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		GRPCRequests,
+		GRPCRequestDuration,
+		StorageOperationDuration,
+		StorageOperationErrors,
+		RedirectHits,
+		CacheRequests,
+		CacheEvictions,
+		CacheEntries,
+		DeletionQueueDepth,
+		AuditWriteErrors,
+		ReplicaHealthy,
+	)
+}
+
+// Returns a http.Handler serving the metrics in the prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveStorage records the duration and the error of a storage operation started at start.
+func ObserveStorage(backend, method string, start time.Time, err error) {
+	StorageOperationDuration.WithLabelValues(backend, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		StorageOperationErrors.WithLabelValues(backend, method).Inc()
+	}
+}
+
+// PoolCollector exports the statistics of a pgx pool taken at the time of scraping.
+type PoolCollector struct {
+	pool *pgxpool.Pool
+
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	acquiredConns        *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	idleConns            *prometheus.Desc
+	maxConns             *prometheus.Desc
+	totalConns           *prometheus.Desc
+}
+
+// Returns a pointer to PoolCollector of the pool labelled with the name.
+func NewPoolCollector(pool *pgxpool.Pool, name string) *PoolCollector {
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgx_pool", metric), help,
+			nil, prometheus.Labels{"pool": name})
+	}
+
+	return &PoolCollector{
+		pool:                 pool,
+		acquireCount:         desc("acquire_total", "The number of successful acquires from the pool."),
+		acquireDuration:      desc("acquire_duration_seconds_total", "The total time of successful acquires from the pool."),
+		acquiredConns:        desc("acquired_connections", "The number of currently acquired connections."),
+		canceledAcquireCount: desc("canceled_acquire_total", "The number of acquires canceled by a context."),
+		constructingConns:    desc("constructing_connections", "The number of connections being constructed."),
+		emptyAcquireCount:    desc("empty_acquire_total", "The number of acquires that waited for a connection."),
+		idleConns:            desc("idle_connections", "The number of currently idle connections."),
+		maxConns:             desc("max_connections", "The maximum size of the pool."),
+		totalConns:           desc("connections", "The total number of connections in the pool."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.acquiredConns
+	ch <- c.canceledAcquireCount
+	ch <- c.constructingConns
+	ch <- c.emptyAcquireCount
+	ch <- c.idleConns
+	ch <- c.maxConns
+	ch <- c.totalConns
+}
+
+// Collect implements prometheus.Collector.
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+}
@@ -0,0 +1,233 @@
+// Package migration copies the links between the storage backends.
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+)
+
+// The number of records read and written at once by default.
+const defaultBatchSize = 500
+
+// ErrTargetNotEmpty is returned if the target has records and the migration is not resumed.
+var ErrTargetNotEmpty = errors.New("the target storage is not empty")
+
+// Options contains the settings of a migration.
+type Options struct {
+	// DryRun reads both storages and reports what would be copied without writing.
+	DryRun bool
+	// Resume continues an interrupted migration: the records already
+	// in the target are checked to be the same and skipped.
+	Resume bool
+	// BatchSize is the number of records written in a single transaction.
+	BatchSize int
+}
+
+// Report contains the results of a migration.
+type Report struct {
+	// Source is the number of the records in the source.
+	Source int
+	// Copied is the number of the records written to the target
+	// or to be written on a dry run.
+	Copied int
+	// Skipped is the number of the records already in the target.
+	Skipped int
+	// Checksum is the checksum of the records of the source.
+	Checksum string
+}
+
+// Run copies the records from the source to the target in batches ordered
+// by their keys, the target is read page by page alongside the source
+// to skip the records already there, and verifies that the target has the same number
+// of records with the same checksum afterwards.
+func Run(ctx context.Context, from, to storage.RecordStore, opts Options) (Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+
+	var report Report
+	target := &cursor{store: to, batchSize: opts.BatchSize}
+	if _, ok, err := target.next(ctx); err != nil {
+		return report, fmt.Errorf("read the target: %w", err)
+	} else if ok && !opts.Resume {
+		return report, fmt.Errorf("%w: resume to continue the migration", ErrTargetNotEmpty)
+	}
+
+	var batch []storage.Record
+	flush := func() error {
+		if len(batch) == 0 || opts.DryRun {
+			batch = batch[:0]
+			return nil
+		}
+		if err := to.ImportRecords(ctx, batch); err != nil {
+			return fmt.Errorf("write the records from %s to %s: %w",
+				name(batch[0]), name(batch[len(batch)-1]), err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	var checksum sum
+	err := each(ctx, from, opts.BatchSize, func(record storage.Record) error {
+		report.Source++
+		checksum.add(record)
+
+		existing, err := target.seek(ctx, record.Key())
+		if err != nil {
+			return fmt.Errorf("read the target: %w", err)
+		}
+		if existing != nil {
+			if line(*existing) != line(record) {
+				return fmt.Errorf("the record %s differs in the target", name(record))
+			}
+			report.Skipped++
+			return nil
+		}
+
+		report.Copied++
+		batch = append(batch, record)
+		if len(batch) < opts.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	report.Checksum = checksum.String()
+	if err != nil || opts.DryRun {
+		return report, err
+	}
+
+	return report, verify(ctx, to, opts.BatchSize, report)
+}
+
+// Checks that the target has the records of the report.
+func verify(ctx context.Context, to storage.RecordStore, batchSize int, report Report) error {
+	var count int
+	var checksum sum
+	err := each(ctx, to, batchSize, func(record storage.Record) error {
+		count++
+		checksum.add(record)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read the target: %w", err)
+	}
+
+	if count != report.Source {
+		return fmt.Errorf("verification failed: the target has %d records, the source has %d", count, report.Source)
+	}
+	if checksum.String() != report.Checksum {
+		return fmt.Errorf("verification failed: the checksum of the target %s differs from the source %s", checksum.String(), report.Checksum)
+	}
+	return nil
+}
+
+// Calls f for every record of the store in the order of the keys, see storage.LinkKey.
+func each(ctx context.Context, st storage.RecordStore, batchSize int, f func(record storage.Record) error) error {
+	after := ""
+	for {
+		records, err := st.ExportRecords(ctx, after, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := f(record); err != nil {
+				return err
+			}
+		}
+		if len(records) < batchSize {
+			return nil
+		}
+		after = records[len(records)-1].Key()
+	}
+}
+
+// cursor reads the records of a store page by page in the order of the keys.
+type cursor struct {
+	store     storage.RecordStore
+	batchSize int
+	page      []storage.Record
+	// after is the key of the last record read.
+	after string
+	// done is set once the last page is read, the records written
+	// by the migration after that are not read back.
+	done bool
+}
+
+// Returns the current record without moving past it, ok is false at the end.
+func (c *cursor) next(ctx context.Context) (storage.Record, bool, error) {
+	if len(c.page) == 0 && !c.done {
+		page, err := c.store.ExportRecords(ctx, c.after, c.batchSize)
+		if err != nil {
+			return storage.Record{}, false, err
+		}
+		c.page, c.done = page, len(page) < c.batchSize
+		if len(page) > 0 {
+			c.after = page[len(page)-1].Key()
+		}
+	}
+	if len(c.page) == 0 {
+		return storage.Record{}, false, nil
+	}
+	return c.page[0], true, nil
+}
+
+// Moves past the records before the key and returns the record with the key
+// or nil if there is no such record. The keys are sought in ascending order.
+func (c *cursor) seek(ctx context.Context, key string) (*storage.Record, error) {
+	for {
+		record, ok, err := c.next(ctx)
+		if err != nil || !ok || record.Key() > key {
+			return nil, err
+		}
+		c.page = c.page[1:]
+		if record.Key() == key {
+			return &record, nil
+		}
+	}
+}
+
+// Returns the canonical line of the record the checksums are computed over.
+// Only the creation date is compared since Postgres does not keep the time.
+func line(r storage.Record) string {
+	createdAt := ""
+	if !r.CreatedAt.IsZero() {
+		createdAt = r.CreatedAt.Format("2006-01-02")
+	}
+	return fmt.Sprintf("%q %q %d %t %d %q %s %q",
+		r.ShortLink, r.InitialLink, r.UserID, r.Deleted, r.DisabledStatus, r.DisabledReason, createdAt, r.Domain)
+}
+
+// Returns the name of the record in the errors: the short link and its domain if it is not the default one.
+func name(r storage.Record) string {
+	if r.Domain == "" {
+		return r.ShortLink
+	}
+	return r.Domain + "/" + r.ShortLink
+}
+
+// sum is a checksum of a set of records independent of their order,
+// the backends may order the short links differently. It is the sum
+// of the sha256 of the records modulo 2^256, so that the records
+// repeated twice do not cancel each other out as they do with xor.
+type sum [sha256.Size]byte
+
+func (s *sum) add(r storage.Record) {
+	h := sha256.Sum256([]byte(line(r)))
+	carry := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		v := int(s[i]) + int(h[i]) + carry
+		s[i], carry = byte(v), v>>8
+	}
+}
+
+func (s sum) String() string {
+	return hex.EncodeToString(s[:])
+}
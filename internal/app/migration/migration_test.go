@@ -0,0 +1,163 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+)
+
+// Returns n records ordered by their keys with the owners, the deleted flags, the moderation,
+// the creation dates and the short links repeated in another domain.
+func testRecords(n int) []storage.Record {
+	records := make([]storage.Record, n)
+	for i := range records {
+		records[i] = storage.Record{
+			ShortURL: storage.ShortURL{
+				InitialLink: fmt.Sprintf("https://example.com/%d", i),
+				ShortLink:   fmt.Sprintf("link%03d", i),
+				UserID:      uint32(i % 3),
+				Deleted:     i%4 == 0,
+			},
+			CreatedAt: time.Date(2021, time.March, 1+i%28, 0, 0, 0, 0, time.UTC),
+		}
+		if i%6 == 1 {
+			records[i].ShortLink = records[i-1].ShortLink
+			records[i].Domain = "go.brand.example"
+		}
+		if i%5 == 0 {
+			records[i].DisabledStatus = http.StatusGone
+			records[i].DisabledReason = "spam"
+		}
+	}
+	return records
+}
+
+func newSource(t *testing.T, records []storage.Record) storage.RecordStore {
+	st := storage.NewInFileStoragePath(filepath.Join(t.TempDir(), "links.json"))
+	require.NoError(t, st.ImportRecords(context.Background(), records))
+	return st
+}
+
+func newTarget(t *testing.T) storage.RecordStore {
+	st, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "links.sqlite"))
+	require.NoError(t, err)
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func exportAll(t *testing.T, st storage.RecordStore) []storage.Record {
+	records, err := st.ExportRecords(context.Background(), "", 1000)
+	require.NoError(t, err)
+	return records
+}
+
+func TestRun(t *testing.T) {
+	records := testRecords(25)
+	from, to := newSource(t, records), newTarget(t)
+
+	report, err := Run(context.Background(), from, to, Options{BatchSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 25, report.Source)
+	assert.Equal(t, 25, report.Copied)
+	assert.Zero(t, report.Skipped)
+	assert.NotEmpty(t, report.Checksum)
+
+	copied := exportAll(t, to)
+	require.Len(t, copied, len(records))
+	for i, want := range records {
+		assert.Equal(t, want.ShortURL, copied[i].ShortURL)
+		assert.True(t, want.CreatedAt.Equal(copied[i].CreatedAt), "the creation date of %s", want.ShortLink)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	from, to := newSource(t, testRecords(5)), newTarget(t)
+
+	report, err := Run(context.Background(), from, to, Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Copied)
+	assert.Empty(t, exportAll(t, to))
+}
+
+func TestRunTargetNotEmpty(t *testing.T) {
+	records := testRecords(5)
+	from, to := newSource(t, records), newTarget(t)
+	require.NoError(t, to.ImportRecords(context.Background(), records[:1]))
+
+	_, err := Run(context.Background(), from, to, Options{})
+	assert.ErrorIs(t, err, ErrTargetNotEmpty)
+	assert.Len(t, exportAll(t, to), 1)
+}
+
+func TestRunResume(t *testing.T) {
+	records := testRecords(25)
+	from, to := newSource(t, records), newTarget(t)
+	require.NoError(t, to.ImportRecords(context.Background(), records[:12]))
+
+	report, err := Run(context.Background(), from, to, Options{Resume: true, BatchSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 25, report.Source)
+	assert.Equal(t, 13, report.Copied)
+	assert.Equal(t, 12, report.Skipped)
+	assert.Len(t, exportAll(t, to), 25)
+}
+
+func TestRunResumeGaps(t *testing.T) {
+	records := testRecords(25)
+	from, to := newSource(t, records), newTarget(t)
+	var existing []storage.Record
+	for i := 0; i < len(records); i += 2 {
+		existing = append(existing, records[i])
+	}
+	require.NoError(t, to.ImportRecords(context.Background(), existing))
+
+	report, err := Run(context.Background(), from, to, Options{Resume: true, BatchSize: 4})
+	require.NoError(t, err)
+	assert.Equal(t, 12, report.Copied)
+	assert.Equal(t, 13, report.Skipped)
+	assert.Len(t, exportAll(t, to), 25)
+}
+
+func TestRunResumeDiffers(t *testing.T) {
+	records := testRecords(5)
+	from, to := newSource(t, records), newTarget(t)
+	changed := records[0]
+	changed.UserID = 42
+	require.NoError(t, to.ImportRecords(context.Background(), []storage.Record{changed}))
+
+	_, err := Run(context.Background(), from, to, Options{Resume: true})
+	assert.EqualError(t, err, "the record link000 differs in the target")
+}
+
+func TestSumRepeatedRecords(t *testing.T) {
+	records := testRecords(2)
+	var once, twice sum
+	once.add(records[0])
+	twice.add(records[0])
+	twice.add(records[1])
+	twice.add(records[1])
+	assert.NotEqual(t, once, twice)
+
+	var reordered sum
+	reordered.add(records[1])
+	reordered.add(records[0])
+	reordered.add(records[1])
+	assert.Equal(t, twice, reordered)
+}
+
+func TestRunVerifyFails(t *testing.T) {
+	from, to := newSource(t, testRecords(5)), newTarget(t)
+	extra := storage.Record{ShortURL: storage.ShortURL{InitialLink: "https://example.org", ShortLink: "zzz"}}
+	require.NoError(t, to.ImportRecords(context.Background(), []storage.Record{extra}))
+
+	_, err := Run(context.Background(), from, to, Options{Resume: true})
+	assert.EqualError(t, err, "verification failed: the target has 6 records, the source has 5")
+}
@@ -5,8 +5,11 @@
 package mock_storage
 
 import (
+	context "context"
 	reflect "reflect"
 
+	audit "github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	health "github.com/GorunovAlx/shortening_long_url/internal/app/health"
 	storage "github.com/GorunovAlx/shortening_long_url/internal/app/storage"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -35,106 +38,178 @@ func (m *MockShortURLRepo) EXPECT() *MockShortURLRepoMockRecorder {
 }
 
 // CheckURLsCreatedByUser mocks base method.
-func (m *MockShortURLRepo) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
+func (m *MockShortURLRepo) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CheckURLsCreatedByUser", links, id)
+	ret := m.ctrl.Call(m, "CheckURLsCreatedByUser", ctx, domain, links, id)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CheckURLsCreatedByUser indicates an expected call of CheckURLsCreatedByUser.
-func (mr *MockShortURLRepoMockRecorder) CheckURLsCreatedByUser(links, id interface{}) *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) CheckURLsCreatedByUser(ctx, domain, links, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckURLsCreatedByUser", reflect.TypeOf((*MockShortURLRepo)(nil).CheckURLsCreatedByUser), links, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckURLsCreatedByUser", reflect.TypeOf((*MockShortURLRepo)(nil).CheckURLsCreatedByUser), ctx, domain, links, id)
 }
 
 // CreateListShortURL mocks base method.
-func (m *MockShortURLRepo) CreateListShortURL(links []storage.ShortURLByUser) ([]storage.ShortURLByUser, error) {
+func (m *MockShortURLRepo) CreateListShortURL(ctx context.Context, links []storage.ShortURLByUser) ([]storage.ShortURLByUser, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateListShortURL", links)
+	ret := m.ctrl.Call(m, "CreateListShortURL", ctx, links)
 	ret0, _ := ret[0].([]storage.ShortURLByUser)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateListShortURL indicates an expected call of CreateListShortURL.
-func (mr *MockShortURLRepoMockRecorder) CreateListShortURL(links interface{}) *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) CreateListShortURL(ctx, links interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateListShortURL", reflect.TypeOf((*MockShortURLRepo)(nil).CreateListShortURL), links)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateListShortURL", reflect.TypeOf((*MockShortURLRepo)(nil).CreateListShortURL), ctx, links)
 }
 
 // CreateShortURL mocks base method.
-func (m *MockShortURLRepo) CreateShortURL(shortURL *storage.ShortURL) (string, error) {
+func (m *MockShortURLRepo) CreateShortURL(ctx context.Context, shortURL *storage.ShortURL) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateShortURL", shortURL)
+	ret := m.ctrl.Call(m, "CreateShortURL", ctx, shortURL)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateShortURL indicates an expected call of CreateShortURL.
-func (mr *MockShortURLRepoMockRecorder) CreateShortURL(shortURL interface{}) *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) CreateShortURL(ctx, shortURL interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateShortURL", reflect.TypeOf((*MockShortURLRepo)(nil).CreateShortURL), shortURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateShortURL", reflect.TypeOf((*MockShortURLRepo)(nil).CreateShortURL), ctx, shortURL)
 }
 
 // DeleteShortURLUser mocks base method.
-func (m *MockShortURLRepo) DeleteShortURLUser(link string, id uint32) error {
+func (m *MockShortURLRepo) DeleteShortURLUser(ctx context.Context, domain, link string, id uint32) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteShortURLUser", link, id)
+	ret := m.ctrl.Call(m, "DeleteShortURLUser", ctx, domain, link, id)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteShortURLUser indicates an expected call of DeleteShortURLUser.
-func (mr *MockShortURLRepoMockRecorder) DeleteShortURLUser(link, id interface{}) *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) DeleteShortURLUser(ctx, domain, link, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteShortURLUser", reflect.TypeOf((*MockShortURLRepo)(nil).DeleteShortURLUser), link, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteShortURLUser", reflect.TypeOf((*MockShortURLRepo)(nil).DeleteShortURLUser), ctx, domain, link, id)
+}
+
+// FindAuditEntries mocks base method.
+func (m *MockShortURLRepo) FindAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindAuditEntries", ctx, filter)
+	ret0, _ := ret[0].([]audit.Entry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindAuditEntries indicates an expected call of FindAuditEntries.
+func (mr *MockShortURLRepoMockRecorder) FindAuditEntries(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindAuditEntries", reflect.TypeOf((*MockShortURLRepo)(nil).FindAuditEntries), ctx, filter)
+}
+
+// FindShortURLs mocks base method.
+func (m *MockShortURLRepo) FindShortURLs(ctx context.Context, filter storage.ShortURLFilter) ([]storage.ShortURL, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindShortURLs", ctx, filter)
+	ret0, _ := ret[0].([]storage.ShortURL)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindShortURLs indicates an expected call of FindShortURLs.
+func (mr *MockShortURLRepoMockRecorder) FindShortURLs(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindShortURLs", reflect.TypeOf((*MockShortURLRepo)(nil).FindShortURLs), ctx, filter)
 }
 
 // GetAllShortURLUser mocks base method.
-func (m *MockShortURLRepo) GetAllShortURLUser(id uint32) ([]storage.ShortURLByUser, error) {
+func (m *MockShortURLRepo) GetAllShortURLUser(ctx context.Context, id uint32) ([]storage.ShortURLByUser, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllShortURLUser", id)
+	ret := m.ctrl.Call(m, "GetAllShortURLUser", ctx, id)
 	ret0, _ := ret[0].([]storage.ShortURLByUser)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllShortURLUser indicates an expected call of GetAllShortURLUser.
-func (mr *MockShortURLRepoMockRecorder) GetAllShortURLUser(id interface{}) *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) GetAllShortURLUser(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllShortURLUser", reflect.TypeOf((*MockShortURLRepo)(nil).GetAllShortURLUser), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllShortURLUser", reflect.TypeOf((*MockShortURLRepo)(nil).GetAllShortURLUser), ctx, id)
 }
 
 // GetInitialLink mocks base method.
-func (m *MockShortURLRepo) GetInitialLink(shortLink string) (string, error) {
+func (m *MockShortURLRepo) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetInitialLink", shortLink)
+	ret := m.ctrl.Call(m, "GetInitialLink", ctx, domain, shortLink)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetInitialLink indicates an expected call of GetInitialLink.
-func (mr *MockShortURLRepoMockRecorder) GetInitialLink(shortLink interface{}) *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) GetInitialLink(ctx, domain, shortLink interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInitialLink", reflect.TypeOf((*MockShortURLRepo)(nil).GetInitialLink), shortLink)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInitialLink", reflect.TypeOf((*MockShortURLRepo)(nil).GetInitialLink), ctx, domain, shortLink)
+}
+
+// HealthComponents mocks base method.
+func (m *MockShortURLRepo) HealthComponents() []health.Component {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HealthComponents")
+	ret0, _ := ret[0].([]health.Component)
+	return ret0
+}
+
+// HealthComponents indicates an expected call of HealthComponents.
+func (mr *MockShortURLRepoMockRecorder) HealthComponents() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HealthComponents", reflect.TypeOf((*MockShortURLRepo)(nil).HealthComponents))
+}
+
+// ModerateShortURL mocks base method.
+func (m_2 *MockShortURLRepo) ModerateShortURL(ctx context.Context, domain, link string, m storage.Moderation) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "ModerateShortURL", ctx, domain, link, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ModerateShortURL indicates an expected call of ModerateShortURL.
+func (mr *MockShortURLRepoMockRecorder) ModerateShortURL(ctx, domain, link, m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ModerateShortURL", reflect.TypeOf((*MockShortURLRepo)(nil).ModerateShortURL), ctx, domain, link, m)
 }
 
 // PingDB mocks base method.
-func (m *MockShortURLRepo) PingDB() error {
+func (m *MockShortURLRepo) PingDB(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PingDB")
+	ret := m.ctrl.Call(m, "PingDB", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // PingDB indicates an expected call of PingDB.
-func (mr *MockShortURLRepoMockRecorder) PingDB() *gomock.Call {
+func (mr *MockShortURLRepoMockRecorder) PingDB(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PingDB", reflect.TypeOf((*MockShortURLRepo)(nil).PingDB))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PingDB", reflect.TypeOf((*MockShortURLRepo)(nil).PingDB), ctx)
+}
+
+// ReassignShortURL mocks base method.
+func (m *MockShortURLRepo) ReassignShortURL(ctx context.Context, domain, link string, id uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReassignShortURL", ctx, domain, link, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReassignShortURL indicates an expected call of ReassignShortURL.
+func (mr *MockShortURLRepoMockRecorder) ReassignShortURL(ctx, domain, link, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReassignShortURL", reflect.TypeOf((*MockShortURLRepo)(nil).ReassignShortURL), ctx, domain, link, id)
 }
 
 // MockStorageOperations is a mock of StorageOperations interface.
@@ -160,103 +235,146 @@ func (m *MockStorageOperations) EXPECT() *MockStorageOperationsMockRecorder {
 	return m.recorder
 }
 
+// CheckHealth mocks base method.
+func (m *MockStorageOperations) CheckHealth(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckHealth", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckHealth indicates an expected call of CheckHealth.
+func (mr *MockStorageOperationsMockRecorder) CheckHealth(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckHealth", reflect.TypeOf((*MockStorageOperations)(nil).CheckHealth), ctx)
+}
+
 // CheckURLsCreatedByUser mocks base method.
-func (m *MockStorageOperations) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
+func (m *MockStorageOperations) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CheckURLsCreatedByUser", links, id)
+	ret := m.ctrl.Call(m, "CheckURLsCreatedByUser", ctx, domain, links, id)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CheckURLsCreatedByUser indicates an expected call of CheckURLsCreatedByUser.
-func (mr *MockStorageOperationsMockRecorder) CheckURLsCreatedByUser(links, id interface{}) *gomock.Call {
+func (mr *MockStorageOperationsMockRecorder) CheckURLsCreatedByUser(ctx, domain, links, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckURLsCreatedByUser", reflect.TypeOf((*MockStorageOperations)(nil).CheckURLsCreatedByUser), links, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckURLsCreatedByUser", reflect.TypeOf((*MockStorageOperations)(nil).CheckURLsCreatedByUser), ctx, domain, links, id)
 }
 
 // DeleteShortURLByUser mocks base method.
-func (m *MockStorageOperations) DeleteShortURLByUser(link string, id uint32) error {
+func (m *MockStorageOperations) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteShortURLByUser", link, id)
+	ret := m.ctrl.Call(m, "DeleteShortURLByUser", ctx, domain, link, id)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteShortURLByUser indicates an expected call of DeleteShortURLByUser.
-func (mr *MockStorageOperationsMockRecorder) DeleteShortURLByUser(link, id interface{}) *gomock.Call {
+func (mr *MockStorageOperationsMockRecorder) DeleteShortURLByUser(ctx, domain, link, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteShortURLByUser", reflect.TypeOf((*MockStorageOperations)(nil).DeleteShortURLByUser), link, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteShortURLByUser", reflect.TypeOf((*MockStorageOperations)(nil).DeleteShortURLByUser), ctx, domain, link, id)
+}
+
+// FindShortURLs mocks base method.
+func (m *MockStorageOperations) FindShortURLs(ctx context.Context, filter storage.ShortURLFilter) ([]storage.ShortURL, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindShortURLs", ctx, filter)
+	ret0, _ := ret[0].([]storage.ShortURL)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindShortURLs indicates an expected call of FindShortURLs.
+func (mr *MockStorageOperationsMockRecorder) FindShortURLs(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindShortURLs", reflect.TypeOf((*MockStorageOperations)(nil).FindShortURLs), ctx, filter)
 }
 
 // GetAllShortURLByUser mocks base method.
-func (m *MockStorageOperations) GetAllShortURLByUser(userID uint32) ([]storage.ShortURLByUser, error) {
+func (m *MockStorageOperations) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]storage.ShortURLByUser, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllShortURLByUser", userID)
+	ret := m.ctrl.Call(m, "GetAllShortURLByUser", ctx, userID)
 	ret0, _ := ret[0].([]storage.ShortURLByUser)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllShortURLByUser indicates an expected call of GetAllShortURLByUser.
-func (mr *MockStorageOperationsMockRecorder) GetAllShortURLByUser(userID interface{}) *gomock.Call {
+func (mr *MockStorageOperationsMockRecorder) GetAllShortURLByUser(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllShortURLByUser", reflect.TypeOf((*MockStorageOperations)(nil).GetAllShortURLByUser), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllShortURLByUser", reflect.TypeOf((*MockStorageOperations)(nil).GetAllShortURLByUser), ctx, userID)
 }
 
 // GetInitialLink mocks base method.
-func (m *MockStorageOperations) GetInitialLink(shortLink string) (string, error) {
+func (m *MockStorageOperations) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetInitialLink", shortLink)
+	ret := m.ctrl.Call(m, "GetInitialLink", ctx, domain, shortLink)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetInitialLink indicates an expected call of GetInitialLink.
-func (mr *MockStorageOperationsMockRecorder) GetInitialLink(shortLink interface{}) *gomock.Call {
+func (mr *MockStorageOperationsMockRecorder) GetInitialLink(ctx, domain, shortLink interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInitialLink", reflect.TypeOf((*MockStorageOperations)(nil).GetInitialLink), shortLink)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInitialLink", reflect.TypeOf((*MockStorageOperations)(nil).GetInitialLink), ctx, domain, shortLink)
 }
 
-// PingDB mocks base method.
-func (m *MockStorageOperations) PingDB() error {
+// UpdateModeration mocks base method.
+func (m_2 *MockStorageOperations) UpdateModeration(ctx context.Context, domain, link string, m storage.Moderation) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "UpdateModeration", ctx, domain, link, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateModeration indicates an expected call of UpdateModeration.
+func (mr *MockStorageOperationsMockRecorder) UpdateModeration(ctx, domain, link, m interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateModeration", reflect.TypeOf((*MockStorageOperations)(nil).UpdateModeration), ctx, domain, link, m)
+}
+
+// UpdateShortURLOwner mocks base method.
+func (m *MockStorageOperations) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PingDB")
+	ret := m.ctrl.Call(m, "UpdateShortURLOwner", ctx, domain, link, id)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// PingDB indicates an expected call of PingDB.
-func (mr *MockStorageOperationsMockRecorder) PingDB() *gomock.Call {
+// UpdateShortURLOwner indicates an expected call of UpdateShortURLOwner.
+func (mr *MockStorageOperationsMockRecorder) UpdateShortURLOwner(ctx, domain, link, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PingDB", reflect.TypeOf((*MockStorageOperations)(nil).PingDB))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateShortURLOwner", reflect.TypeOf((*MockStorageOperations)(nil).UpdateShortURLOwner), ctx, domain, link, id)
 }
 
 // WriteListShortURL mocks base method.
-func (m *MockStorageOperations) WriteListShortURL(links []storage.ShortURLByUser) error {
+func (m *MockStorageOperations) WriteListShortURL(ctx context.Context, links []storage.ShortURLByUser) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "WriteListShortURL", links)
+	ret := m.ctrl.Call(m, "WriteListShortURL", ctx, links)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // WriteListShortURL indicates an expected call of WriteListShortURL.
-func (mr *MockStorageOperationsMockRecorder) WriteListShortURL(links interface{}) *gomock.Call {
+func (mr *MockStorageOperationsMockRecorder) WriteListShortURL(ctx, links interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteListShortURL", reflect.TypeOf((*MockStorageOperations)(nil).WriteListShortURL), links)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteListShortURL", reflect.TypeOf((*MockStorageOperations)(nil).WriteListShortURL), ctx, links)
 }
 
 // WriteShortURL mocks base method.
-func (m *MockStorageOperations) WriteShortURL(shortURL *storage.ShortURL) error {
+func (m *MockStorageOperations) WriteShortURL(ctx context.Context, shortURL *storage.ShortURL) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "WriteShortURL", shortURL)
+	ret := m.ctrl.Call(m, "WriteShortURL", ctx, shortURL)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // WriteShortURL indicates an expected call of WriteShortURL.
-func (mr *MockStorageOperationsMockRecorder) WriteShortURL(shortURL interface{}) *gomock.Call {
+func (mr *MockStorageOperationsMockRecorder) WriteShortURL(ctx, shortURL interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteShortURL", reflect.TypeOf((*MockStorageOperations)(nil).WriteShortURL), shortURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteShortURL", reflect.TypeOf((*MockStorageOperations)(nil).WriteShortURL), ctx, shortURL)
 }
@@ -0,0 +1,249 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Rules the destination url can be rejected by.
+const (
+	RuleURL       = "url"
+	RuleScheme    = "scheme"
+	RuleHost      = "host"
+	RuleDenyList  = "deny_list"
+	RuleAllowList = "allow_list"
+	RulePrivateIP = "private_ip"
+)
+
+var errEmptyHost = errors.New("host is empty")
+
+// DefaultSchemes are allowed if no schemes are configured.
+var DefaultSchemes = []string{"http", "https"}
+
+// Policy contains the rules a destination url must satisfy.
+// Domains are matched exactly or by a wildcard like *.example.com,
+// which matches the subdomains of example.com but not example.com itself.
+// If AllowedDomains is empty, all the domains not denied are allowed.
+type Policy struct {
+	AllowedSchemes  []string
+	AllowedDomains  []string
+	DeniedDomains   []string
+	AllowPrivateIPs bool
+	ResolveHosts    bool
+	// lookupIP is replaced in tests.
+	lookupIP func(host string) ([]net.IP, error)
+}
+
+// Returns a pointer to Policy with the rules from config.
+func NewPolicy() *Policy {
+	cfg := configs.Get()
+	schemes := cfg.URLPolicyAllowedSchemes
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+
+	return &Policy{
+		AllowedSchemes:  schemes,
+		AllowedDomains:  cfg.URLPolicyAllowedDomains,
+		DeniedDomains:   cfg.URLPolicyDeniedDomains,
+		AllowPrivateIPs: cfg.URLPolicyAllowPrivateIPs,
+		ResolveHosts:    cfg.URLPolicyResolveHosts,
+		lookupIP:        net.LookupIP,
+	}
+}
+
+// Check applies the rules to the link and returns the link with
+// the host normalised: lowercased, without the trailing dot and
+// with an internationalised domain name converted to punycode.
+// A link without a scheme is checked as an http link and returned without it,
+// a link with any scheme, like javascript:, mailto: or data:, is checked by its scheme.
+// The error is *utils.PolicyViolationError naming the rule that rejected the link.
+func (p *Policy) Check(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", utils.NewPolicyViolationError(link, RuleURL, err.Error())
+	}
+
+	hasScheme := u.Scheme != "" && !isHostPort(link)
+	if !hasScheme {
+		if u, err = url.Parse("http://" + link); err != nil {
+			return "", utils.NewPolicyViolationError(link, RuleURL, err.Error())
+		}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !contains(p.AllowedSchemes, scheme) {
+		return "", utils.NewPolicyViolationError(link, RuleScheme, "scheme "+strconv.Quote(scheme)+" is not allowed")
+	}
+
+	host, err := normaliseHost(u.Hostname())
+	if err != nil {
+		return "", utils.NewPolicyViolationError(link, RuleHost, err.Error())
+	}
+
+	if pattern, ok := matchDomain(p.DeniedDomains, host); ok {
+		return "", utils.NewPolicyViolationError(link, RuleDenyList, "host "+host+" is denied by "+pattern)
+	}
+	if len(p.AllowedDomains) > 0 {
+		if _, ok := matchDomain(p.AllowedDomains, host); !ok {
+			return "", utils.NewPolicyViolationError(link, RuleAllowList, "host "+host+" is not in the allow list")
+		}
+	}
+
+	if !p.AllowPrivateIPs {
+		if reason, private := p.privateTarget(host); private {
+			return "", utils.NewPolicyViolationError(link, RulePrivateIP, reason)
+		}
+	}
+
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	u.Scheme = scheme
+
+	normalised := u.String()
+	if !hasScheme {
+		normalised = strings.TrimPrefix(normalised, "http://")
+	}
+	return normalised, nil
+}
+
+// Reports whether the link without a scheme starts with a host and a port like example.com:8080/a,
+// which is parsed as a url with the scheme example.com.
+func isHostPort(link string) bool {
+	i := strings.Index(link, ":")
+	if i < 0 || strings.HasPrefix(link[i:], "://") {
+		return false
+	}
+	port := link[i+1:]
+	if end := strings.IndexAny(port, "/?#"); end >= 0 {
+		port = port[:end]
+	}
+	_, err := strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
+
+// Reports whether the host targets a private, loopback or link-local address.
+// Host names are resolved only if the policy is configured to.
+func (p *Policy) privateTarget(host string) (string, bool) {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "host " + host + " is a loopback name", true
+	}
+
+	if ip := parseIP(host); ip != nil {
+		if isPrivate(ip) {
+			return "address " + ip.String() + " is private, loopback or link-local", true
+		}
+		return "", false
+	}
+
+	if !p.ResolveHosts {
+		return "", false
+	}
+
+	ips, err := p.lookupIP(host)
+	if err != nil {
+		return "host " + host + " cannot be resolved", true
+	}
+	for _, ip := range ips {
+		if isPrivate(ip) {
+			return "host " + host + " resolves to private address " + ip.String(), true
+		}
+	}
+	return "", false
+}
+
+// Lowercases the host, removes the trailing dot and converts
+// an internationalised domain name to punycode checking it is valid.
+func normaliseHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", errEmptyHost
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	return idna.Lookup.ToASCII(host)
+}
+
+// Returns the first pattern the host matches.
+func matchDomain(patterns []string, host string) (string, bool) {
+	for _, pattern := range patterns {
+		p, err := normaliseHost(strings.TrimPrefix(pattern, "*."))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, "."+p) {
+				return pattern, true
+			}
+		} else if host == p {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Parses the ip the way browsers do, so hosts like 2130706433
+// or 0x7f.1 are recognised as 127.0.0.1.
+func parseIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return nil
+		}
+		values[i] = v
+	}
+
+	// the last part fills the remaining bytes of the address.
+	var addr uint64
+	for i, v := range values[:len(values)-1] {
+		if v > 0xff {
+			return nil
+		}
+		addr |= v << (8 * (3 - i))
+	}
+	last := values[len(values)-1]
+	if last >= 1<<(8*(5-len(values))) {
+		return nil
+	}
+	addr |= last
+
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p := &Policy{
+		AllowedSchemes: DefaultSchemes,
+		DeniedDomains:  []string{"*.evil.com", "phishing.org"},
+		ResolveHosts:   true,
+		lookupIP: func(host string) ([]net.IP, error) {
+			if host == "intranet.example.com" {
+				return []net.IP{net.ParseIP("10.1.2.3")}, nil
+			}
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		},
+	}
+
+	tests := []struct {
+		name string
+		link string
+		want string
+		rule string
+	}{
+		{name: "allowed link", link: "https://example.com/path?q=1", want: "https://example.com/path?q=1"},
+		{name: "link without scheme", link: "google.com", want: "google.com"},
+		{name: "host is normalised", link: "HTTP://Example.COM./a", want: "http://example.com/a"},
+		{name: "idn host", link: "http://пример.рф/", want: "http://xn--e1afmkfd.xn--p1ai/"},
+		{name: "scheme is not allowed", link: "ftp://example.com/file", rule: RuleScheme},
+		{name: "javascript scheme", link: "javascript://alert(1)", rule: RuleScheme},
+		{name: "javascript scheme without slashes", link: "javascript:alert(1)", rule: RuleScheme},
+		{name: "mailto scheme", link: "mailto:x@y", rule: RuleScheme},
+		{name: "data scheme", link: "data:text/html,<script>alert(1)</script>", rule: RuleScheme},
+		{name: "link without scheme with port", link: "example.com:8080/a", want: "example.com:8080/a"},
+		{name: "link without scheme to localhost", link: "localhost:8080", rule: RulePrivateIP},
+		{name: "invalid idn host", link: "http://xn--a.com/", rule: RuleHost},
+		{name: "denied by wildcard", link: "https://login.evil.com/", rule: RuleDenyList},
+		{name: "wildcard does not match apex", link: "https://evil.com/", want: "https://evil.com/"},
+		{name: "denied exactly", link: "https://PHISHING.org/", rule: RuleDenyList},
+		{name: "loopback ip", link: "http://127.0.0.1:8080/admin", rule: RulePrivateIP},
+		{name: "loopback decimal ip", link: "http://2130706433/", rule: RulePrivateIP},
+		{name: "loopback hex ip", link: "http://0x7f.1/", rule: RulePrivateIP},
+		{name: "private ip", link: "http://192.168.1.1/", rule: RulePrivateIP},
+		{name: "link-local ip", link: "http://169.254.169.254/latest/meta-data", rule: RulePrivateIP},
+		{name: "loopback ipv6", link: "http://[::1]/", rule: RulePrivateIP},
+		{name: "localhost", link: "http://localhost:8080/", rule: RulePrivateIP},
+		{name: "host resolves to private ip", link: "http://intranet.example.com/", rule: RulePrivateIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Check(tt.link)
+			if tt.rule == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+				return
+			}
+
+			var violation *utils.PolicyViolationError
+			require.True(t, errors.As(err, &violation), "error: %v", err)
+			assert.Equal(t, tt.rule, violation.Rule)
+			assert.Equal(t, tt.link, violation.Link)
+		})
+	}
+}
+
+func TestPolicyCheckAllowList(t *testing.T) {
+	p := &Policy{
+		AllowedSchemes: DefaultSchemes,
+		AllowedDomains: []string{"example.com", "*.example.org"},
+	}
+
+	for _, link := range []string{"https://example.com/", "https://docs.example.org/"} {
+		_, err := p.Check(link)
+		assert.NoError(t, err)
+	}
+
+	_, err := p.Check("https://example.net/")
+	var violation *utils.PolicyViolationError
+	require.True(t, errors.As(err, &violation))
+	assert.Equal(t, RuleAllowList, violation.Rule)
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/dbpool"
+)
+
+// How often an instance deletes the expired buckets.
+const dbSweepInterval = time.Minute
+
+// DBStore keeps the buckets in Postgres, so the limits are shared
+// between all the instances of the service. The buckets are expired
+// once they are full again, they are equal to new ones then, and are
+// deleted once a minute by every instance. Every query is cancelled
+// after DATABASE_QUERY_TIMEOUT.
+type DBStore struct {
+	Postgres     *pgxpool.Pool
+	queryTimeout time.Duration
+
+	m     sync.Mutex
+	swept time.Time
+}
+
+// CheckHealth pings the database over a connection of the pool.
+func (s *DBStore) CheckHealth(ctx context.Context) error {
+	return s.Postgres.Ping(ctx)
+}
+
+// Returns a pointer to DBStore connected to the dsn with the pool settings
+// of the config, see dbpool.Config, and creates the rate_limits table.
+func NewDBStore(dsn string) (*DBStore, error) {
+	pool, err := dbpool.Connect(context.Background(), dsn, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &DBStore{
+		Postgres:     pool,
+		queryTimeout: configs.Get().DatabaseQueryTimeout,
+		swept:        time.Now(),
+	}
+
+	if err := store.CreateTable(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (dbs *DBStore) CreateTable() error {
+	sqlCreateStmt := `
+	create table if not exists public.rate_limits ( key varchar(256) constraint rate_limits_pk primary key,
+	tokens double precision not null, updated_at timestamptz not null );
+	alter table public.rate_limits add column if not exists expires_at timestamptz;
+	create index if not exists rate_limits_expires_at_idx on public.rate_limits (expires_at);`
+
+	ctx, cancel := dbpool.QueryContext(context.Background(), dbs.queryTimeout)
+	defer cancel()
+	_, err := dbs.Postgres.Exec(ctx, sqlCreateStmt)
+	return err
+}
+
+// Take takes a token from the bucket of the key,
+// the row of the bucket is locked until the transaction ends.
+func (dbs *DBStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	ctx, cancel := dbpool.QueryContext(ctx, dbs.queryTimeout)
+	defer cancel()
+
+	if err := dbs.sweep(ctx); err != nil {
+		return Result{}, err
+	}
+
+	tx, err := dbs.Postgres.Begin(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		"insert into rate_limits (key, tokens, updated_at) values ($1, $2, now()) on conflict (key) do nothing",
+		key,
+		limit.capacity(),
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var tokens float64
+	var elapsed time.Duration
+	err = tx.QueryRow(
+		ctx,
+		"select tokens, now() - updated_at from rate_limits where key = $1 for update",
+		key,
+	).Scan(&tokens, &elapsed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, result := take(tokens, elapsed, limit)
+
+	_, err = tx.Exec(
+		ctx,
+		"update rate_limits set tokens = $1, updated_at = now(), expires_at = now() + $2 where key = $3",
+		tokens,
+		result.Reset,
+		key,
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, tx.Commit(ctx)
+}
+
+// Refund puts back a token into the bucket of the key, up to its capacity.
+func (dbs *DBStore) Refund(ctx context.Context, key string, limit Limit) error {
+	ctx, cancel := dbpool.QueryContext(ctx, dbs.queryTimeout)
+	defer cancel()
+
+	_, err := dbs.Postgres.Exec(
+		ctx,
+		"update rate_limits set tokens = least(tokens + 1, $1) where key = $2",
+		limit.capacity(),
+		key,
+	)
+	return err
+}
+
+// Deletes the expired buckets if they have not been deleted for dbSweepInterval.
+func (dbs *DBStore) sweep(ctx context.Context) error {
+	dbs.m.Lock()
+	if time.Since(dbs.swept) < dbSweepInterval {
+		dbs.m.Unlock()
+		return nil
+	}
+	dbs.swept = time.Now()
+	dbs.m.Unlock()
+
+	_, err := dbs.Postgres.Exec(ctx, "delete from rate_limits where expires_at < now()")
+	return err
+}
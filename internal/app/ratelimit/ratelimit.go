@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+// Limit describes a token bucket: the bucket holds up to Burst tokens
+// and is refilled with Rate tokens per second, every request takes a token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result describes the state of the bucket after taking a token.
+// RetryAfter is the time until a token is available if the request is not allowed,
+// Reset is the time until the bucket is full again.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// Store keeps token buckets by a key of the client.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Refund puts back a token taken from the bucket of the key.
+	Refund(ctx context.Context, key string, limit Limit) error
+}
+
+// TakeAll takes a token from the bucket of every key until a bucket does not allow
+// the request, the tokens taken from the buckets before it are refunded then.
+// The result is of that bucket or else of the bucket with the fewest tokens left.
+func TakeAll(ctx context.Context, store Store, keys []string, limit Limit) (Result, error) {
+	var res Result
+	for i, key := range keys {
+		r, err := store.Take(ctx, key, limit)
+		if err != nil {
+			return Result{}, err
+		}
+		if !r.Allowed {
+			for _, taken := range keys[:i] {
+				if err := store.Refund(ctx, taken, limit); err != nil {
+					return Result{}, err
+				}
+			}
+			return r, nil
+		}
+		if i == 0 || r.Remaining < res.Remaining {
+			res = r
+		}
+	}
+	return res, nil
+}
+
+// Returns a Store from config: the Postgres store shared between
+// the instances of the service or the in memory store by default.
+func NewStore() (Store, error) {
+	if cfg := configs.Get(); cfg.RateLimitStore == "postgres" {
+		return NewDBStore(cfg.DatabaseDSN)
+	}
+
+	return NewInMemoryStore(), nil
+}
+
+// The scopes separating the buckets of the creates, the redirects and the deletes.
+const (
+	ScopeCreate   = "create"
+	ScopeRedirect = "redirect"
+	ScopeDelete   = "delete"
+)
+
+// ScopeLimit returns the limit of the scope from config,
+// the requests of an unknown scope are not limited.
+func ScopeLimit(scope string) Limit {
+	cfg := configs.Get()
+	switch scope {
+	case ScopeCreate:
+		return Limit{Rate: cfg.RateLimitCreateRPS, Burst: cfg.RateLimitCreateBurst}
+	case ScopeRedirect:
+		return Limit{Rate: cfg.RateLimitRedirectRPS, Burst: cfg.RateLimitRedirectBurst}
+	case ScopeDelete:
+		return Limit{Rate: cfg.RateLimitDeleteRPS, Burst: cfg.RateLimitDeleteBurst}
+	}
+	return Limit{}
+}
+
+// Keys returns the keys of the buckets the client is limited by: a known api key only,
+// or else the ip and the authenticated user, which is empty if there is none.
+// Every client is limited by its ip, as a new user is issued to anyone and would
+// get a new bucket. The user is not taken into account if the limiter is configured
+// to tell clients apart by ip only.
+func Keys(apiKey, ip, user string) []string {
+	keys := []string{"ip:" + ip}
+	if configs.Get().RateLimitKeyBy == "ip" {
+		return keys
+	}
+
+	if apiKey != "" && isAPIKey(apiKey) {
+		sum := sha256.Sum256([]byte(apiKey))
+		return []string{"key:" + hex.EncodeToString(sum[:])}
+	}
+	if user != "" {
+		keys = append(keys, "user:"+user)
+	}
+	return keys
+}
+
+func isAPIKey(key string) bool {
+	for _, apiKey := range configs.Get().APIKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled reports whether requests are limited at all.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// Returns the burst, at least the tokens refilled in a second.
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// Refills the bucket with tokens for the elapsed time and takes a token.
+// Returns the tokens left in the bucket and the result.
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	capacity := limit.capacity()
+	tokens = math.Min(capacity, tokens+elapsed.Seconds()*limit.Rate)
+
+	result := Result{
+		Limit: int(capacity),
+	}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((capacity - tokens) / limit.Rate)
+
+	return tokens, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// InMemoryStore keeps the buckets of the process in a map,
+// full buckets are swept out once a minute.
+type InMemoryStore struct {
+	buckets map[string]*bucket
+	swept   time.Time
+	m       sync.Mutex
+}
+
+// Returns a pointer to InMemoryStore.
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// Take takes a token from the bucket of the key.
+func (s *InMemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) > time.Minute {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens:  limit.capacity(),
+			updated: now,
+			limit:   limit,
+		}
+		s.buckets[key] = b
+	}
+
+	var result Result
+	b.tokens, result = take(b.tokens, now.Sub(b.updated), limit)
+	b.updated = now
+	b.limit = limit
+
+	return result, nil
+}
+
+// Refund puts back a token into the bucket of the key, up to its capacity.
+func (s *InMemoryStore) Refund(ctx context.Context, key string, limit Limit) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if b, ok := s.buckets[key]; ok {
+		b.tokens = math.Min(limit.capacity(), b.tokens+1)
+	}
+	return nil
+}
+
+// Removes the buckets that are full by now, they are equal to new ones.
+func (s *InMemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= b.limit.capacity() {
+			delete(s.buckets, key)
+		}
+	}
+	s.swept = now
+}
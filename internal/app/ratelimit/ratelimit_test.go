@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 3}
+
+	tokens, res := take(3, 0, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining)
+	assert.Equal(t, 3, res.Limit)
+
+	tokens, res = take(tokens-2, 0, limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	// half a second refills a token.
+	_, res = take(tokens, 500*time.Millisecond, limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1500*time.Millisecond, res.Reset)
+}
+
+func TestInMemoryStore(t *testing.T) {
+	store := NewInMemoryStore()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		res, err := store.Take(context.Background(), "user:1", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+
+	res, err := store.Take(context.Background(), "user:1", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// the buckets of other clients are not affected.
+	res, err = store.Take(context.Background(), "user:2", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestTakeAll(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	res, err := TakeAll(ctx, store, []string{"ip:1", "user:1"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	// the ip bucket is shared by the users of the ip.
+	res, err = TakeAll(ctx, store, []string{"ip:1", "user:2"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, err = TakeAll(ctx, store, []string{"ip:1", "user:3"}, limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestTakeAllRefundsOnDenial(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore()
+	limit := Limit{Rate: 0.001, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		res, err := store.Take(ctx, "user:1", limit)
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+	}
+
+	// the user bucket denies, the token of the ip bucket is put back.
+	for i := 0; i < 3; i++ {
+		res, err := TakeAll(ctx, store, []string{"ip:1", "user:1"}, limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+	}
+
+	res, err := TakeAll(ctx, store, []string{"ip:1", "user:2"}, limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+// BackendFactory opens a storage backend with the settings from config.
+type BackendFactory func(logger zerolog.Logger) (StorageOperations, error)
+
+// backendFactories contains the backends selectable by STORAGE_BACKEND.
+var backendFactories = map[string]BackendFactory{
+	"postgres": func(logger zerolog.Logger) (StorageOperations, error) {
+		st, err := NewDBStorage(logger)
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	},
+	"sqlite": func(logger zerolog.Logger) (StorageOperations, error) {
+		path, _ := configs.Get().SQLitePath()
+		st, err := NewSQLiteStorage(path)
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	},
+	"bolt": func(logger zerolog.Logger) (StorageOperations, error) {
+		st, err := NewBoltStorage(configs.Get().BoltPath)
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	},
+	"file": func(logger zerolog.Logger) (StorageOperations, error) {
+		return NewInFileStorage(), nil
+	},
+	"memory": func(logger zerolog.Logger) (StorageOperations, error) {
+		return NewInMemoryStorage(), nil
+	},
+}
+
+// RegisterBackend makes the backend selectable by the name,
+// it must be called before NewStorage.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendFactories[name] = factory
+}
+
+// Returns the backend derived from the settings when STORAGE_BACKEND is empty:
+// the database from the dsn, the bbolt database, the file or the memory.
+func defaultBackend(cfg *configs.Config) string {
+	if _, ok := cfg.SQLitePath(); ok {
+		return "sqlite"
+	}
+	switch {
+	case cfg.DatabaseDSN != "":
+		return "postgres"
+	case cfg.BoltPath != "":
+		return "bolt"
+	case cfg.FileStoragePath != "":
+		return "file"
+	}
+	return "memory"
+}
+
+// Returns STORAGE_BACKEND or the backend derived from the settings.
+func configuredBackend(cfg *configs.Config) string {
+	if cfg.StorageBackend != "" {
+		return cfg.StorageBackend
+	}
+	return defaultBackend(cfg)
+}
+
+// Opens the configured backend or the first backend of the fallback chain
+// which can be opened, the failures are logged. Returns the name of
+// the opened backend or the error of the last one.
+func openBackend(logger zerolog.Logger) (string, StorageOperations, error) {
+	cfg := configs.Get()
+	chain := append([]string{configuredBackend(cfg)}, cfg.StorageFallback...)
+
+	var err error
+	for i, name := range chain {
+		factory, ok := backendFactories[name]
+		if !ok {
+			err = fmt.Errorf("unknown storage backend %q", name)
+		} else {
+			var st StorageOperations
+			if st, err = factory(logger); err == nil {
+				return name, st, nil
+			}
+			err = fmt.Errorf("storage backend %s: %w", name, err)
+		}
+
+		if i < len(chain)-1 {
+			logger.Error().Err(err).Str("fallback", chain[i+1]).Msg("storage backend is unavailable")
+		}
+	}
+	return "", nil, err
+}
+
+// OpenRecordStore opens the backend by the url: postgres:// or postgresql://
+// for Postgres, sqlite://, bolt:// and file:// followed by the path of the file.
+func OpenRecordStore(rawURL string, logger zerolog.Logger) (RecordStore, error) {
+	i := strings.Index(rawURL, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("storage url %q: must be like file:///path", rawURL)
+	}
+	scheme, path := rawURL[:i], rawURL[i+len("://"):]
+
+	var st RecordStore
+	var err error
+	switch scheme {
+	case "postgres", "postgresql":
+		st, err = NewDBStorageDSN(rawURL, logger)
+	case "sqlite":
+		st, err = NewSQLiteStorage(path)
+	case "bolt":
+		st, err = NewBoltStorage(path)
+	case "file":
+		st = NewInFileStoragePath(path)
+	default:
+		return nil, fmt.Errorf("storage url %q: unknown scheme %q", rawURL, scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+// Registers the backend failing with the error for the test.
+func registerFailingBackend(t *testing.T, name string, err error) {
+	RegisterBackend(name, func(logger zerolog.Logger) (StorageOperations, error) {
+		return nil, err
+	})
+	t.Cleanup(func() { delete(backendFactories, name) })
+}
+
+func setBackendConfig(t *testing.T, change func(cfg *configs.Config)) {
+	old := *configs.Get()
+	cfg := old
+	cfg.StorageBackend = ""
+	cfg.StorageFallback = nil
+	change(&cfg)
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+}
+
+func TestDefaultBackend(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *configs.Config)
+		want   string
+	}{
+		{name: "memory", change: func(cfg *configs.Config) {}, want: "memory"},
+		{name: "file", change: func(cfg *configs.Config) { cfg.FileStoragePath = "links.json" }, want: "file"},
+		{name: "bolt", change: func(cfg *configs.Config) { cfg.BoltPath = "links.db" }, want: "bolt"},
+		{name: "sqlite", change: func(cfg *configs.Config) { cfg.DatabaseDSN = "sqlite://links.sqlite" }, want: "sqlite"},
+		{
+			name: "postgres over file",
+			change: func(cfg *configs.Config) {
+				cfg.DatabaseDSN = "postgres://localhost:5432/db"
+				cfg.FileStoragePath = "links.json"
+			},
+			want: "postgres",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg configs.Config
+			tt.change(&cfg)
+			assert.Equal(t, tt.want, defaultBackend(&cfg))
+		})
+	}
+}
+
+func TestOpenBackendFailFast(t *testing.T) {
+	registerFailingBackend(t, "broken", errors.New("connection refused"))
+	setBackendConfig(t, func(cfg *configs.Config) {
+		cfg.StorageBackend = "broken"
+	})
+
+	_, st, err := openBackend(zerolog.Nop())
+	assert.Nil(t, st)
+	assert.EqualError(t, err, "storage backend broken: connection refused")
+}
+
+func TestOpenBackendFallback(t *testing.T) {
+	registerFailingBackend(t, "broken", errors.New("connection refused"))
+	path := filepath.Join(t.TempDir(), "links.json")
+	setBackendConfig(t, func(cfg *configs.Config) {
+		cfg.StorageBackend = "broken"
+		cfg.StorageFallback = []string{"unknown", "file", "memory"}
+		cfg.FileStoragePath = path
+	})
+
+	name, st, err := openBackend(zerolog.Nop())
+	require.NoError(t, err)
+	assert.Equal(t, "file", name)
+	assert.IsType(t, &FileStorage{}, st)
+}
+
+func TestOpenBackendUnknown(t *testing.T) {
+	setBackendConfig(t, func(cfg *configs.Config) {
+		cfg.StorageBackend = "cassandra"
+	})
+
+	_, _, err := openBackend(zerolog.Nop())
+	assert.EqualError(t, err, `unknown storage backend "cassandra"`)
+}
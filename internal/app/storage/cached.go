@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// cachedStorage keeps the results of GetInitialLink of the wrapped storage
+// in a bounded LRU: the destinations for ttl, the missing and deleted links
+// for negativeTTL. The disabled links are not cached, moderation may enable
+// them again on another instance. A link is invalidated by every write, deletion
+// and edit of it made through the storage; the changes made by other
+// instances are not seen until the entry expires.
+type cachedStorage struct {
+	storage     StorageOperations
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	m       sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation is increased by every invalidation, a lookup started
+	// before an invalidation must not be cached.
+	generation uint64
+}
+
+// cacheEntry is the result of GetInitialLink for the key of the link, see LinkKey.
+type cacheEntry struct {
+	key     string
+	link    string
+	err     error
+	expires time.Time
+}
+
+// Returns the storage wrapped into the cache with the size and the ttls
+// from config, the storage as is if the size is zero.
+func withCache(st StorageOperations) StorageOperations {
+	cfg := configs.Get()
+	if cfg.CacheSize <= 0 {
+		return st
+	}
+	return newCachedStorage(st, cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL)
+}
+
+// Returns a pointer to cachedStorage wrapping the storage.
+func newCachedStorage(st StorageOperations, size int, ttl, negativeTTL time.Duration) *cachedStorage {
+	return &cachedStorage{
+		storage:     st,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// GetInitialLink returns the cached result or reads it from the storage.
+// The failures of the storage are not cached.
+func (c *cachedStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	key := LinkKey(domain, shortLink)
+	entry, generation, ok := c.get(key)
+	if ok {
+		metrics.CacheRequests.WithLabelValues("hit").Inc()
+		return entry.link, entry.err
+	}
+	metrics.CacheRequests.WithLabelValues("miss").Inc()
+
+	link, err := c.storage.GetInitialLink(ctx, domain, shortLink)
+	switch {
+	case err == nil:
+		c.add(generation, cacheEntry{key: key, link: link}, c.ttl)
+	case errors.Is(err, utils.ErrDisabledLink):
+		// not cached, see cachedStorage.
+	case errors.Is(err, utils.ErrNotFound), errors.Is(err, utils.ErrGone):
+		c.add(generation, cacheEntry{key: key, err: err}, c.negativeTTL)
+	}
+	return link, err
+}
+
+func (c *cachedStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	defer c.invalidate(shortURL.Key())
+	return c.storage.WriteShortURL(ctx, shortURL)
+}
+
+func (c *cachedStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) error {
+	defer func() {
+		for _, link := range links {
+			c.invalidate(LinkKey(link.Domain, link.ShortLink))
+		}
+	}()
+	return c.storage.WriteListShortURL(ctx, links)
+}
+
+func (c *cachedStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error) {
+	return c.storage.GetAllShortURLByUser(ctx, userID)
+}
+
+func (c *cachedStorage) CheckHealth(ctx context.Context) error {
+	return c.storage.CheckHealth(ctx)
+}
+
+func (c *cachedStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
+	defer c.invalidate(LinkKey(domain, link))
+	return c.storage.DeleteShortURLByUser(ctx, domain, link, id)
+}
+
+func (c *cachedStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	return c.storage.CheckURLsCreatedByUser(ctx, domain, links, id)
+}
+
+func (c *cachedStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	return c.storage.FindShortURLs(ctx, filter)
+}
+
+func (c *cachedStorage) UpdateModeration(ctx context.Context, domain, link string, m Moderation) error {
+	defer c.invalidate(LinkKey(domain, link))
+	return c.storage.UpdateModeration(ctx, domain, link, m)
+}
+
+func (c *cachedStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
+	defer c.invalidate(LinkKey(domain, link))
+	return c.storage.UpdateShortURLOwner(ctx, domain, link, id)
+}
+
+// Returns the entry of the key if it has not expired
+// and the current generation for the entry to be added on a miss.
+func (c *cachedStorage) get(key string) (cacheEntry, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, c.generation, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return cacheEntry{}, c.generation, false
+	}
+	c.lru.MoveToFront(elem)
+	return *entry, c.generation, true
+}
+
+// Adds the entry for the ttl unless the cache has been invalidated since
+// the generation, the least recently used entry is evicted if the cache is full.
+func (c *cachedStorage) add(generation uint64, entry cacheEntry, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	entry.expires = c.now().Add(ttl)
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = &entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(&entry)
+	metrics.CacheEntries.Inc()
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		metrics.CacheEvictions.Inc()
+	}
+}
+
+// Removes the entry of the key if any.
+func (c *cachedStorage) invalidate(key string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.generation++
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *cachedStorage) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	metrics.CacheEntries.Dec()
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// countingStorage counts the lookups reaching the in memory storage,
+// onGet is called before every lookup if it is set.
+type countingStorage struct {
+	*InMemoryStorage
+	gets  int
+	err   error
+	onGet func()
+}
+
+func (s *countingStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	s.gets++
+	if s.onGet != nil {
+		s.onGet()
+	}
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.InMemoryStorage.GetInitialLink(ctx, domain, shortLink)
+}
+
+// Returns the cache over the counting storage with the clock set by the returned func.
+func newTestCache(size int) (*cachedStorage, *countingStorage, func(time.Duration)) {
+	backend := &countingStorage{InMemoryStorage: NewInMemoryStorage()}
+	cache := newCachedStorage(backend, size, time.Minute, 5*time.Second)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	return cache, backend, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCachedStorageHit(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, advance := newTestCache(10)
+	require.NoError(t, cache.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+
+	for i := 0; i < 3; i++ {
+		link, err := cache.GetInitialLink(ctx, "", "abc")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", link)
+	}
+	assert.Equal(t, 1, backend.gets)
+
+	advance(time.Minute)
+	_, err := cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.gets, "the expired entry must be read again")
+}
+
+func TestCachedStorageNegative(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, advance := newTestCache(10)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.GetInitialLink(ctx, "", "abc")
+		assert.ErrorIs(t, err, utils.ErrLinkNotFound)
+	}
+	assert.Equal(t, 1, backend.gets)
+
+	advance(5 * time.Second)
+	_, err := cache.GetInitialLink(ctx, "", "abc")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound)
+	assert.Equal(t, 2, backend.gets)
+
+	require.NoError(t, cache.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	link, err := cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link, "the write must invalidate the missing link")
+}
+
+func TestCachedStorageDisabledNotCached(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, _ := newTestCache(10)
+	require.NoError(t, backend.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	moderation := Moderation{Disabled: true, Reason: "phishing", Status: http.StatusUnavailableForLegalReasons}
+	require.NoError(t, backend.UpdateModeration(ctx, "", "abc", moderation))
+
+	_, err := cache.GetInitialLink(ctx, "", "abc")
+	assert.ErrorIs(t, err, utils.ErrDisabledLink)
+
+	// enabled by another instance, past the cache.
+	require.NoError(t, backend.UpdateModeration(ctx, "", "abc", Moderation{}))
+	link, err := cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link)
+}
+
+func TestCachedStorageInvalidation(t *testing.T) {
+	ctx := context.Background()
+	cache, _, _ := newTestCache(10)
+	require.NoError(t, cache.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	_, err := cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+
+	moderation := Moderation{Disabled: true, Reason: "phishing", Status: http.StatusUnavailableForLegalReasons}
+	require.NoError(t, cache.UpdateModeration(ctx, "", "abc", moderation))
+	_, err = cache.GetInitialLink(ctx, "", "abc")
+	assert.ErrorIs(t, err, utils.ErrDisabledLink)
+
+	require.NoError(t, cache.UpdateModeration(ctx, "", "abc", Moderation{}))
+	link, err := cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link)
+}
+
+func TestCachedStorageDomains(t *testing.T) {
+	ctx := context.Background()
+	cache, _, _ := newTestCache(10)
+	require.NoError(t, cache.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	_, err := cache.GetInitialLink(ctx, "go.brand.example", "abc")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound)
+
+	require.NoError(t, cache.WriteShortURL(ctx, &ShortURL{InitialLink: "https://brand.example", ShortLink: "abc", Domain: "go.brand.example"}))
+	link, err := cache.GetInitialLink(ctx, "go.brand.example", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://brand.example", link, "the write must invalidate the link of its domain")
+	link, err = cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link)
+}
+
+func TestCachedStorageEviction(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, _ := newTestCache(2)
+	require.NoError(t, cache.WriteListShortURL(ctx, []ShortURLByUser{
+		{InitialLink: "https://a.example.com", ShortLink: "a"},
+		{InitialLink: "https://b.example.com", ShortLink: "b"},
+		{InitialLink: "https://c.example.com", ShortLink: "c"},
+	}))
+
+	for _, shortLink := range []string{"a", "b", "a", "c"} {
+		_, err := cache.GetInitialLink(ctx, "", shortLink)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, backend.gets)
+	assert.Equal(t, 2, cache.lru.Len())
+
+	// b is the least recently used one.
+	_, err := cache.GetInitialLink(ctx, "", "a")
+	require.NoError(t, err)
+	_, err = cache.GetInitialLink(ctx, "", "b")
+	require.NoError(t, err)
+	assert.Equal(t, 4, backend.gets)
+}
+
+func TestCachedStorageFailuresNotCached(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, _ := newTestCache(10)
+	backend.err = utils.NewUnavailableError(errors.New("connection refused"))
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.GetInitialLink(ctx, "", "abc")
+		assert.ErrorIs(t, err, utils.ErrUnavailable)
+	}
+	assert.Equal(t, 2, backend.gets)
+}
+
+func TestCachedStorageInvalidatedDuringLookup(t *testing.T) {
+	ctx := context.Background()
+	cache, backend, _ := newTestCache(10)
+
+	// the link is written while the lookup is waiting for the storage.
+	backend.onGet = func() {
+		backend.onGet = nil
+		require.NoError(t, cache.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	}
+	backend.err = utils.ErrLinkNotFound
+	_, err := cache.GetInitialLink(ctx, "", "abc")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound)
+
+	backend.err = nil
+	link, err := cache.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link, "the result read before the write must not be cached")
+}
@@ -4,36 +4,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
 	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/dbpool"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/tracing"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
 )
 
+// DBStorage keeps the links in Postgres, the writes go to the primary
+// and the reads of the links go to the replicas if there are any.
 type DBStorage struct {
-	dsn      string
-	Postgres *pgxpool.Pool
+	Postgres     *pgxpool.Pool
+	replicas     *replicaSet
+	queryTimeout time.Duration
 }
 
+// Returns the pool connected to the dsn, see pgxPoolConfig.
 func NewPGXPool(ctx context.Context, dsn string, logger pgx.Logger, logLevel pgx.LogLevel) (*pgxpool.Pool, error) {
-	conf, err := pgxpool.ParseConfig(dsn)
+	conf, err := pgxPoolConfig(dsn, logger, logLevel)
 	if err != nil {
 		return nil, err
 	}
 
-	conf.ConnConfig.Logger = logger
-
-	if logLevel != 0 {
-		conf.ConnConfig.LogLevel = logLevel
-	}
-
-	conf.MaxConns = 20
-	conf.MaxConnIdleTime = time.Second * 30
-	conf.MaxConnLifetime = time.Minute * 2
-
 	pool, err := pgxpool.ConnectConfig(ctx, conf)
 	if err != nil {
 		return nil, fmt.Errorf("pgx connection error: %w", err)
@@ -41,10 +40,54 @@ func NewPGXPool(ctx context.Context, dsn string, logger pgx.Logger, logLevel pgx
 	return pool, nil
 }
 
+// Returns the config of the pool of the primary and the replicas, see dbpool.Config,
+// preparing the queries of the links on connect in the prepare statement cache mode.
+func pgxPoolConfig(dsn string, logger pgx.Logger, logLevel pgx.LogLevel) (*pgxpool.Config, error) {
+	conf, err := dbpool.Config(dsn, logger, logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbpool.PreparesStatements() {
+		conf.AfterConnect = prepareStatements
+	}
+	return conf, nil
+}
+
+// The queries of the redirects and the creates prepared on every connection.
+// They are named by their sql, so pgx runs the prepared statement for the sql
+// if the connection has it and the sql as is otherwise.
+var preparedStatements = []string{getInitialLinkSQL, writeShortURLSQL, getShortURLsByUserSQL}
+
+const (
+	getInitialLinkSQL = `select initial_link, COALESCE(deleted, false), COALESCE(disabled_status, 0), COALESCE(disabled_reason, '')
+	from shortened_links where short_link=$1 and domain=$2`
+	writeShortURLSQL = `INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create, domain)
+	VALUES ($1, $2, $3, $4, $5) ON CONFLICT (domain, initial_link) DO NOTHING`
+	getShortURLsByUserSQL = `select initial_link, short_link, domain from shortened_links where user_id=$1`
+	getShortLinkSQL       = `select short_link from shortened_links where domain=$1 and initial_link=$2`
+)
+
+// Prepares the statements on the connection. Nothing is prepared until
+// the table is created and migrated, the first connection is made before it.
+func prepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for _, sql := range preparedStatements {
+		if _, err := conn.Prepare(ctx, sql, sql); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && (pgErr.Code == pgUndefinedTable ||
+				pgErr.Code == pgUndefinedColumn || pgErr.Code == pgInvalidColumnReference) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // LogLevelFromEnv returns the pgx.LogLevel from the environment variable PGX_LOG_LEVEL.
 // By default this is info (pgx.LogLevelInfo), which is good for development.
 func LogLevelFromEnv() (pgx.LogLevel, error) {
-	if level := configs.Cfg.PgxLogLevel; level != "" {
+	if level := configs.Get().PgxLogLevel; level != "" {
 		l, err := pgx.LogLevelFromString(level)
 		if err != nil {
 			return pgx.LogLevelDebug, fmt.Errorf("pgx configuration: %w", err)
@@ -54,42 +97,109 @@ func LogLevelFromEnv() (pgx.LogLevel, error) {
 	return pgx.LogLevelInfo, nil
 }
 
-// PGXStdLogger prints pgx logs to the standard logger.
-// os.Stderr by default.
-type PGXStdLogger struct{}
+// PGXZerologLogger writes pgx logs to the request logger from ctx
+// or to the logger if the query is not made by a request.
+type PGXZerologLogger struct {
+	logger zerolog.Logger
+}
 
-func (l *PGXStdLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
-	args := make([]interface{}, 0, len(data)+2) // making space for arguments + level + msg
-	args = append(args, level, msg)
-	for k, v := range data {
-		args = append(args, fmt.Sprintf("%s=%v", k, v))
+// Returns a pointer to PGXZerologLogger writing to the logger.
+func NewPGXZerologLogger(logger zerolog.Logger) *PGXZerologLogger {
+	return &PGXZerologLogger{
+		logger: logger.With().Str("component", "pgx").Logger(),
 	}
-	log.Println(args...)
 }
 
-func NewDBStorage() (*DBStorage, error) {
-	pgxLogLevel, err := LogLevelFromEnv()
+func (l *PGXZerologLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	logger := &l.logger
+	if ctxLogger := zerolog.Ctx(ctx); ctxLogger.GetLevel() != zerolog.Disabled {
+		sub := ctxLogger.With().Str("component", "pgx").Logger()
+		logger = &sub
+	}
+
+	var event *zerolog.Event
+	switch level {
+	case pgx.LogLevelTrace:
+		event = logger.Trace()
+	case pgx.LogLevelDebug:
+		event = logger.Debug()
+	case pgx.LogLevelInfo:
+		event = logger.Info()
+	case pgx.LogLevelWarn:
+		event = logger.Warn()
+	case pgx.LogLevelError:
+		event = logger.Error()
+	default:
+		event = logger.Log()
+	}
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		event = event.Str("trace_id", traceID)
+	}
+	event.Fields(data).Msg(msg)
+}
+
+// Returns a pointer to DBStorage connected to DATABASE_DSN reading
+// from DATABASE_REPLICA_DSNS, the pgx logs are written to the logger.
+// The replicas failing to connect are read from once they are healthy.
+func NewDBStorage(logger zerolog.Logger) (*DBStorage, error) {
+	cfg := configs.Get()
+	storage, err := NewDBStorageDSN(cfg.DatabaseDSN, logger)
+	if err != nil || len(cfg.DatabaseReplicaDSNs) == 0 {
+		return storage, err
+	}
+
+	pgxLogger, err := newPGXLogger(logger)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	storage.replicas, err = newReplicaSet(cfg.DatabaseReplicaDSNs, pgxLogger, pgxLogger.ConnLevel(),
+		cfg.DatabaseReplicaCheckInterval, cfg.DatabaseReplicaPinDuration, logger)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+	return storage, nil
+}
+
+// Returns a pointer to DBStorage connected to the dsn.
+func NewDBStorageDSN(dsn string, logger zerolog.Logger) (*DBStorage, error) {
+	pgxLogger, err := newPGXLogger(logger)
+	if err != nil {
+		return nil, err
 	}
 
-	pgPool, err := NewPGXPool(context.Background(), configs.Cfg.DatabaseDSN, &PGXStdLogger{}, pgxLogLevel)
+	pgPool, err := NewPGXPool(context.Background(), dsn, pgxLogger, pgxLogger.ConnLevel())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	storage := &DBStorage{
-		dsn:      configs.Cfg.DatabaseDSN,
-		Postgres: pgPool,
+		Postgres:     pgPool,
+		queryTimeout: configs.Get().DatabaseQueryTimeout,
 	}
 
 	if err := storage.Init(); err != nil {
+		pgPool.Close()
 		return nil, err
 	}
 
 	return storage, nil
 }
 
+// Returns the pgx logger writing to the logger at PGX_LOG_LEVEL.
+func newPGXLogger(logger zerolog.Logger) (*tracing.PgxLogger, error) {
+	pgxLogLevel, err := LogLevelFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &tracing.PgxLogger{Next: NewPGXZerologLogger(logger), Level: pgxLogLevel}, nil
+}
+
+// Nodes returns the primary and the replicas.
+func (dbs *DBStorage) Nodes() []Node {
+	return append([]Node{{Name: "primary", Pool: dbs.Postgres}}, dbs.replicas.nodes()...)
+}
+
 func (dbs *DBStorage) Init() error {
 	if err := dbs.CreateTable(); err != nil {
 		return err
@@ -97,20 +207,49 @@ func (dbs *DBStorage) Init() error {
 	return nil
 }
 
-func (dbs *DBStorage) GetInitialLink(shortLink string) (string, error) {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return "", e
+// Returns the context of a query cancelled after DATABASE_QUERY_TIMEOUT.
+func (dbs *DBStorage) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return dbpool.QueryContext(ctx, dbs.queryTimeout)
+}
+
+// Runs f in a transaction committed if f returns nil.
+// Every query of the transaction has its own timeout, see execTx.
+func (dbs *DBStorage) inTx(ctx context.Context, f func(tx pgx.Tx) error) error {
+	beginCtx, cancel := dbs.queryContext(ctx)
+	defer cancel()
+	tx, err := dbs.Postgres.Begin(beginCtx)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := f(tx); err != nil {
+		return dbError(err)
 	}
-	defer conn.Release()
 
-	var iLink string
+	commitCtx, cancel := dbs.queryContext(ctx)
+	defer cancel()
+	return dbError(tx.Commit(commitCtx))
+}
+
+// Runs the statement of the transaction cancelled after DATABASE_QUERY_TIMEOUT.
+func (dbs *DBStorage) execTx(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := dbs.queryContext(ctx)
+	defer cancel()
+	return tx.Exec(ctx, sql, args...)
+}
+
+func (dbs *DBStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	var iLink, disabledReason string
 	var deleted bool
-	err := conn.QueryRow(
-		context.Background(),
-		"select initial_link, COALESCE(deleted, false) from shortened_links where short_link=$1",
-		shortLink,
-	).Scan(&iLink, &deleted)
+	var disabledStatus int
+	err := dbs.replicas.read(ctx, dbs.Postgres, []string{linkKey(domain, shortLink)}, func(pool *pgxpool.Pool) error {
+		ctx, cancel := dbs.queryContext(ctx)
+		defer cancel()
+
+		return dbError(pool.QueryRow(ctx, getInitialLinkSQL, shortLink, domain).
+			Scan(&iLink, &deleted, &disabledStatus, &disabledReason))
+	})
 	if err != nil {
 		return "", err
 	}
@@ -120,201 +259,420 @@ func (dbs *DBStorage) GetInitialLink(shortLink string) (string, error) {
 		return "", err
 	}
 
+	if disabledStatus != 0 {
+		err = utils.NewDisabledLinkError(shortLink, disabledReason, disabledStatus)
+		return "", err
+	}
+
 	return iLink, nil
 }
 
-func (dbs *DBStorage) WriteShortURL(shortURL *ShortURL) error {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return e
+func (dbs *DBStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	err := dbs.inTx(ctx, func(tx pgx.Tx) error {
+		commandTag, err := dbs.execTx(
+			ctx,
+			tx,
+			writeShortURLSQL,
+			shortURL.InitialLink,
+			shortURL.ShortLink,
+			shortURL.UserID,
+			time.Now(),
+			shortURL.Domain,
+		)
+		if err != nil {
+			return err
+		}
+		if commandTag.RowsAffected() != 1 {
+			queryCtx, cancel := dbs.queryContext(ctx)
+			defer cancel()
+			err = tx.QueryRow(queryCtx, getShortLinkSQL, shortURL.Domain, shortURL.InitialLink).Scan(&shortURL.ShortLink)
+			if err != nil {
+				return err
+			}
+			return utils.NewInsertUniqueLinkError(shortURL.InitialLink)
+		}
+		return dbs.writeAudit(ctx, tx)
+	})
+	if err != nil {
+		return err
 	}
-	defer conn.Release()
+	dbs.replicas.pin(linkKey(shortURL.Domain, shortURL.ShortLink), userKey(shortURL.UserID))
+	return nil
+}
 
-	insertStatement := `
-	INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create)
-	VALUES ($1, $2, $3, $4) ON CONFLICT (initial_link) DO NOTHING;`
+func (dbs *DBStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error) {
+	var result []ShortURLByUser
+	err := dbs.replicas.read(ctx, dbs.Postgres, []string{userKey(userID)}, func(pool *pgxpool.Pool) error {
+		ctx, cancel := dbs.queryContext(ctx)
+		defer cancel()
 
-	commandTag, err := conn.Exec(
-		context.Background(),
-		insertStatement,
-		shortURL.InitialLink,
-		shortURL.ShortLink,
-		shortURL.UserID,
-		time.Now(),
-	)
+		result = nil
+		rows, err := pool.Query(ctx, getShortURLsByUserSQL, userID)
+		if err != nil {
+			return dbError(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var s ShortURLByUser
+			err = rows.Scan(&s.InitialLink, &s.ShortLink, &s.Domain)
+			if err != nil {
+				return dbError(err)
+			}
+			result = append(result, s)
+		}
 
+		return dbError(rows.Err())
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if commandTag.RowsAffected() != 1 {
-		err = utils.NewInsertUniqueLinkError(shortURL.InitialLink)
+
+	return result, nil
+}
+
+// CheckHealth pings the database over a connection of the pool.
+func (dbs *DBStorage) CheckHealth(ctx context.Context) error {
+	return dbError(dbs.Postgres.Ping(ctx))
+}
+
+func (dbs *DBStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) error {
+	err := dbs.inTx(ctx, func(tx pgx.Tx) error {
+		for _, l := range links {
+			if _, err := dbs.execTx(
+				ctx,
+				tx,
+				"INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create, domain) VALUES ($1, $2, $3, $4, $5)",
+				l.InitialLink,
+				l.ShortLink,
+				nil,
+				time.Now(),
+				l.Domain,
+			); err != nil {
+				return err
+			}
+		}
+		return dbs.writeAudit(ctx, tx)
+	})
+	if err != nil {
 		return err
 	}
+
+	for _, l := range links {
+		dbs.replicas.pin(linkKey(l.Domain, l.ShortLink))
+	}
 	return nil
 }
 
-func (dbs *DBStorage) GetAllShortURLByUser(userID uint32) ([]ShortURLByUser, error) {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return nil, e
-	}
-	defer conn.Release()
+func (dbs *DBStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	ctx, cancel := dbs.queryContext(ctx)
+	defer cancel()
 
-	var result []ShortURLByUser
+	var result []string
 
-	selectStatement := "select initial_link, short_link from shortened_links where user_id=$1"
-	rows, err := conn.Query(context.Background(), selectStatement, userID)
+	selectStatement := `with temp as (
+		select short_link 
+		from public.shortened_links
+		where user_id = $1 and domain = $3)
+		
+		select links.short_link from
+		(select unnest(ARRAY[$2::varchar[]]) short_link) links
+		except
+		select short_link
+		from temp`
+	rows, err := dbs.Postgres.Query(ctx, selectStatement, id, links, domain)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var s ShortURLByUser
-		err = rows.Scan(&s.InitialLink, &s.ShortLink)
+		var s string
+		err = rows.Scan(&s)
 		if err != nil {
-			return nil, err
+			return nil, dbError(err)
 		}
-		s.ShortLink = configs.Cfg.BaseURL + "/" + s.ShortLink
+
 		result = append(result, s)
 	}
 
 	if rows.Err() != nil {
-		return nil, rows.Err()
+		return nil, dbError(rows.Err())
 	}
 
 	return result, nil
 }
 
-func (dbs *DBStorage) PingDB() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func (dbs *DBStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
+	sqlStmt := `
+	update shortened_links set deleted = true 
+	where user_id = $1 and short_link = $2 and domain = $3;`
 
-	dbPool, err := pgxpool.Connect(ctx, dbs.dsn)
+	err := dbs.inTx(ctx, func(tx pgx.Tx) error {
+		if _, err := dbs.execTx(ctx, tx, sqlStmt, id, link, domain); err != nil {
+			return err
+		}
+		return dbs.writeAudit(ctx, tx)
+	})
 	if err != nil {
 		return err
 	}
-	defer dbPool.Close()
-
-	if dbPool != nil {
-		return nil
-	}
+	dbs.replicas.pin(linkKey(domain, link), userKey(id))
 
-	return errors.New("ping attempt failed")
+	return nil
 }
 
-func (dbs *DBStorage) WriteListShortURL(links []ShortURLByUser) error {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return e
+func (dbs *DBStorage) CreateTable() error {
+	ctx := context.Background()
+
+	sqlCreateStmt := `
+	create table if not exists public.shortened_links ( id bigserial constraint shortened_link_pk primary key,
+	` + shortenedLinksColumns + ` ); alter table public.shortened_links owner to postgres;`
+
+	_, err := dbs.Postgres.Exec(ctx, sqlCreateStmt)
+	if err != nil {
+		return err
 	}
-	defer conn.Release()
 
-	tx, err := conn.Begin(context.Background())
+	// the initial links were unique over all the links before the domains.
+	sqlAlterStmt := `
+	alter table public.shortened_links
+	add column if not exists disabled_status int,
+	add column if not exists disabled_reason varchar(256),
+	add column if not exists ` + domainColumn + `;
+	alter table public.shortened_links drop constraint if exists shortened_links_initial_link_key;`
+
+	_, err = dbs.Postgres.Exec(ctx, sqlAlterStmt)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback(context.Background())
 
-	for _, l := range links {
-		if _, err = tx.Conn().Exec(
-			context.Background(),
-			"INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create) VALUES ($1, $2, $3, $4)",
-			l.InitialLink,
-			l.ShortLink,
-			nil,
-			time.Now(),
-		); err != nil {
+	for _, stmt := range schemaIndexes {
+		if _, err = dbs.Postgres.Exec(ctx, stmt); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit(context.Background())
+	return nil
 }
 
-func (dbs *DBStorage) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return nil, e
-	}
-	defer conn.Release()
-
-	var result []string
+func (dbs *DBStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	ctx, cancel := dbs.queryContext(ctx)
+	defer cancel()
 
-	selectStatement := `with temp as (
-		select short_link 
-		from public.shortened_links
-		where user_id = $1)
-		
-		select links.short_link from
-		(select unnest(ARRAY[$2::varchar[]]) short_link) links
-		except
-		select short_link
-		from temp`
-	rows, err := conn.Query(context.Background(), selectStatement, id, links)
+	var result []ShortURL
+
+	selectStatement := `
+	select initial_link, short_link, domain, COALESCE(user_id, 0), COALESCE(deleted, false),
+	COALESCE(disabled_status, 0), COALESCE(disabled_reason, '')
+	from shortened_links
+	where ($1 = '' or initial_link ilike '%' || $1 || '%')
+	and ($2 = '' or short_link = $2)
+	and ($3::bigint is null or user_id = $3)
+	and ($4::varchar is null or domain = $4)
+	order by id`
+	rows, err := dbs.Postgres.Query(ctx, selectStatement, filter.InitialLink, filter.ShortLink, filter.UserID, filter.Domain)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var s string
-		err = rows.Scan(&s)
+		var s ShortURL
+		var userID int64
+		err = rows.Scan(&s.InitialLink, &s.ShortLink, &s.Domain, &userID, &s.Deleted, &s.DisabledStatus, &s.DisabledReason)
 		if err != nil {
-			return nil, err
+			return nil, dbError(err)
 		}
-
+		s.UserID = uint32(userID)
 		result = append(result, s)
 	}
 
 	if rows.Err() != nil {
-		return nil, rows.Err()
+		return nil, dbError(rows.Err())
 	}
 
 	return result, nil
 }
 
-func (dbs *DBStorage) DeleteShortURLByUser(link string, id uint32) error {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return e
+func (dbs *DBStorage) UpdateModeration(ctx context.Context, domain, link string, m Moderation) error {
+	var status *int
+	var reason *string
+	if m.Disabled {
+		status, reason = &m.Status, &m.Reason
 	}
-	defer conn.Release()
 
 	sqlStmt := `
-	update shortened_links set deleted = true 
-	where user_id = $1 and short_link = $2;`
+	update shortened_links set disabled_status = $1, disabled_reason = $2
+	where short_link = $3 and domain = $4;`
 
-	_, err := conn.Exec(
-		context.Background(),
-		sqlStmt,
-		id,
-		link,
-	)
+	if err := dbs.updateLink(ctx, sqlStmt, status, reason, link, domain); err != nil {
+		return err
+	}
+	dbs.replicas.pin(linkKey(domain, link))
 
-	if err != nil {
+	return nil
+}
+
+func (dbs *DBStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
+	sqlStmt := `
+	update shortened_links set user_id = $1
+	where short_link = $2 and domain = $3;`
+
+	if err := dbs.updateLink(ctx, sqlStmt, id, link, domain); err != nil {
 		return err
 	}
+	dbs.replicas.pin(linkKey(domain, link), userKey(id))
 
 	return nil
 }
 
-func (dbs *DBStorage) CreateTable() error {
-	conn, e := dbs.Postgres.Acquire(context.Background())
-	if e != nil {
-		return e
+// Runs the update of a single link in a transaction with its audit entries,
+// the link is not found if the update affects no row.
+func (dbs *DBStorage) updateLink(ctx context.Context, sql string, args ...interface{}) error {
+	return dbs.inTx(ctx, func(tx pgx.Tx) error {
+		commandTag, err := dbs.execTx(ctx, tx, sql, args...)
+		if err != nil {
+			return err
+		}
+		if commandTag.RowsAffected() == 0 {
+			return utils.ErrLinkNotFound
+		}
+		return dbs.writeAudit(ctx, tx)
+	})
+}
+
+// Writes the entries of the mutation applied with ctx to the audit trail
+// in the transaction of the mutation, see ShortURLStorage.audited.
+func (dbs *DBStorage) writeAudit(ctx context.Context, tx pgx.Tx) error {
+	pending := pendingAuditFromContext(ctx)
+	if pending == nil {
+		return nil
 	}
-	defer conn.Release()
 
-	sqlCreateStmt := `
-	create table if not exists public.shortened_links ( id bigserial constraint shortened_link_pk primary key,
-	initial_link varchar(256) not null unique, short_link varchar(256) not null, user_id bigint,
-	date_of_create date, deleted boolean ); alter table public.shortened_links owner to postgres;`
+	for _, entry := range pending.entries {
+		if err := pending.recorder.RecordTx(ctx, tx, entry); err != nil {
+			metrics.AuditWriteErrors.Inc()
+			return err
+		}
+	}
+	pending.written = true
+	return nil
+}
 
-	_, err := conn.Exec(context.Background(), sqlCreateStmt)
+// Close closes the connections of the primary and the replicas.
+func (dbs *DBStorage) Close() error {
+	dbs.replicas.close()
+	dbs.Postgres.Close()
+	return nil
+}
+
+func (dbs *DBStorage) ExportRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	ctx, cancel := dbs.queryContext(ctx)
+	defer cancel()
+
+	var result []Record
+
+	selectStatement := `
+	select initial_link, short_link, domain, COALESCE(user_id, 0), COALESCE(deleted, false),
+	COALESCE(disabled_status, 0), COALESCE(disabled_reason, ''), date_of_create
+	from shortened_links
+	where (short_link, domain) > ($1, $2)
+	order by short_link, domain
+	limit $3`
+	afterDomain, afterLink := splitLinkKey(after)
+	rows, err := dbs.Postgres.Query(ctx, selectStatement, afterLink, afterDomain, limit)
 	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Record
+		var userID int64
+		var createdAt *time.Time
+		err = rows.Scan(&r.InitialLink, &r.ShortLink, &r.Domain, &userID, &r.Deleted, &r.DisabledStatus, &r.DisabledReason, &createdAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		r.UserID = uint32(userID)
+		if createdAt != nil {
+			r.CreatedAt = *createdAt
+		}
+		result = append(result, r)
+	}
+
+	if rows.Err() != nil {
+		return nil, dbError(rows.Err())
+	}
+
+	return result, nil
+}
+
+// ImportRecords writes the records in a single transaction.
+func (dbs *DBStorage) ImportRecords(ctx context.Context, records []Record) error {
+	return dbs.inTx(ctx, func(tx pgx.Tx) error {
+		for _, r := range records {
+			status, reason, createdAt := r.nullColumns()
+			commandTag, err := dbs.execTx(
+				ctx,
+				tx,
+				`INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create, deleted, disabled_status, disabled_reason, domain)
+				SELECT $1::varchar, $2::varchar, $3::bigint, $4::date, $5::boolean, $6::int, $7::varchar, $8::varchar
+				WHERE NOT EXISTS (select 1 from shortened_links where short_link=$2 and domain=$8)`,
+				r.InitialLink,
+				r.ShortLink,
+				r.UserID,
+				createdAt,
+				r.Deleted,
+				status,
+				reason,
+				r.Domain,
+			)
+			if err != nil {
+				return err
+			}
+			if commandTag.RowsAffected() == 0 {
+				return utils.NewInsertUniqueLinkError(r.ShortLink)
+			}
+		}
+		return nil
+	})
+}
+
+// The codes and the classes of codes of the Postgres errors.
+const (
+	pgUniqueViolation        = "23505"
+	pgUndefinedTable         = "42P01"
+	pgUndefinedColumn        = "42703"
+	pgInvalidColumnReference = "42P10"
+	pgConnectionException    = "08"
+	pgInsufficientResources  = "53"
+	pgOperatorIntervention   = "57"
+)
+
+// Returns the error of the storage as an error of its kind: no rows is utils.ErrLinkNotFound,
+// a unique violation is utils.ErrUniqueLink, the errors of the connection
+// and of the server resources are utils.ErrUnavailable. The other errors of Postgres are returned as is.
+func dbError(err error) error {
+	if err == nil || utils.Kind(err) != nil {
 		return err
 	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return utils.ErrLinkNotFound
+	}
 
-	return nil
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return utils.NewUnavailableError(err)
+	}
+	switch {
+	case pgErr.Code == pgUniqueViolation:
+		return utils.ErrUniqueLink
+	case strings.HasPrefix(pgErr.Code, pgConnectionException),
+		strings.HasPrefix(pgErr.Code, pgInsufficientResources),
+		strings.HasPrefix(pgErr.Code, pgOperatorIntervention):
+		return utils.NewUnavailableError(err)
+	}
+	return err
 }
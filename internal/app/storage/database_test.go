@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+func TestPGXPoolConfig(t *testing.T) {
+	setBackendConfig(t, func(cfg *configs.Config) {
+		cfg.DatabaseMaxConns = 50
+		cfg.DatabaseMinConns = 5
+		cfg.DatabaseMaxConnIdleTime = time.Minute
+		cfg.DatabaseMaxConnLifetime = time.Hour
+		cfg.DatabaseHealthCheckPeriod = 10 * time.Second
+		cfg.DatabaseConnectTimeout = 3 * time.Second
+		cfg.DatabaseStatementCacheMode = "prepare"
+		cfg.DatabaseStatementCacheCapacity = 128
+	})
+
+	conf, err := pgxPoolConfig(unreachableDSN, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int32(50), conf.MaxConns)
+	assert.Equal(t, int32(5), conf.MinConns)
+	assert.Equal(t, time.Minute, conf.MaxConnIdleTime)
+	assert.Equal(t, time.Hour, conf.MaxConnLifetime)
+	assert.Equal(t, 10*time.Second, conf.HealthCheckPeriod)
+	assert.Equal(t, 3*time.Second, conf.ConnConfig.ConnectTimeout)
+	assert.NotNil(t, conf.ConnConfig.BuildStatementCache)
+	assert.NotNil(t, conf.AfterConnect, "the statements are prepared on connect")
+}
+
+func TestPGXPoolConfigStatementCacheMode(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantCache   bool
+		wantPrepare bool
+	}{
+		{mode: "prepare", wantCache: true, wantPrepare: true},
+		{mode: "describe", wantCache: true},
+		{mode: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			setBackendConfig(t, func(cfg *configs.Config) {
+				cfg.DatabaseStatementCacheMode = tt.mode
+				cfg.DatabaseStatementCacheCapacity = 512
+			})
+
+			conf, err := pgxPoolConfig(unreachableDSN, nil, 0)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCache, conf.ConnConfig.BuildStatementCache != nil)
+			assert.Equal(t, tt.wantPrepare, conf.AfterConnect != nil)
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// The buckets of BoltStorage: the links by their keys, the short links
+// by the keys of the initial links and the links of every user keyed
+// by the user id followed by the key of the link, see LinkKey.
+var (
+	boltLinksBucket        = []byte("links")
+	boltDestinationsBucket = []byte("destinations")
+	boltUsersBucket        = []byte("users")
+)
+
+// BoltStorage keeps the links in an embedded bbolt database file,
+// every operation is a transaction of the database.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// Returns a pointer to BoltStorage over the database file at the path,
+// the file and the buckets are created if they do not exist.
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltLinksBucket, boltDestinationsBucket, boltUsersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+// Close closes the database file.
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	var link string
+	err := b.view(func(tx *bolt.Tx) error {
+		record, err := getBoltLink(tx, LinkKey(domain, shortLink))
+		if err != nil {
+			return err
+		}
+		if record.Deleted {
+			return utils.NewDeletedLinkError(shortLink)
+		}
+		if record.DisabledStatus != 0 {
+			return utils.NewDisabledLinkError(shortLink, record.DisabledReason, record.DisabledStatus)
+		}
+		link = record.InitialLink
+		return nil
+	})
+	return link, err
+}
+
+func (b *BoltStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	return b.update(func(tx *bolt.Tx) error {
+		destination := []byte(LinkKey(shortURL.Domain, shortURL.InitialLink))
+		if stored := tx.Bucket(boltDestinationsBucket).Get(destination); stored != nil {
+			shortURL.ShortLink = string(stored)
+			return utils.NewInsertUniqueLinkError(shortURL.InitialLink)
+		}
+		return putBoltLink(tx, Record{ShortURL: *shortURL, CreatedAt: time.Now()})
+	})
+}
+
+// WriteListShortURL writes all the links in a single transaction,
+// none of them is written if one fails.
+func (b *BoltStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) error {
+	now := time.Now()
+	return b.update(func(tx *bolt.Tx) error {
+		for _, link := range links {
+			shortURL := ShortURL{
+				InitialLink: link.InitialLink,
+				ShortLink:   link.ShortLink,
+				Domain:      link.Domain,
+			}
+			if err := putBoltLink(tx, Record{ShortURL: shortURL, CreatedAt: now}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error) {
+	var result []ShortURLByUser
+	err := b.view(func(tx *bolt.Tx) error {
+		return eachBoltUserLink(tx, userID, func(shortURL ShortURL) {
+			result = append(result, ShortURLByUser{
+				InitialLink: shortURL.InitialLink,
+				ShortLink:   shortURL.ShortLink,
+				Domain:      shortURL.Domain,
+			})
+		})
+	})
+	return result, err
+}
+
+// CheckHealth checks that a transaction of the database can be started.
+func (b *BoltStorage) CheckHealth(ctx context.Context) error {
+	return b.view(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (b *BoltStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
+	err := b.update(func(tx *bolt.Tx) error {
+		record, err := getBoltLink(tx, LinkKey(domain, link))
+		if err != nil {
+			return err
+		}
+		if record.UserID != id {
+			return nil
+		}
+		record.Deleted = true
+		return saveBoltLink(tx, record)
+	})
+	// like Postgres, deleting a missing link is not an error.
+	if err == utils.ErrLinkNotFound {
+		return nil
+	}
+	return err
+}
+
+func (b *BoltStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	var result []string
+	err := b.view(func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		for _, link := range links {
+			if users.Get(boltUserKey(id, LinkKey(domain, link))) == nil {
+				result = append(result, link)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (b *BoltStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	var result []ShortURL
+	add := func(shortURL ShortURL) {
+		if filter.Match(shortURL) {
+			result = append(result, shortURL)
+		}
+	}
+
+	err := b.view(func(tx *bolt.Tx) error {
+		switch {
+		case filter.ShortLink != "" && filter.Domain != nil:
+			record, err := getBoltLink(tx, LinkKey(*filter.Domain, filter.ShortLink))
+			if err == utils.ErrLinkNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			add(record.ShortURL)
+			return nil
+		case filter.UserID != nil:
+			return eachBoltUserLink(tx, *filter.UserID, add)
+		}
+
+		return tx.Bucket(boltLinksBucket).ForEach(func(k, v []byte) error {
+			var record Record
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			add(record.ShortURL)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (b *BoltStorage) UpdateModeration(ctx context.Context, domain, link string, m Moderation) error {
+	return b.update(func(tx *bolt.Tx) error {
+		record, err := getBoltLink(tx, LinkKey(domain, link))
+		if err != nil {
+			return err
+		}
+		record.setModeration(m)
+		return saveBoltLink(tx, record)
+	})
+}
+
+func (b *BoltStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
+	key := LinkKey(domain, link)
+	return b.update(func(tx *bolt.Tx) error {
+		record, err := getBoltLink(tx, key)
+		if err != nil {
+			return err
+		}
+
+		users := tx.Bucket(boltUsersBucket)
+		if err := users.Delete(boltUserKey(record.UserID, key)); err != nil {
+			return err
+		}
+		record.UserID = id
+		if err := users.Put(boltUserKey(id, key), []byte{}); err != nil {
+			return err
+		}
+		return saveBoltLink(tx, record)
+	})
+}
+
+// Runs the read-only transaction, the errors of the database are utils.ErrUnavailable.
+func (b *BoltStorage) view(fn func(tx *bolt.Tx) error) error {
+	return boltError(b.db.View(fn))
+}
+
+// Runs the read-write transaction, it is rolled back if fn fails.
+func (b *BoltStorage) update(fn func(tx *bolt.Tx) error) error {
+	return boltError(b.db.Update(fn))
+}
+
+// Returns the errors of a kind as is, the other errors
+// of the database are utils.ErrUnavailable.
+func boltError(err error) error {
+	return utils.NewUnavailableError(err)
+}
+
+func getBoltLink(tx *bolt.Tx, key string) (Record, error) {
+	var record Record
+	data := tx.Bucket(boltLinksBucket).Get([]byte(key))
+	if data == nil {
+		return record, utils.ErrLinkNotFound
+	}
+	err := json.Unmarshal(data, &record)
+	return record, err
+}
+
+// Writes the new link with its indexes, neither the short link nor the initial link
+// must be written yet in the domain.
+func putBoltLink(tx *bolt.Tx, record Record) error {
+	if tx.Bucket(boltLinksBucket).Get([]byte(record.Key())) != nil {
+		return utils.NewInsertUniqueLinkError(record.ShortLink)
+	}
+	destinations := tx.Bucket(boltDestinationsBucket)
+	destination := []byte(LinkKey(record.Domain, record.InitialLink))
+	if destinations.Get(destination) != nil {
+		return utils.NewInsertUniqueLinkError(record.InitialLink)
+	}
+	if err := destinations.Put(destination, []byte(record.ShortLink)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltUsersBucket).Put(boltUserKey(record.UserID, record.Key()), []byte{}); err != nil {
+		return err
+	}
+	return saveBoltLink(tx, record)
+}
+
+// Writes the link to the links bucket.
+func saveBoltLink(tx *bolt.Tx, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltLinksBucket).Put([]byte(record.Key()), data)
+}
+
+// Calls f for every link of the user.
+func eachBoltUserLink(tx *bolt.Tx, userID uint32, f func(shortURL ShortURL)) error {
+	prefix := boltUserKey(userID, "")
+	c := tx.Bucket(boltUsersBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		record, err := getBoltLink(tx, string(k[len(prefix):]))
+		if err != nil {
+			return err
+		}
+		f(record.ShortURL)
+	}
+	return nil
+}
+
+// Returns the key of the users bucket: the big endian user id followed by the key of the link.
+func boltUserKey(userID uint32, linkKey string) []byte {
+	key := make([]byte, 4, 4+len(linkKey))
+	binary.BigEndian.PutUint32(key, userID)
+	return append(key, linkKey...)
+}
+
+func (b *BoltStorage) ExportRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	var records []Record
+	err := b.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltLinksBucket).Cursor()
+		k, v := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+		for ; k != nil && len(records) < limit; k, v = c.Next() {
+			var record Record
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// ImportRecords writes the records in a single transaction.
+func (b *BoltStorage) ImportRecords(ctx context.Context, records []Record) error {
+	return b.update(func(tx *bolt.Tx) error {
+		for _, record := range records {
+			if err := putBoltLink(tx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The links are kept in the file after the storage is closed.
+func TestBoltStorageReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.db")
+	st, err := NewBoltStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, st.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	require.NoError(t, st.Close())
+
+	st, err = NewBoltStorage(path)
+	require.NoError(t, err)
+	defer st.Close()
+
+	link, err := st.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link)
+
+	found, err := st.FindShortURLs(ctx, ShortURLFilter{InitialLink: "example.com"})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+}
@@ -2,16 +2,35 @@ package storage
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
 )
 
-// FileStorage contains the path file.
+// FileStorage contains the path file and the lock of the file,
+// the lookups read the file together, the writes one at a time.
 type FileStorage struct {
 	path string
+	m    sync.RWMutex
+	// index is kept between the pages of the export and the import, see indexed.
+	index *fileIndex
+}
+
+// fileIndex is the records of the file ordered by their keys and the keys of their links,
+// it is valid while the file is the same one with the same size and modification time.
+type fileIndex struct {
+	info         os.FileInfo
+	records      []Record
+	shortLinks   map[string]bool
+	initialLinks map[string]bool
 }
 
 // FileWriter contains a file for writing and bufio.Writer.
@@ -20,6 +39,9 @@ type FileWriter struct {
 	writer *bufio.Writer
 }
 
+// The longest line of the file, a line of WriteListShortURL has all the links of the batch.
+const maxFileLineSize = 16 * 1024 * 1024
+
 // FileScanner contains a file for scanning and bufio.Scanner.
 type FileScanner struct {
 	file    *os.File
@@ -28,8 +50,13 @@ type FileScanner struct {
 
 // Returns a pointer to FileStorage with path file from config.
 func NewInFileStorage() *FileStorage {
+	return NewInFileStoragePath(configs.Get().FileStoragePath)
+}
+
+// Returns a pointer to FileStorage with the path file.
+func NewInFileStoragePath(path string) *FileStorage {
 	return &FileStorage{
-		path: configs.Cfg.FileStoragePath,
+		path: path,
 	}
 }
 
@@ -37,7 +64,7 @@ func NewInFileStorage() *FileStorage {
 func NewInFileWriter(st *FileStorage) (*FileWriter, error) {
 	file, err := os.OpenFile(st.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0777)
 	if err != nil {
-		return nil, err
+		return nil, utils.NewUnavailableError(err)
 	}
 
 	return &FileWriter{
@@ -55,12 +82,14 @@ func (w *FileWriter) Close() error {
 func NewInFileScanner(st *FileStorage) (*FileScanner, error) {
 	file, err := os.OpenFile(st.path, os.O_RDONLY|os.O_CREATE, 0777)
 	if err != nil {
-		return nil, err
+		return nil, utils.NewUnavailableError(err)
 	}
 
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFileLineSize)
 	return &FileScanner{
 		file:    file,
-		scanner: bufio.NewScanner(file),
+		scanner: scanner,
 	}, nil
 }
 
@@ -69,13 +98,19 @@ func (w *FileScanner) Close() error {
 	return w.file.Close()
 }
 
-// Writes a ShortURL to the file.
-func (f *FileStorage) WriteShortURL(shortURL *ShortURL) error {
-	if exist, _ := ScanFile(f, shortURL.ShortLink); exist != "" {
-		return nil
+// Writes a ShortURL to the file if its initial link is not there yet.
+func (f *FileStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if stored, err := checkUniqueLinks(f, []ShortURLByUser{{InitialLink: shortURL.InitialLink, ShortLink: shortURL.ShortLink, Domain: shortURL.Domain}}); err != nil {
+		if stored != "" {
+			shortURL.ShortLink = stored
+		}
+		return err
 	}
 
-	data, err := json.Marshal(&shortURL)
+	data, err := json.Marshal(Record{ShortURL: *shortURL, CreatedAt: time.Now()})
 	if err != nil {
 		return err
 	}
@@ -87,22 +122,28 @@ func (f *FileStorage) WriteShortURL(shortURL *ShortURL) error {
 	defer wr.Close()
 
 	if _, err := wr.writer.Write(data); err != nil {
-		return err
+		return utils.NewUnavailableError(err)
 	}
 
 	if err := wr.writer.WriteByte('\n'); err != nil {
-		return err
+		return utils.NewUnavailableError(err)
 	}
 
-	return wr.writer.Flush()
+	return utils.NewUnavailableError(wr.writer.Flush())
 }
 
 // Find and read shortened link and returns ShortURL.
-func (f *FileStorage) GetInitialLink(shortLink string) (string, error) {
-	return ScanFile(f, shortLink)
+func (f *FileStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	return ScanFile(f, domain, shortLink)
 }
 
-func (f *FileStorage) GetAllShortURLByUser(userID uint32) ([]ShortURLByUser, error) {
+func (f *FileStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
 	sc, err := NewInFileScanner(f)
 	if err != nil {
 		return nil, err
@@ -111,25 +152,30 @@ func (f *FileStorage) GetAllShortURLByUser(userID uint32) ([]ShortURLByUser, err
 
 	var result []ShortURLByUser
 	for sc.scanner.Scan() {
-		data := sc.scanner.Bytes()
-		shortURL := ShortURL{}
-		err := json.Unmarshal(data, &shortURL)
+		records, err := decodeLine(sc.scanner.Bytes())
 		if err != nil {
 			return nil, err
 		}
-		if shortURL.UserID == userID {
-			byUser := ShortURLByUser{
-				InitialLink: shortURL.InitialLink,
-				ShortLink:   configs.Cfg.BaseURL + "/" + shortURL.ShortLink,
+		for _, record := range records {
+			if record.UserID == userID {
+				byUser := ShortURLByUser{
+					InitialLink: record.InitialLink,
+					ShortLink:   record.ShortLink,
+					Domain:      record.Domain,
+				}
+				result = append(result, byUser)
 			}
-			result = append(result, byUser)
 		}
 	}
+	if err := sc.scanner.Err(); err != nil {
+		return nil, utils.NewUnavailableError(err)
+	}
 
 	return result, nil
 }
 
-func ScanFile(f *FileStorage, p string) (string, error) {
+// Returns the initial link of the short link p of the domain.
+func ScanFile(f *FileStorage, domain, p string) (string, error) {
 	sc, err := NewInFileScanner(f)
 	if err != nil {
 		return "", err
@@ -137,25 +183,175 @@ func ScanFile(f *FileStorage, p string) (string, error) {
 	defer sc.Close()
 
 	for sc.scanner.Scan() {
-		data := sc.scanner.Bytes()
-		shortURL := ShortURL{}
-		err := json.Unmarshal(data, &shortURL)
+		records, err := decodeLine(sc.scanner.Bytes())
 		if err != nil {
 			return "", err
 		}
-		if shortURL.ShortLink == p {
-			return shortURL.InitialLink, nil
+		for _, record := range records {
+			if record.ShortLink != p || record.Domain != domain {
+				continue
+			}
+			if record.Deleted {
+				return "", utils.NewDeletedLinkError(p)
+			}
+			if record.DisabledStatus != 0 {
+				return "", utils.NewDisabledLinkError(p, record.DisabledReason, record.DisabledStatus)
+			}
+			return record.InitialLink, nil
+		}
+	}
+	if err := sc.scanner.Err(); err != nil {
+		return "", utils.NewUnavailableError(err)
+	}
+
+	return "", utils.ErrLinkNotFound
+}
+
+// Decodes a line of the file. Links written by WriteListShortURL
+// are stored as a json array of ShortURLByUser on a single line
+// without the creation dates.
+func decodeLine(data []byte) ([]Record, error) {
+	if len(data) > 0 && data[0] == '[' {
+		var links []ShortURLByUser
+		if err := json.Unmarshal(data, &links); err != nil {
+			return nil, err
+		}
+		result := make([]Record, 0, len(links))
+		for _, link := range links {
+			result = append(result, Record{ShortURL: ShortURL{
+				InitialLink: link.InitialLink,
+				ShortLink:   link.ShortLink,
+				Domain:      link.Domain,
+			}})
+		}
+		return result, nil
+	}
+
+	record := Record{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return []Record{record}, nil
+}
+
+// Reads all the links from the file.
+func readAll(f *FileStorage) ([]Record, error) {
+	sc, err := NewInFileScanner(f)
+	if err != nil {
+		return nil, err
+	}
+	defer sc.Close()
+
+	var result []Record
+	for sc.scanner.Scan() {
+		records, err := decodeLine(sc.scanner.Bytes())
+		if err != nil {
+			return nil, err
 		}
+		result = append(result, records...)
+	}
+
+	return result, utils.NewUnavailableError(sc.scanner.Err())
+}
+
+// Returns utils.ErrUniqueLink if an initial link or a short link of the links
+// is already in the file or repeated in the list in the domain of the link.
+// The short link stored for the initial link is returned if it is found.
+func checkUniqueLinks(f *FileStorage, links []ShortURLByUser) (string, error) {
+	records, err := readAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	written := make(map[string]string, len(records)+len(links))
+	shortLinks := make(map[string]bool, len(records)+len(links))
+	for _, record := range records {
+		written[LinkKey(record.Domain, record.InitialLink)] = record.ShortLink
+		shortLinks[record.Key()] = true
+	}
+	for _, link := range links {
+		key := LinkKey(link.Domain, link.InitialLink)
+		if stored, ok := written[key]; ok {
+			return stored, utils.NewInsertUniqueLinkError(link.InitialLink)
+		}
+		if shortKey := LinkKey(link.Domain, link.ShortLink); shortLinks[shortKey] {
+			return "", utils.NewInsertUniqueLinkError(link.ShortLink)
+		}
+		written[key] = link.ShortLink
+		shortLinks[LinkKey(link.Domain, link.ShortLink)] = true
 	}
 
 	return "", nil
 }
 
-func (f *FileStorage) PingDB() error {
-	return errors.New("this type of storage does not support the ping operation")
+// Applies update to the links with the short link p of the domain and rewrites
+// the file, one link per line. Returns utils.ErrLinkNotFound if there is no such link.
+func rewriteFile(f *FileStorage, domain, p string, update func(s *ShortURL)) error {
+	records, err := readAll(f)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range records {
+		if records[i].ShortLink == p && records[i].Domain == domain {
+			update(&records[i].ShortURL)
+			found = true
+		}
+	}
+	if !found {
+		return utils.ErrLinkNotFound
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return utils.NewUnavailableError(err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return utils.NewUnavailableError(err)
+	}
+	defer os.Remove(tmp.Name())
+	// the file keeps its mode, the temporary file is created with 0600.
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return utils.NewUnavailableError(err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for i := range records {
+		if err := encoder.Encode(&records[i]); err != nil {
+			tmp.Close()
+			return utils.NewUnavailableError(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return utils.NewUnavailableError(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return utils.NewUnavailableError(err)
+	}
+
+	return utils.NewUnavailableError(os.Rename(tmp.Name(), f.path))
+}
+
+// CheckHealth checks that the file can be written to.
+func (f *FileStorage) CheckHealth(ctx context.Context) error {
+	return utils.NewUnavailableError(health.CheckWritable(f.path))
 }
 
-func (f *FileStorage) WriteListShortURL(links []ShortURLByUser) error {
+// WriteListShortURL writes all the links on a single line or none of them
+// if an initial link is already there or repeated in the list.
+func (f *FileStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if _, err := checkUniqueLinks(f, links); err != nil {
+		return err
+	}
+
 	data, err := json.Marshal(links)
 	if err != nil {
 		return err
@@ -168,20 +364,226 @@ func (f *FileStorage) WriteListShortURL(links []ShortURLByUser) error {
 	defer wr.Close()
 
 	if _, err := wr.writer.Write(data); err != nil {
-		return err
+		return utils.NewUnavailableError(err)
 	}
 
 	if err := wr.writer.WriteByte('\n'); err != nil {
+		return utils.NewUnavailableError(err)
+	}
+
+	return utils.NewUnavailableError(wr.writer.Flush())
+}
+
+// DeleteShortURLByUser marks the link deleted if it is created by the user.
+func (f *FileStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	err := rewriteFile(f, domain, link, func(s *ShortURL) {
+		if s.UserID == id {
+			s.Deleted = true
+		}
+	})
+	if errors.Is(err, utils.ErrLinkNotFound) {
+		return nil
+	}
+	return err
+}
+
+// CheckURLsCreatedByUser returns the links of the list not created by the user.
+func (f *FileStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	records, err := readAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool)
+	for _, record := range records {
+		if record.UserID == id && record.Domain == domain {
+			owned[record.ShortLink] = true
+		}
+	}
+
+	var result []string
+	for _, link := range links {
+		if !owned[link] {
+			result = append(result, link)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *FileStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	records, err := readAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ShortURL
+	for _, record := range records {
+		if filter.Match(record.ShortURL) {
+			result = append(result, record.ShortURL)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *FileStorage) UpdateModeration(ctx context.Context, domain, link string, m Moderation) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return rewriteFile(f, domain, link, func(s *ShortURL) {
+		s.setModeration(m)
+	})
+}
+
+func (f *FileStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return rewriteFile(f, domain, link, func(s *ShortURL) {
+		s.UserID = id
+	})
+}
+
+// ExportRecords takes the write lock since it may read the file into the index.
+func (f *FileStorage) ExportRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	index, err := f.indexed()
+	if err != nil {
+		return nil, err
+	}
+
+	// the page is copied since the index is changed by the imports.
+	return append([]Record(nil), pageSortedRecords(index.records, after, limit)...), nil
+}
+
+// ImportRecords appends the records to the file, one per line, in a single write.
+func (f *FileStorage) ImportRecords(ctx context.Context, records []Record) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	index, err := f.indexed()
+	if err != nil {
 		return err
 	}
 
-	return wr.writer.Flush()
+	shortLinks := make(map[string]bool, len(records))
+	initialLinks := make(map[string]bool, len(records))
+	var data []byte
+	for _, record := range records {
+		key, initialKey := record.Key(), LinkKey(record.Domain, record.InitialLink)
+		if index.shortLinks[key] || shortLinks[key] {
+			return utils.NewInsertUniqueLinkError(record.ShortLink)
+		}
+		if index.initialLinks[initialKey] || initialLinks[initialKey] {
+			return utils.NewInsertUniqueLinkError(record.InitialLink)
+		}
+		shortLinks[key] = true
+		initialLinks[initialKey] = true
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	wr, err := NewInFileWriter(f)
+	if err != nil {
+		return err
+	}
+	defer wr.Close()
+
+	if _, err := wr.writer.Write(data); err != nil {
+		f.index = nil
+		return utils.NewUnavailableError(err)
+	}
+	if err := wr.writer.Flush(); err != nil {
+		f.index = nil
+		return utils.NewUnavailableError(err)
+	}
+
+	return f.indexImported(index, records)
+}
+
+// Returns the index of the file, the file is read only if it has changed
+// since the index was built. The caller holds the write lock.
+func (f *FileStorage) indexed() (*fileIndex, error) {
+	info, err := os.Stat(f.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, utils.NewUnavailableError(err)
+	}
+	if info != nil && f.index != nil && f.index.valid(info) {
+		return f.index, nil
+	}
+
+	records, err := readAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		if info, err = os.Stat(f.path); err != nil {
+			return nil, utils.NewUnavailableError(err)
+		}
+	}
+
+	sortRecords(records)
+	index := &fileIndex{
+		info:         info,
+		records:      records,
+		shortLinks:   make(map[string]bool, len(records)),
+		initialLinks: make(map[string]bool, len(records)),
+	}
+	for _, record := range records {
+		index.shortLinks[record.Key()] = true
+		index.initialLinks[LinkKey(record.Domain, record.InitialLink)] = true
+	}
+	f.index = index
+	return index, nil
 }
 
-func (f *FileStorage) DeleteShortURLByUser(link string, id uint32) error {
+// Adds the records appended to the file to the index, so that the next page
+// of the import does not read the file again.
+func (f *FileStorage) indexImported(index *fileIndex, records []Record) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.index = nil
+		return utils.NewUnavailableError(err)
+	}
+
+	for _, record := range records {
+		index.shortLinks[record.Key()] = true
+		index.initialLinks[LinkKey(record.Domain, record.InitialLink)] = true
+	}
+	n := len(index.records)
+	index.records = append(index.records, records...)
+	for i := n; i < len(index.records); i++ {
+		if i > 0 && index.records[i-1].Key() > index.records[i].Key() {
+			sortRecords(index.records)
+			break
+		}
+	}
+	index.info = info
 	return nil
 }
 
-func (f *FileStorage) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
-	return nil, nil
+// Reports if the index is built from the file as it is.
+func (index *fileIndex) valid(info os.FileInfo) bool {
+	return os.SameFile(index.info, info) && index.info.Size() == info.Size() && index.info.ModTime().Equal(info.ModTime())
+}
+
+// Close does nothing, the file is opened by every operation.
+func (f *FileStorage) Close() error {
+	return nil
 }
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// A batch is written on a single line longer than the default buffer of bufio.Scanner.
+func TestFileStorageLongLine(t *testing.T) {
+	ctx := context.Background()
+	st := NewInFileStoragePath(filepath.Join(t.TempDir(), "links.json"))
+
+	links := make([]ShortURLByUser, 2000)
+	for i := range links {
+		links[i] = ShortURLByUser{
+			InitialLink: fmt.Sprintf("https://example.com/%d/%s", i, strings.Repeat("a", 50)),
+			ShortLink:   fmt.Sprintf("link%d", i),
+		}
+	}
+	require.NoError(t, st.WriteListShortURL(ctx, links))
+
+	link, err := st.GetInitialLink(ctx, "", "link1999")
+	require.NoError(t, err)
+	assert.Equal(t, links[1999].InitialLink, link)
+}
+
+// A line longer than the limit fails the lookup instead of reporting the link missing.
+func TestFileStorageLineTooLong(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.json")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("a", maxFileLineSize+1)), 0644))
+	st := NewInFileStoragePath(path)
+
+	_, err := st.GetInitialLink(context.Background(), "", "abc")
+	assert.ErrorIs(t, err, utils.ErrUnavailable)
+}
+
+// The file keeps its mode after it is rewritten by an update.
+func TestFileStorageRewriteKeepsMode(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	st := NewInFileStoragePath(path)
+	require.NoError(t, st.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc", UserID: 1}))
+
+	require.NoError(t, st.UpdateShortURLOwner(ctx, "", "abc", 2))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
@@ -1,77 +1,284 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
 
-	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
 )
 
-// InMemoryStorage contains storage map[string]ShortURL.
+// The number of shards of InMemoryStorage, the operations
+// on links of different shards do not wait for each other.
+const inMemoryShards = 32
+
+// memoryShard contains the links by their keys hashed to the shard, see LinkKey.
+type memoryShard struct {
+	m     sync.RWMutex
+	links map[string]Record
+}
+
+// InMemoryStorage contains the links sharded by their keys and the short
+// links by the keys of the initial links to keep them unique in the domains.
 type InMemoryStorage struct {
-	storage map[string]ShortURL
+	shards       [inMemoryShards]memoryShard
+	initialLinks sync.Map
 }
 
 // Returns a pointer to InMemoryStorage.
 func NewInMemoryStorage() *InMemoryStorage {
-	return &InMemoryStorage{
-		storage: make(map[string]ShortURL),
+	m := &InMemoryStorage{}
+	for i := range m.shards {
+		m.shards[i].links = make(map[string]Record)
 	}
+	return m
+}
+
+// Returns the shard of the key of a link.
+func (m *InMemoryStorage) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.shards[h.Sum32()%inMemoryShards]
 }
 
 // Find and read shortened link and returns ShortURL.
-func (m *InMemoryStorage) GetInitialLink(shortLink string) (string, error) {
-	sh, ok := m.storage[shortLink]
+func (m *InMemoryStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	key := LinkKey(domain, shortLink)
+	shard := m.shard(key)
+	shard.m.RLock()
+	defer shard.m.RUnlock()
+
+	sh, ok := shard.links[key]
 	if !ok {
-		return "", errors.New("URL with this value does not exist")
+		return "", utils.ErrLinkNotFound
+	}
+	if sh.Deleted {
+		return "", utils.NewDeletedLinkError(shortLink)
+	}
+	if sh.DisabledStatus != 0 {
+		return "", utils.NewDisabledLinkError(shortLink, sh.DisabledReason, sh.DisabledStatus)
 	}
 	return sh.InitialLink, nil
 }
 
-// Writes a ShortURL to the in memory storage.
-func (m *InMemoryStorage) WriteShortURL(shortURL *ShortURL) error {
-	for _, existing := range m.storage {
-		if shortURL.InitialLink == existing.InitialLink {
-			return nil
-		}
+// Writes a ShortURL to the in memory storage if neither its initial link
+// nor its short link is there yet.
+func (m *InMemoryStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	key := LinkKey(shortURL.Domain, shortURL.ShortLink)
+	shard := m.shard(key)
+	shard.m.Lock()
+	defer shard.m.Unlock()
+
+	if _, ok := shard.links[key]; ok {
+		return utils.NewInsertUniqueLinkError(shortURL.ShortLink)
+	}
+	if stored, loaded := m.initialLinks.LoadOrStore(LinkKey(shortURL.Domain, shortURL.InitialLink), shortURL.ShortLink); loaded {
+		shortURL.ShortLink = stored.(string)
+		return utils.NewInsertUniqueLinkError(shortURL.InitialLink)
 	}
-	m.storage[shortURL.ShortLink] = *shortURL
+	shard.links[key] = Record{ShortURL: *shortURL, CreatedAt: time.Now()}
 	return nil
 }
 
-func (m *InMemoryStorage) GetAllShortURLByUser(userID uint32) ([]ShortURLByUser, error) {
+func (m *InMemoryStorage) store(record Record) {
+	key := record.Key()
+	shard := m.shard(key)
+	shard.m.Lock()
+	defer shard.m.Unlock()
+
+	shard.links[key] = record
+}
+
+func (m *InMemoryStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error) {
 	var result []ShortURLByUser
-	for _, shortURL := range m.storage {
-		if shortURL.UserID == userID {
+	m.each(func(record Record) {
+		if shortURL := record.ShortURL; shortURL.UserID == userID {
 			byUser := ShortURLByUser{
 				InitialLink: shortURL.InitialLink,
-				ShortLink:   configs.Cfg.BaseURL + "/" + shortURL.ShortLink,
+				ShortLink:   shortURL.ShortLink,
+				Domain:      shortURL.Domain,
 			}
 			result = append(result, byUser)
 		}
-	}
+	})
 
 	return result, nil
 }
 
-func (m *InMemoryStorage) PingDB() error {
-	return errors.New("this type of storage does not support the ping operation")
+// Calls f for every link, shard by shard.
+func (m *InMemoryStorage) each(f func(record Record)) {
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.m.RLock()
+		for _, record := range shard.links {
+			f(record)
+		}
+		shard.m.RUnlock()
+	}
+}
+
+// CheckHealth always succeeds, the links are kept by the process itself.
+func (m *InMemoryStorage) CheckHealth(ctx context.Context) error {
+	return nil
 }
 
-func (m *InMemoryStorage) WriteListShortURL(links []ShortURLByUser) error {
+// WriteListShortURL writes all the links or none of them
+// if an initial link is already there or repeated in the list.
+func (m *InMemoryStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) error {
+	for i, link := range links {
+		if _, loaded := m.initialLinks.LoadOrStore(LinkKey(link.Domain, link.InitialLink), link.ShortLink); loaded {
+			for _, written := range links[:i] {
+				m.initialLinks.Delete(LinkKey(written.Domain, written.InitialLink))
+			}
+			return utils.NewInsertUniqueLinkError(link.InitialLink)
+		}
+	}
+
+	now := time.Now()
 	for _, link := range links {
 		var url ShortURL
 		url.InitialLink = link.InitialLink
 		url.ShortLink = link.ShortLink
-		m.storage[url.ShortLink] = url
+		url.Domain = link.Domain
+		m.store(Record{ShortURL: url, CreatedAt: now})
 	}
 
 	return nil
 }
 
-func (m *InMemoryStorage) DeleteShortURLByUser(link string, id uint32) error {
+// DeleteShortURLByUser marks the link deleted if it is created by the user.
+func (m *InMemoryStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
+	err := m.update(LinkKey(domain, link), func(sh *ShortURL) {
+		if sh.UserID == id {
+			sh.Deleted = true
+		}
+	})
+	if errors.Is(err, utils.ErrLinkNotFound) {
+		return nil
+	}
+	return err
+}
+
+// CheckURLsCreatedByUser returns the links of the list not created by the user.
+func (m *InMemoryStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	var result []string
+	for _, link := range links {
+		key := LinkKey(domain, link)
+		shard := m.shard(key)
+		shard.m.RLock()
+		sh, ok := shard.links[key]
+		shard.m.RUnlock()
+		if !ok || sh.UserID != id {
+			result = append(result, link)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *InMemoryStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	if filter.ShortLink != "" && filter.Domain != nil {
+		key := LinkKey(*filter.Domain, filter.ShortLink)
+		shard := m.shard(key)
+		shard.m.RLock()
+		defer shard.m.RUnlock()
+
+		if record, ok := shard.links[key]; ok && filter.Match(record.ShortURL) {
+			return []ShortURL{record.ShortURL}, nil
+		}
+		return nil, nil
+	}
+
+	var result []ShortURL
+	m.each(func(record Record) {
+		if filter.Match(record.ShortURL) {
+			result = append(result, record.ShortURL)
+		}
+	})
+
+	return result, nil
+}
+
+func (m *InMemoryStorage) UpdateModeration(ctx context.Context, domain, link string, md Moderation) error {
+	return m.update(LinkKey(domain, link), func(sh *ShortURL) {
+		sh.setModeration(md)
+	})
+}
+
+func (m *InMemoryStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
+	return m.update(LinkKey(domain, link), func(sh *ShortURL) {
+		sh.UserID = id
+	})
+}
+
+// Applies the edit to the link of the key under the lock of its shard.
+func (m *InMemoryStorage) update(key string, edit func(sh *ShortURL)) error {
+	shard := m.shard(key)
+	shard.m.Lock()
+	defer shard.m.Unlock()
+
+	record, ok := shard.links[key]
+	if !ok {
+		return utils.ErrLinkNotFound
+	}
+	edit(&record.ShortURL)
+	shard.links[key] = record
+
 	return nil
 }
 
-func (m *InMemoryStorage) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
-	return nil, nil
+func (m *InMemoryStorage) ExportRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	var records []Record
+	m.each(func(record Record) {
+		records = append(records, record)
+	})
+
+	return pageRecords(records, after, limit), nil
+}
+
+func (m *InMemoryStorage) ImportRecords(ctx context.Context, records []Record) error {
+	keys := make(map[string]bool, len(records))
+	for i, record := range records {
+		key := record.Key()
+		exists := keys[key] || m.has(key)
+		keys[key] = true
+		if exists {
+			m.release(records[:i])
+			return utils.NewInsertUniqueLinkError(record.ShortLink)
+		}
+		if _, loaded := m.initialLinks.LoadOrStore(LinkKey(record.Domain, record.InitialLink), record.ShortLink); loaded {
+			m.release(records[:i])
+			return utils.NewInsertUniqueLinkError(record.InitialLink)
+		}
+	}
+
+	for _, record := range records {
+		m.store(record)
+	}
+
+	return nil
+}
+
+// Reports whether the link of the key is there.
+func (m *InMemoryStorage) has(key string) bool {
+	shard := m.shard(key)
+	shard.m.RLock()
+	defer shard.m.RUnlock()
+
+	_, ok := shard.links[key]
+	return ok
+}
+
+// Releases the initial links of the records not to be written.
+func (m *InMemoryStorage) release(records []Record) {
+	for _, record := range records {
+		m.initialLinks.Delete(LinkKey(record.Domain, record.InitialLink))
+	}
+}
+
+// Close does nothing, the links are kept by the process itself.
+func (m *InMemoryStorage) Close() error {
+	return nil
 }
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// instrumentedStorage records the latency and the errors
+// of every operation of the wrapped storage by the backend name.
+type instrumentedStorage struct {
+	storage StorageOperations
+	backend string
+}
+
+// Returns the storage wrapped to record its operations as the backend.
+func instrument(st StorageOperations, backend string) StorageOperations {
+	return &instrumentedStorage{
+		storage: st,
+		backend: backend,
+	}
+}
+
+func (s *instrumentedStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (link string, err error) {
+	defer s.observe("GetInitialLink", time.Now(), &err)
+	return s.storage.GetInitialLink(ctx, domain, shortLink)
+}
+
+func (s *instrumentedStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) (err error) {
+	defer s.observe("WriteShortURL", time.Now(), &err)
+	return s.storage.WriteShortURL(ctx, shortURL)
+}
+
+func (s *instrumentedStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) (err error) {
+	defer s.observe("WriteListShortURL", time.Now(), &err)
+	return s.storage.WriteListShortURL(ctx, links)
+}
+
+func (s *instrumentedStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) (links []ShortURLByUser, err error) {
+	defer s.observe("GetAllShortURLByUser", time.Now(), &err)
+	return s.storage.GetAllShortURLByUser(ctx, userID)
+}
+
+func (s *instrumentedStorage) CheckHealth(ctx context.Context) (err error) {
+	defer s.observe("CheckHealth", time.Now(), &err)
+	return s.storage.CheckHealth(ctx)
+}
+
+func (s *instrumentedStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) (err error) {
+	defer s.observe("DeleteShortURLByUser", time.Now(), &err)
+	return s.storage.DeleteShortURLByUser(ctx, domain, link, id)
+}
+
+func (s *instrumentedStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) (res []string, err error) {
+	defer s.observe("CheckURLsCreatedByUser", time.Now(), &err)
+	return s.storage.CheckURLsCreatedByUser(ctx, domain, links, id)
+}
+
+func (s *instrumentedStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) (res []ShortURL, err error) {
+	defer s.observe("FindShortURLs", time.Now(), &err)
+	return s.storage.FindShortURLs(ctx, filter)
+}
+
+func (s *instrumentedStorage) UpdateModeration(ctx context.Context, domain, link string, m Moderation) (err error) {
+	defer s.observe("UpdateModeration", time.Now(), &err)
+	return s.storage.UpdateModeration(ctx, domain, link, m)
+}
+
+func (s *instrumentedStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) (err error) {
+	defer s.observe("UpdateShortURLOwner", time.Now(), &err)
+	return s.storage.UpdateShortURLOwner(ctx, domain, link, id)
+}
+
+// Records the operation, the error is taken by pointer to be read after the call returns.
+func (s *instrumentedStorage) observe(method string, start time.Time, err *error) {
+	e := *err
+	if isExpected(e) {
+		e = nil
+	}
+	metrics.ObserveStorage(s.backend, method, start, e)
+}
+
+// Reports whether the error is an expected result of an operation,
+// like a deleted, disabled, missing or duplicate link, not a failure of the backend.
+func isExpected(err error) bool {
+	for _, expected := range []error{utils.ErrDeletedLink, utils.ErrDisabledLink, utils.ErrLinkNotFound, utils.ErrUniqueLink} {
+		if errors.Is(err, expected) {
+			return true
+		}
+	}
+	return false
+}
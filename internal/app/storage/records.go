@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Record is a link with everything the backends keep about it,
+// the records are copied between the backends as is, see RecordStore.
+type Record struct {
+	ShortURL
+	// CreatedAt is zero if the creation date is unknown, Postgres keeps only the date.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordStore is implemented by the backends the records can be copied from and to.
+type RecordStore interface {
+	// ExportRecords returns up to limit records ordered by their keys
+	// whose keys are after the given one, see LinkKey.
+	ExportRecords(ctx context.Context, after string, limit int) ([]Record, error)
+	// ImportRecords writes the records as is, all of them or none. utils.ErrUniqueLink
+	// is returned if a short link or an initial link is already there in the domain.
+	ImportRecords(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// Returns up to limit records after the key from the unordered records.
+func pageRecords(records []Record, after string, limit int) []Record {
+	sortRecords(records)
+	return pageSortedRecords(records, after, limit)
+}
+
+// Returns up to limit records after the key from the records ordered by their keys.
+func pageSortedRecords(records []Record, after string, limit int) []Record {
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Key() > after
+	})
+	records = records[i:]
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records
+}
+
+// Orders the records by their keys.
+func sortRecords(records []Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Key() < records[j].Key()
+	})
+}
+
+// Returns the values of the columns of the record which are null
+// in the databases if they are empty: the moderation and the creation date.
+func (r Record) nullColumns() (*int, *string, *time.Time) {
+	var status *int
+	var reason *string
+	var createdAt *time.Time
+	if r.DisabledStatus != 0 {
+		status, reason = &r.DisabledStatus, &r.DisabledReason
+	}
+	if !r.CreatedAt.IsZero() {
+		createdAt = &r.CreatedAt
+	}
+	return status, reason, createdAt
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Node is a Postgres node of DBStorage with the name its pool is labelled with.
+type Node struct {
+	Name string
+	Pool *pgxpool.Pool
+}
+
+// replica is a Postgres replica the reads are sent to while it is healthy.
+type replica struct {
+	Node
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// replicaSet sends the reads to the healthy replicas in turn. The links and
+// the users written by the instance are pinned to the primary for a while,
+// so their reads see the writes before they are replicated.
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+	logger   zerolog.Logger
+
+	pinDuration time.Duration
+	m           sync.Mutex
+	pinned      map[string]time.Time
+	swept       time.Time
+
+	done chan struct{}
+}
+
+// Opens the pools of the replicas without connecting, the replicas
+// are checked before they are returned and every interval until close.
+func newReplicaSet(dsns []string, pgxLogger pgx.Logger, logLevel pgx.LogLevel,
+	checkInterval, pinDuration time.Duration, logger zerolog.Logger) (*replicaSet, error) {
+	rs := &replicaSet{
+		logger:      logger,
+		pinDuration: pinDuration,
+		pinned:      make(map[string]time.Time),
+		done:        make(chan struct{}),
+	}
+	for i, dsn := range dsns {
+		conf, err := pgxPoolConfig(dsn, pgxLogger, logLevel)
+		if err != nil {
+			rs.closePools()
+			return nil, fmt.Errorf("replica %d: %w", i, err)
+		}
+		conf.LazyConnect = true
+
+		pool, err := pgxpool.ConnectConfig(context.Background(), conf)
+		if err != nil {
+			rs.closePools()
+			return nil, fmt.Errorf("replica %d: %w", i, err)
+		}
+		name := "replica " + conf.ConnConfig.Host + ":" + strconv.Itoa(int(conf.ConnConfig.Port))
+		rs.replicas = append(rs.replicas, &replica{Node: Node{Name: name, Pool: pool}})
+	}
+
+	rs.check(checkInterval)
+	go rs.watch(checkInterval)
+	return rs, nil
+}
+
+// Checks the replicas every interval until close.
+func (rs *replicaSet) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rs.done:
+			return
+		case <-ticker.C:
+			rs.check(interval)
+		}
+	}
+}
+
+// Pings the replicas marking them healthy or failed, the ping waits up to the timeout.
+func (rs *replicaSet) check(timeout time.Duration) {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := r.Pool.Ping(ctx)
+		cancel()
+		rs.mark(r, err)
+	}
+}
+
+// Marks the replica failed if err is not nil and healthy otherwise,
+// the changes of the state are logged.
+func (rs *replicaSet) mark(r *replica, err error) {
+	healthy := int32(0)
+	if err == nil {
+		healthy = 1
+	}
+	metrics.ReplicaHealthy.WithLabelValues(r.Name).Set(float64(healthy))
+	if atomic.SwapInt32(&r.healthy, healthy) == healthy {
+		return
+	}
+
+	if err != nil {
+		rs.logger.Error().Err(err).Str("replica", r.Name).Msg("postgres replica is unavailable, reading from the primary")
+	} else {
+		rs.logger.Info().Str("replica", r.Name).Msg("postgres replica is healthy")
+	}
+}
+
+// Returns the next healthy replica or nil if none is healthy
+// or any of the keys is pinned to the primary.
+func (rs *replicaSet) pick(keys ...string) *replica {
+	if rs == nil || rs.isPinned(keys) {
+		return nil
+	}
+
+	n := uint32(len(rs.replicas))
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) isPinned(keys []string) bool {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if until, ok := rs.pinned[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pins the keys to the primary for the pin duration,
+// the expired keys are swept once in the pin duration.
+func (rs *replicaSet) pin(keys ...string) {
+	if rs == nil || rs.pinDuration == 0 {
+		return
+	}
+
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	now := time.Now()
+	if now.Sub(rs.swept) > rs.pinDuration {
+		for key, until := range rs.pinned {
+			if !now.Before(until) {
+				delete(rs.pinned, key)
+			}
+		}
+		rs.swept = now
+	}
+	for _, key := range keys {
+		rs.pinned[key] = now.Add(rs.pinDuration)
+	}
+}
+
+// Runs the read on a healthy replica unless the keys are pinned and on
+// the primary otherwise. The read is retried on the primary if the replica
+// fails or has not got the link yet, it may be written by another instance.
+// The replica is marked down by the connection and server errors only:
+// a cancelled request is not retried and a timed out query does not mark it.
+func (rs *replicaSet) read(ctx context.Context, primary *pgxpool.Pool, keys []string, read func(pool *pgxpool.Pool) error) error {
+	r := rs.pick(keys...)
+	if r == nil {
+		return read(primary)
+	}
+
+	err := read(r.Pool)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return err
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// the query is out of its time, the replica may be slow for this one only.
+	case errors.Is(err, utils.ErrUnavailable):
+		rs.mark(r, err)
+	case !errors.Is(err, utils.ErrLinkNotFound):
+		return err
+	}
+	return read(primary)
+}
+
+// Returns the replicas as the nodes.
+func (rs *replicaSet) nodes() []Node {
+	if rs == nil {
+		return nil
+	}
+	nodes := make([]Node, len(rs.replicas))
+	for i, r := range rs.replicas {
+		nodes[i] = r.Node
+	}
+	return nodes
+}
+
+// Stops the checks and closes the pools of the replicas.
+func (rs *replicaSet) close() {
+	if rs == nil {
+		return
+	}
+	close(rs.done)
+	rs.closePools()
+}
+
+func (rs *replicaSet) closePools() {
+	for _, r := range rs.replicas {
+		r.Pool.Close()
+	}
+}
+
+// The keys the links and the users are pinned by.
+func linkKey(domain, shortLink string) string {
+	return "link:" + LinkKey(domain, shortLink)
+}
+
+func userKey(userID uint32) string {
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// The dsn of a node nothing listens on, the pools never connect.
+const unreachableDSN = "postgres://postgres@127.0.0.1:1/db?connect_timeout=1"
+
+func lazyPool(t *testing.T) *pgxpool.Pool {
+	conf, err := pgxpool.ParseConfig(unreachableDSN)
+	require.NoError(t, err)
+	conf.LazyConnect = true
+	pool, err := pgxpool.ConnectConfig(context.Background(), conf)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// Returns the set of the healthy replicas with the pools that never connect.
+func newTestReplicaSet(t *testing.T, n int, pinDuration time.Duration) *replicaSet {
+	rs := &replicaSet{
+		logger:      zerolog.Nop(),
+		pinDuration: pinDuration,
+		pinned:      make(map[string]time.Time),
+		done:        make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		r := &replica{Node: Node{Name: "replica", Pool: lazyPool(t)}}
+		rs.mark(r, nil)
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs
+}
+
+// Returns the pool the read is run on.
+func readPool(t *testing.T, rs *replicaSet, primary *pgxpool.Pool, keys ...string) *pgxpool.Pool {
+	var used *pgxpool.Pool
+	require.NoError(t, rs.read(context.Background(), primary, keys, func(pool *pgxpool.Pool) error {
+		used = pool
+		return nil
+	}))
+	return used
+}
+
+func TestReplicaSetBalancesReads(t *testing.T) {
+	primary := lazyPool(t)
+	rs := newTestReplicaSet(t, 2, time.Minute)
+
+	first, second := readPool(t, rs, primary), readPool(t, rs, primary)
+	assert.NotSame(t, primary, first)
+	assert.NotSame(t, primary, second)
+	assert.NotSame(t, first, second, "the reads go to the replicas in turn")
+
+	rs.mark(rs.replicas[0], errors.New("connection refused"))
+	rs.mark(rs.replicas[1], errors.New("connection refused"))
+	assert.Same(t, primary, readPool(t, rs, primary))
+
+	var none *replicaSet
+	assert.Same(t, primary, readPool(t, none, primary))
+}
+
+func TestReplicaSetFallsBackToPrimary(t *testing.T) {
+	primary := lazyPool(t)
+	rs := newTestReplicaSet(t, 1, time.Minute)
+
+	tests := []struct {
+		name        string
+		replicaErr  error
+		wantHealthy bool
+	}{
+		{name: "missing link", replicaErr: utils.ErrLinkNotFound, wantHealthy: true},
+		{name: "unavailable", replicaErr: utils.NewUnavailableError(errors.New("connection refused")), wantHealthy: false},
+		{name: "query timeout", replicaErr: utils.NewUnavailableError(context.DeadlineExceeded), wantHealthy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs.mark(rs.replicas[0], nil)
+
+			var used []*pgxpool.Pool
+			err := rs.read(context.Background(), primary, nil, func(pool *pgxpool.Pool) error {
+				used = append(used, pool)
+				if pool != primary {
+					return tt.replicaErr
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []*pgxpool.Pool{rs.replicas[0].Pool, primary}, used)
+			assert.Equal(t, tt.wantHealthy, rs.replicas[0].isHealthy())
+		})
+	}
+
+	rs.mark(rs.replicas[0], nil)
+	err := rs.read(context.Background(), primary, nil, func(pool *pgxpool.Pool) error {
+		return utils.ErrDeletedLink
+	})
+	assert.ErrorIs(t, err, utils.ErrDeletedLink, "the other errors of the replica are returned")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var used []*pgxpool.Pool
+	err = rs.read(ctx, primary, nil, func(pool *pgxpool.Pool) error {
+		used = append(used, pool)
+		return utils.NewUnavailableError(ctx.Err())
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []*pgxpool.Pool{rs.replicas[0].Pool}, used, "the cancelled request is not retried")
+	assert.True(t, rs.replicas[0].isHealthy(), "the replica is not marked down by the cancelled request")
+}
+
+func TestReplicaSetPinsWrites(t *testing.T) {
+	primary := lazyPool(t)
+	rs := newTestReplicaSet(t, 1, 50*time.Millisecond)
+
+	rs.pin(linkKey("", "a"), userKey(1))
+	assert.Same(t, primary, readPool(t, rs, primary, linkKey("", "a")))
+	assert.Same(t, primary, readPool(t, rs, primary, userKey(1)))
+	assert.NotSame(t, primary, readPool(t, rs, primary, userKey(2)))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.NotSame(t, primary, readPool(t, rs, primary, linkKey("", "a")), "the pin expires")
+
+	rs.pin(linkKey("", "b"))
+	assert.NotContains(t, rs.pinned, linkKey("", "a"), "the expired pins are swept")
+}
+
+func TestNewReplicaSetUnreachable(t *testing.T) {
+	rs, err := newReplicaSet([]string{unreachableDSN}, nil, 0, time.Second, time.Second, zerolog.Nop())
+	require.NoError(t, err, "the replica is read from once it is healthy")
+	defer rs.close()
+
+	require.Len(t, rs.nodes(), 1)
+	assert.Equal(t, "replica 127.0.0.1:1", rs.nodes()[0].Name)
+	assert.False(t, rs.replicas[0].isHealthy())
+	assert.Nil(t, rs.pick())
+}
@@ -0,0 +1,19 @@
+package storage
+
+// The columns of the shortened_links table shared by the Postgres and
+// the SQLite storages, the id column is defined by the dialect.
+// The short links and the initial links are unique within the domain, see schemaIndexes.
+const shortenedLinksColumns = `initial_link varchar(256) not null, short_link varchar(256) not null,
+	user_id bigint, date_of_create date, deleted boolean,
+	disabled_status int, disabled_reason varchar(256), ` + domainColumn
+
+// The domain column, the links created before the domains are in the default domain.
+const domainColumn = `domain varchar(256) not null default ''`
+
+// The statements run by both storages after the table is created.
+var schemaIndexes = []string{
+	`create index if not exists shortened_links_short_link_idx on shortened_links (short_link)`,
+	`create index if not exists shortened_links_user_id_idx on shortened_links (user_id)`,
+	`create unique index if not exists shortened_links_domain_short_link_idx on shortened_links (domain, short_link)`,
+	`create unique index if not exists shortened_links_domain_initial_link_idx on shortened_links (domain, initial_link)`,
+}
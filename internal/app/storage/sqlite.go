@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// SQLiteStorage keeps the links in the shortened_links table
+// of a SQLite database file with the schema of DBStorage.
+type SQLiteStorage struct {
+	DB *sql.DB
+}
+
+// Returns a pointer to SQLiteStorage over the database file at the path,
+// the file and the table are created if they do not exist.
+// SQLite has a single writer, so the storage uses a single connection
+// and waits for the lock of the file held by another process.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	storage := &SQLiteStorage{
+		DB: db,
+	}
+
+	if err := storage.CreateTable(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// Close closes the database.
+func (s *SQLiteStorage) Close() error {
+	return s.DB.Close()
+}
+
+func (s *SQLiteStorage) CreateTable() error {
+	ctx := context.Background()
+	sqlCreateStmt := `
+	create table if not exists shortened_links ( id integer primary key autoincrement,
+	` + shortenedLinksColumns + ` );`
+
+	if _, err := s.DB.ExecContext(ctx, sqlCreateStmt); err != nil {
+		return err
+	}
+
+	// SQLite cannot drop the unique constraint of the initial links
+	// of the tables created before the domains, they stay unique
+	// over all the domains in such a table.
+	var hasDomain bool
+	err := s.DB.QueryRowContext(ctx,
+		"select exists(select 1 from pragma_table_info('shortened_links') where name = 'domain')").Scan(&hasDomain)
+	if err != nil {
+		return err
+	}
+	if !hasDomain {
+		if _, err := s.DB.ExecContext(ctx, "alter table shortened_links add column "+domainColumn); err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range schemaIndexes {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	var iLink, disabledReason string
+	var deleted bool
+	var disabledStatus int
+	err := s.DB.QueryRowContext(
+		ctx,
+		`select initial_link, COALESCE(deleted, false), COALESCE(disabled_status, 0), COALESCE(disabled_reason, '')
+		from shortened_links where short_link=? and domain=?`,
+		shortLink,
+		domain,
+	).Scan(&iLink, &deleted, &disabledStatus, &disabledReason)
+	if err != nil {
+		return "", sqliteError(err)
+	}
+
+	if deleted {
+		return "", utils.NewDeletedLinkError(shortLink)
+	}
+
+	if disabledStatus != 0 {
+		return "", utils.NewDisabledLinkError(shortLink, disabledReason, disabledStatus)
+	}
+
+	return iLink, nil
+}
+
+func (s *SQLiteStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	insertStatement := `
+	INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create, domain)
+	VALUES (?, ?, ?, ?, ?) ON CONFLICT (domain, initial_link) DO NOTHING;`
+
+	result, err := s.DB.ExecContext(
+		ctx,
+		insertStatement,
+		shortURL.InitialLink,
+		shortURL.ShortLink,
+		shortURL.UserID,
+		time.Now(),
+		shortURL.Domain,
+	)
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return sqliteError(err)
+	}
+	if rows != 1 {
+		err = s.DB.QueryRowContext(ctx, "select short_link from shortened_links where domain=? and initial_link=?",
+			shortURL.Domain, shortURL.InitialLink).Scan(&shortURL.ShortLink)
+		if err != nil {
+			return sqliteError(err)
+		}
+		return utils.NewInsertUniqueLinkError(shortURL.InitialLink)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error) {
+	rows, err := s.DB.QueryContext(ctx, "select initial_link, short_link, domain from shortened_links where user_id=?", userID)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var result []ShortURLByUser
+	for rows.Next() {
+		var link ShortURLByUser
+		if err := rows.Scan(&link.InitialLink, &link.ShortLink, &link.Domain); err != nil {
+			return nil, sqliteError(err)
+		}
+		result = append(result, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return result, nil
+}
+
+// CheckHealth pings the database.
+func (s *SQLiteStorage) CheckHealth(ctx context.Context) error {
+	return sqliteError(s.DB.PingContext(ctx))
+}
+
+func (s *SQLiteStorage) WriteListShortURL(ctx context.Context, links []ShortURLByUser) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	for _, l := range links {
+		if _, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create, domain) VALUES (?, ?, ?, ?, ?)",
+			l.InitialLink,
+			l.ShortLink,
+			nil,
+			time.Now(),
+			l.Domain,
+		); err != nil {
+			return sqliteError(err)
+		}
+	}
+
+	return sqliteError(tx.Commit())
+}
+
+// CheckURLsCreatedByUser returns the links of the list not created by the user.
+func (s *SQLiteStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	owned := make(map[string]bool)
+	rows, err := s.DB.QueryContext(ctx, "select short_link from shortened_links where user_id=? and domain=?", id, domain)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var link string
+		if err := rows.Scan(&link); err != nil {
+			return nil, sqliteError(err)
+		}
+		owned[link] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+
+	var result []string
+	for _, link := range links {
+		if !owned[link] {
+			result = append(result, link)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *SQLiteStorage) DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error {
+	sqlStmt := `
+	update shortened_links set deleted = true
+	where user_id = ? and short_link = ? and domain = ?;`
+
+	_, err := s.DB.ExecContext(ctx, sqlStmt, id, link, domain)
+	return sqliteError(err)
+}
+
+func (s *SQLiteStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	var userID *int64
+	if filter.UserID != nil {
+		id := int64(*filter.UserID)
+		userID = &id
+	}
+
+	selectStatement := `
+	select initial_link, short_link, domain, COALESCE(user_id, 0), COALESCE(deleted, false),
+	COALESCE(disabled_status, 0), COALESCE(disabled_reason, '')
+	from shortened_links
+	where (?1 = '' or initial_link like '%' || ?1 || '%')
+	and (?2 = '' or short_link = ?2)
+	and (?3 is null or user_id = ?3)
+	and (?4 is null or domain = ?4)
+	order by id`
+	rows, err := s.DB.QueryContext(ctx, selectStatement, filter.InitialLink, filter.ShortLink, userID, filter.Domain)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var result []ShortURL
+	for rows.Next() {
+		var shortURL ShortURL
+		var userID int64
+		err := rows.Scan(
+			&shortURL.InitialLink, &shortURL.ShortLink, &shortURL.Domain, &userID,
+			&shortURL.Deleted, &shortURL.DisabledStatus, &shortURL.DisabledReason,
+		)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		shortURL.UserID = uint32(userID)
+		result = append(result, shortURL)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return result, nil
+}
+
+func (s *SQLiteStorage) UpdateModeration(ctx context.Context, domain, link string, m Moderation) error {
+	var status *int
+	var reason *string
+	if m.Disabled {
+		status, reason = &m.Status, &m.Reason
+	}
+
+	sqlStmt := `
+	update shortened_links set disabled_status = ?, disabled_reason = ?
+	where short_link = ? and domain = ?;`
+
+	return s.updateLink(ctx, sqlStmt, status, reason, link, domain)
+}
+
+func (s *SQLiteStorage) UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error {
+	sqlStmt := `
+	update shortened_links set user_id = ?
+	where short_link = ? and domain = ?;`
+
+	return s.updateLink(ctx, sqlStmt, id, link, domain)
+}
+
+// Runs the update of a link, utils.ErrLinkNotFound is returned if no link is updated.
+func (s *SQLiteStorage) updateLink(ctx context.Context, sqlStmt string, args ...interface{}) error {
+	result, err := s.DB.ExecContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return sqliteError(err)
+	}
+	if rows == 0 {
+		return utils.ErrLinkNotFound
+	}
+
+	return nil
+}
+
+// Returns the error of the storage as an error of its kind like dbError: no rows
+// is utils.ErrLinkNotFound, a unique violation is utils.ErrUniqueLink, a locked
+// database, the errors of the file and the closed database are utils.ErrUnavailable.
+// The other errors of SQLite are returned as is.
+func sqliteError(err error) error {
+	if err == nil || utils.Kind(err) != nil {
+		return err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.ErrLinkNotFound
+	}
+
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return utils.NewUnavailableError(err)
+	}
+	if sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return utils.ErrUniqueLink
+	}
+	// the primary result code is the least significant byte of the extended one.
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_IOERR,
+		sqlite3.SQLITE_CANTOPEN, sqlite3.SQLITE_FULL, sqlite3.SQLITE_READONLY:
+		return utils.NewUnavailableError(err)
+	}
+	return err
+}
+
+func (s *SQLiteStorage) ExportRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	selectStatement := `
+	select initial_link, short_link, domain, COALESCE(user_id, 0), COALESCE(deleted, false),
+	COALESCE(disabled_status, 0), COALESCE(disabled_reason, ''), date_of_create
+	from shortened_links
+	where (short_link, domain) > (?, ?)
+	order by short_link, domain
+	limit ?`
+	afterDomain, afterLink := splitLinkKey(after)
+	rows, err := s.DB.QueryContext(ctx, selectStatement, afterLink, afterDomain, limit)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var result []Record
+	for rows.Next() {
+		var record Record
+		var userID int64
+		var createdAt *time.Time
+		err := rows.Scan(
+			&record.InitialLink, &record.ShortLink, &record.Domain, &userID,
+			&record.Deleted, &record.DisabledStatus, &record.DisabledReason, &createdAt,
+		)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		record.UserID = uint32(userID)
+		if createdAt != nil {
+			record.CreatedAt = *createdAt
+		}
+		result = append(result, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return result, nil
+}
+
+// ImportRecords writes the records in a single transaction.
+func (s *SQLiteStorage) ImportRecords(ctx context.Context, records []Record) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	for _, record := range records {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "select exists(select 1 from shortened_links where short_link=? and domain=?)", record.ShortLink, record.Domain).Scan(&exists)
+		if err != nil {
+			return sqliteError(err)
+		}
+		if exists {
+			return utils.NewInsertUniqueLinkError(record.ShortLink)
+		}
+
+		status, reason, createdAt := record.nullColumns()
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO shortened_links (initial_link, short_link, user_id, date_of_create, deleted, disabled_status, disabled_reason, domain)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			record.InitialLink,
+			record.ShortLink,
+			record.UserID,
+			createdAt,
+			record.Deleted,
+			status,
+			reason,
+			record.Domain,
+		); err != nil {
+			return sqliteError(err)
+		}
+	}
+
+	return sqliteError(tx.Commit())
+}
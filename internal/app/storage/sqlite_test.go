@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The links are kept in the file after the storage is closed.
+func TestSQLiteStorageReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.sqlite")
+	st, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, st.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.com", ShortLink: "abc"}))
+	require.NoError(t, st.Close())
+
+	st, err = NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer st.Close()
+
+	link, err := st.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link)
+
+	found, err := st.FindShortURLs(ctx, ShortURLFilter{InitialLink: "example.com"})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+}
+
+// The table created before the domains gets the domain column, its links are in the default domain.
+func TestSQLiteStorageAddsDomain(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.sqlite")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table shortened_links ( id integer primary key autoincrement,
+	initial_link varchar(256) not null unique, short_link varchar(256) not null,
+	user_id bigint, date_of_create date, deleted boolean,
+	disabled_status int, disabled_reason varchar(256) );
+	insert into shortened_links (initial_link, short_link, user_id) values ('https://example.com', 'abc', 1);`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	st, err := NewSQLiteStorage(path)
+	require.NoError(t, err)
+	defer st.Close()
+
+	link, err := st.GetInitialLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", link)
+
+	require.NoError(t, st.WriteShortURL(ctx, &ShortURL{InitialLink: "https://example.org", ShortLink: "abc", Domain: "go.brand.example"}))
+	link, err = st.GetInitialLink(ctx, "go.brand.example", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.org", link)
+}
@@ -1,107 +1,229 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"log"
-	"sync"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/blocklist"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/domains"
 	gen "github.com/GorunovAlx/shortening_long_url/internal/app/generators"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/metrics"
 	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog"
 )
 
 // ShortURL struct contains a InitialLink - initial link
-// and its shortened link(ShortLink).
+// and its shortened link(ShortLink) unique within its Domain,
+// the empty domain is the default one, see domains.Default.
 type ShortURL struct {
-	InitialLink string `json:"url,omitempty" valid:"-"`
-	ShortLink   string `json:"result,omitempty" valid:"-"`
-	UserID      uint32 `json:"user_id,omitempty"`
+	InitialLink    string `json:"url,omitempty" valid:"-"`
+	ShortLink      string `json:"result,omitempty" valid:"-"`
+	Domain         string `json:"domain,omitempty"`
+	UserID         uint32 `json:"user_id,omitempty"`
+	Deleted        bool   `json:"deleted,omitempty"`
+	DisabledStatus int    `json:"disabled_status,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
 }
 
 type ShortURLByUser struct {
 	ShortLink     string `json:"short_url,omitempty" valid:"-"`
 	InitialLink   string `json:"original_url,omitempty" valid:"-"`
 	CorrelationID string `json:"correlation_id,omitempty"`
+	Domain        string `json:"domain,omitempty"`
+}
+
+// ShortURLFilter contains the search criteria over all links,
+// empty fields are not taken into account.
+type ShortURLFilter struct {
+	InitialLink string
+	ShortLink   string
+	Domain      *string
+	UserID      *uint32
+}
+
+// Moderation contains the moderation state of a link.
+// Status is the http status a disabled link is served with.
+type Moderation struct {
+	Disabled bool
+	Reason   string
+	Status   int
 }
 
 // ShortURLRepo contains:
-// GetInitialLink takes a short link and returns the initial link;
+// GetInitialLink takes a short link of the domain and returns the initial link;
 // CreateShortURL takes an initial link and returns a shortened.
+// Mutations are written to the audit trail on behalf of the actor from ctx.
 type ShortURLRepo interface {
-	GetInitialLink(shortLink string) (string, error)
-	CreateShortURL(shortURL *ShortURL) (string, error)
-	CreateListShortURL(links []ShortURLByUser) ([]ShortURLByUser, error)
-	GetAllShortURLUser(id uint32) ([]ShortURLByUser, error)
-	PingDB() error
-	DeleteShortURLUser(link string, id uint32) error
-	CheckURLsCreatedByUser(links []string, id uint32) ([]string, error)
+	GetInitialLink(ctx context.Context, domain, shortLink string) (string, error)
+	CreateShortURL(ctx context.Context, shortURL *ShortURL) (string, error)
+	CreateListShortURL(ctx context.Context, links []ShortURLByUser) ([]ShortURLByUser, error)
+	GetAllShortURLUser(ctx context.Context, id uint32) ([]ShortURLByUser, error)
+	PingDB(ctx context.Context) error
+	DeleteShortURLUser(ctx context.Context, domain, link string, id uint32) error
+	CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error)
+	FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error)
+	ModerateShortURL(ctx context.Context, domain, link string, m Moderation) error
+	ReassignShortURL(ctx context.Context, domain, link string, id uint32) error
+	FindAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, error)
+	HealthComponents() []health.Component
 }
 
 // RWShortURL contains:
-// GetInitialLink takes a short link and returns the initial link from storage;
-// WriteShortURL takes the ShortURL struct and writes it into the storage.
+// GetInitialLink takes a short link of the domain and returns the initial link from storage;
+// WriteShortURL takes the ShortURL struct and writes it into the storage,
+// if the initial link is already in the domain it returns utils.ErrUniqueLink
+// and sets the ShortLink of the struct to the stored short link.
+// The short links are unique within their domain, and so are the initial links.
+// GetAllShortURLByUser returns the codes of the links with their domains.
 type StorageOperations interface {
-	GetInitialLink(shortLink string) (string, error)
-	WriteShortURL(shortURL *ShortURL) error
-	WriteListShortURL(links []ShortURLByUser) error
-	GetAllShortURLByUser(userID uint32) ([]ShortURLByUser, error)
-	PingDB() error
-	DeleteShortURLByUser(link string, id uint32) error
-	CheckURLsCreatedByUser(links []string, id uint32) ([]string, error)
+	GetInitialLink(ctx context.Context, domain, shortLink string) (string, error)
+	WriteShortURL(ctx context.Context, shortURL *ShortURL) error
+	WriteListShortURL(ctx context.Context, links []ShortURLByUser) error
+	GetAllShortURLByUser(ctx context.Context, userID uint32) ([]ShortURLByUser, error)
+	health.Checker
+	DeleteShortURLByUser(ctx context.Context, domain, link string, id uint32) error
+	CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error)
+	FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error)
+	UpdateModeration(ctx context.Context, domain, link string, m Moderation) error
+	UpdateShortURLOwner(ctx context.Context, domain, link string, id uint32) error
+}
+
+// LinkKey returns the key identifying the short link of the domain among
+// the links of all the domains: the short link itself in the default domain,
+// so the keys of the links created before the domains are kept, and the short
+// link followed by a zero byte and the domain otherwise. The keys are ordered
+// by the short link and then by the domain.
+func LinkKey(domain, shortLink string) string {
+	if domain == domains.Default {
+		return shortLink
+	}
+	return shortLink + "\x00" + domain
+}
+
+// Returns the domain and the short link of the key, see LinkKey.
+func splitLinkKey(key string) (string, string) {
+	if i := strings.IndexByte(key, 0); i >= 0 {
+		return key[i+1:], key[:i]
+	}
+	return domains.Default, key
+}
+
+// Key returns the key of the link, see LinkKey.
+func (s ShortURL) Key() string {
+	return LinkKey(s.Domain, s.ShortLink)
+}
+
+// Returns the link of the domain as it is written to the audit trail:
+// the short link in the default domain and domain/short link otherwise.
+func auditLink(domain, shortLink string) string {
+	if domain == domains.Default {
+		return shortLink
+	}
+	return domain + "/" + shortLink
 }
 
 // The ShortURLStorage contains storage that implements
-// the interface RWShortURL and RWMutex.
+// the interface RWShortURL, the audit trail recorder,
+// the blocklist destinations are checked against and the logger.
+// Every mutation is written to the audit trail, see audited.
+// It has no lock of its own, every backend synchronises its operations:
+// InMemoryStorage by shards, FileStorage by the lock of the file
+// and DBStorage by the database.
 type ShortURLStorage struct {
-	storage StorageOperations
-	s       sync.RWMutex
+	storage   StorageOperations
+	audit     audit.Recorder
+	blocklist *blocklist.List
+	logger    zerolog.Logger
 }
 
-// The function returns a pointer to the ShortURLStorage structure,
-// where the storage is initialized either by file storage
-// if the file path is not empty in the config, or by in memory storage.
-func NewStorage() *ShortURLStorage {
-	if configs.Cfg.DatabaseDSN != "" {
-		st, err := NewDBStorage()
-		if err != nil {
-			log.Println(err)
-		} else {
-			return &ShortURLStorage{
-				storage: st,
-			}
-		}
+// The function returns a pointer to the ShortURLStorage structure
+// over the backend opened by openBackend or the error if neither
+// the configured backend nor the fallback backends can be opened.
+// The operations of the storage are recorded in metrics by the backend name,
+// the destinations are cached in front of the backend, see withCache.
+func NewStorage(logger zerolog.Logger) (*ShortURLStorage, error) {
+	name, st, err := openBackend(logger)
+	if err != nil {
+		return nil, err
 	}
 
-	if configs.Cfg.FileStoragePath != "" {
-		return &ShortURLStorage{
-			storage: NewInFileStorage(),
+	var pool *pgxpool.Pool
+	if db, ok := st.(*DBStorage); ok {
+		for _, node := range db.Nodes() {
+			metrics.Registry.MustRegister(metrics.NewPoolCollector(node.Pool, node.Name))
 		}
+		pool = db.Postgres
 	}
 
-	return &ShortURLStorage{
-		storage: NewInMemoryStorage(),
+	event := logger.Info()
+	if configured := configuredBackend(configs.Get()); configured != name {
+		event = logger.Warn().Str("configured", configured)
 	}
+	event.Str("backend", name).Msg("storage backend opened")
+
+	repo, err := newShortURLStorage(withCache(instrument(st, name)), pool, logger)
+	if err != nil {
+		if closer, ok := st.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+	return repo, nil
 }
 
-// Get the initial link by shortened link or an error.
-func (repo *ShortURLStorage) GetInitialLink(shortLink string) (string, error) {
-	repo.s.RLock()
-	defer repo.s.RUnlock()
+// Returns a pointer to ShortURLStorage with the audit trail kept
+// in Postgres if the pool is not nil, see audit.NewRecorder,
+// or the error if the audit trail or the blocklist cannot be opened.
+func newShortURLStorage(st StorageOperations, pool *pgxpool.Pool, logger zerolog.Logger) (*ShortURLStorage, error) {
+	recorder, err := audit.NewRecorder(pool)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the audit trail: %w", err)
+	}
+
+	list, err := blocklist.NewList(configs.Get().BlocklistPaths)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the blocklist: %w", err)
+	}
+
+	return &ShortURLStorage{
+		storage:   st,
+		audit:     recorder,
+		blocklist: list,
+		logger:    logger,
+	}, nil
+}
 
-	url, err := repo.storage.GetInitialLink(shortLink)
+// Get the initial link by shortened link of the domain or an error.
+func (repo *ShortURLStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	url, err := repo.storage.GetInitialLink(ctx, domain, shortLink)
 	if errors.Is(err, utils.ErrDeletedLink) {
 		return "", utils.ErrDeletedLink
 	} else if err != nil {
 		return "", err
 	}
 
+	// the lists may have been updated since the link was created.
+	if err = repo.checkBlocklist(ctx, "blocklist.redirect", auditLink(domain, shortLink), url); err != nil {
+		return "", err
+	}
+
 	return url, nil
 }
 
-// Create shortened link by initial link.
-func (repo *ShortURLStorage) CreateShortURL(shortURL *ShortURL) (string, error) {
-	repo.s.Lock()
-	defer repo.s.Unlock()
+// Create shortened link by initial link in the domain of the link.
+func (repo *ShortURLStorage) CreateShortURL(ctx context.Context, shortURL *ShortURL) (string, error) {
+	if err := repo.checkBlocklist(ctx, "blocklist.create", "", shortURL.InitialLink); err != nil {
+		return "", err
+	}
 
 	shortenedURL, err := gen.GenerateShortLink(shortURL.InitialLink, shortURL.UserID)
 	if err != nil {
@@ -109,8 +231,15 @@ func (repo *ShortURLStorage) CreateShortURL(shortURL *ShortURL) (string, error)
 	}
 	shortURL.ShortLink = shortenedURL
 
-	err = repo.storage.WriteShortURL(shortURL)
+	entry, err := newAuditEntry(ctx, "create", auditLink(shortURL.Domain, shortURL.ShortLink), nil, shortURL, nil)
+	if err != nil {
+		return "", err
+	}
+	err = repo.audited(ctx, []audit.Entry{entry}, func(ctx context.Context) error {
+		return repo.storage.WriteShortURL(ctx, shortURL)
+	})
 
+	// the backend has set the short link the initial link is stored with.
 	if errors.Is(err, utils.ErrUniqueLink) {
 		return shortURL.ShortLink, utils.ErrUniqueLink
 	} else if err != nil {
@@ -120,20 +249,24 @@ func (repo *ShortURLStorage) CreateShortURL(shortURL *ShortURL) (string, error)
 	return shortURL.ShortLink, nil
 }
 
-func (repo *ShortURLStorage) GetAllShortURLUser(id uint32) ([]ShortURLByUser, error) {
-	repo.s.RLock()
-	defer repo.s.RUnlock()
-
-	result, err := repo.storage.GetAllShortURLByUser(id)
+// Get the links of the user with the short urls built from their domains.
+func (repo *ShortURLStorage) GetAllShortURLUser(ctx context.Context, id uint32) ([]ShortURLByUser, error) {
+	result, err := repo.storage.GetAllShortURLByUser(ctx, id)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
+	}
+
+	registry := domains.Get()
+	for i, link := range result {
+		result[i].setShortLink(registry.ShortURL(link.Domain, link.ShortLink))
 	}
 
 	return result, nil
 }
 
-func (repo *ShortURLStorage) PingDB() error {
-	err := repo.storage.PingDB()
+// Checks the health of the storage backend, kept for /ping.
+func (repo *ShortURLStorage) PingDB(ctx context.Context) error {
+	err := repo.storage.CheckHealth(ctx)
 
 	if err != nil {
 		return err
@@ -142,12 +275,27 @@ func (repo *ShortURLStorage) PingDB() error {
 	return nil
 }
 
-func (repo *ShortURLStorage) CreateListShortURL(links []ShortURLByUser) ([]ShortURLByUser, error) {
-	repo.s.Lock()
-	defer repo.s.Unlock()
+// Returns the components the storage depends on: the backend
+// and the audit trail if its recorder can be checked.
+func (repo *ShortURLStorage) HealthComponents() []health.Component {
+	components := []health.Component{
+		{Name: "storage", Checker: repo.storage},
+	}
+	if checker, ok := repo.audit.(health.Checker); ok {
+		components = append(components, health.Component{Name: "audit", Checker: checker})
+	}
+	return components
+}
 
+func (repo *ShortURLStorage) CreateListShortURL(ctx context.Context, links []ShortURLByUser) ([]ShortURLByUser, error) {
 	var shortenedLinks []ShortURLByUser
 
+	for _, link := range links {
+		if err := repo.checkBlocklist(ctx, "blocklist.create", "", link.InitialLink); err != nil {
+			return nil, err
+		}
+	}
+
 	for i, link := range links {
 		shortenedURL, err := gen.GenerateShortLink(link.InitialLink, 0)
 		if err != nil {
@@ -161,39 +309,102 @@ func (repo *ShortURLStorage) CreateListShortURL(links []ShortURLByUser) ([]Short
 		shortened := ShortURLByUser{
 			ShortLink:     link.ShortLink,
 			CorrelationID: link.CorrelationID,
+			Domain:        link.Domain,
 		}
 
 		shortenedLinks = append(shortenedLinks, shortened)
 	}
 
-	err := repo.storage.WriteListShortURL(links)
+	entries := make([]audit.Entry, len(links))
+	for i, link := range links {
+		after := &ShortURL{
+			InitialLink: link.InitialLink,
+			ShortLink:   link.ShortLink,
+			Domain:      link.Domain,
+		}
+		details := map[string]string{
+			"correlation_id": link.CorrelationID,
+		}
+		entry, err := newAuditEntry(ctx, "batch_create", auditLink(link.Domain, link.ShortLink), nil, after, details)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+
+	err := repo.audited(ctx, entries, func(ctx context.Context) error {
+		return repo.storage.WriteListShortURL(ctx, links)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	registry := domains.Get()
 	for i, link := range shortenedLinks {
-		shortenedURL := configs.Cfg.BaseURL + "/" + link.ShortLink
-		shortenedLinks[i].setShortLink(shortenedURL)
+		shortenedLinks[i].setShortLink(registry.ShortURL(link.Domain, link.ShortLink))
 	}
 
 	return shortenedLinks, nil
 }
 
+// Match reports whether the link satisfies the filter.
+// The destination is matched by a case-insensitive substring.
+func (f ShortURLFilter) Match(s ShortURL) bool {
+	if f.ShortLink != "" && f.ShortLink != s.ShortLink {
+		return false
+	}
+	if f.Domain != nil && *f.Domain != s.Domain {
+		return false
+	}
+	if f.UserID != nil && *f.UserID != s.UserID {
+		return false
+	}
+	if f.InitialLink != "" && !strings.Contains(strings.ToLower(s.InitialLink), strings.ToLower(f.InitialLink)) {
+		return false
+	}
+	return true
+}
+
+func (s *ShortURL) setModeration(m Moderation) {
+	if !m.Disabled {
+		s.DisabledStatus = 0
+		s.DisabledReason = ""
+		return
+	}
+	s.DisabledStatus = m.Status
+	s.DisabledReason = m.Reason
+}
+
 func (s *ShortURLByUser) setShortLink(value string) {
 	(*s).ShortLink = value
 }
 
-func (repo *ShortURLStorage) DeleteShortURLUser(link string, id uint32) error {
-	err := repo.storage.DeleteShortURLByUser(link, id)
+// Delete the link of the domain of the user, the link before and after
+// the deletion is written to the audit trail.
+func (repo *ShortURLStorage) DeleteShortURLUser(ctx context.Context, domain, link string, id uint32) error {
+	before, err := repo.findOne(ctx, domain, link)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	var after *ShortURL
+	if before != nil {
+		deleted := *before
+		deleted.Deleted = true
+		after = &deleted
+	}
+	entry, err := newAuditEntry(ctx, "delete", auditLink(domain, link), before, after, nil)
+	if err != nil {
+		return err
+	}
+
+	return repo.audited(ctx, []audit.Entry{entry}, func(ctx context.Context) error {
+		return repo.storage.DeleteShortURLByUser(ctx, domain, link, id)
+	})
 }
 
-func (repo *ShortURLStorage) CheckURLsCreatedByUser(links []string, id uint32) ([]string, error) {
-	res, err := repo.storage.CheckURLsCreatedByUser(links, id)
+func (repo *ShortURLStorage) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) ([]string, error) {
+	res, err := repo.storage.CheckURLsCreatedByUser(ctx, domain, links, id)
 
 	if err != nil {
 		return nil, err
@@ -201,3 +412,216 @@ func (repo *ShortURLStorage) CheckURLsCreatedByUser(links []string, id uint32) (
 
 	return res, nil
 }
+
+// Find links of all users matching the filter, the search is written to the audit trail.
+func (repo *ShortURLStorage) FindShortURLs(ctx context.Context, filter ShortURLFilter) ([]ShortURL, error) {
+	res, err := repo.storage.FindShortURLs(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{
+		"destination": filter.InitialLink,
+		"code":        filter.ShortLink,
+		"found":       strconv.Itoa(len(res)),
+	}
+	if filter.Domain != nil {
+		details["domain"] = *filter.Domain
+	}
+	if filter.UserID != nil {
+		details["owner"] = strconv.FormatUint(uint64(*filter.UserID), 10)
+	}
+	if err = repo.record(ctx, "admin.search", "", details); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Disable or enable the link, the change is written to the audit trail.
+func (repo *ShortURLStorage) ModerateShortURL(ctx context.Context, domain, link string, m Moderation) error {
+	action, details := "admin.enable", map[string]string(nil)
+	if m.Disabled {
+		action, details = "admin.disable", map[string]string{
+			"reason": m.Reason,
+			"status": strconv.Itoa(m.Status),
+		}
+	}
+
+	return repo.update(ctx, action, domain, link, details, func(s *ShortURL) {
+		s.setModeration(m)
+	}, func(ctx context.Context) error {
+		return repo.storage.UpdateModeration(ctx, domain, link, m)
+	})
+}
+
+// Transfer the link to another owner, the change is written to the audit trail.
+func (repo *ShortURLStorage) ReassignShortURL(ctx context.Context, domain, link string, id uint32) error {
+	return repo.update(ctx, "admin.reassign", domain, link, nil, func(s *ShortURL) {
+		s.UserID = id
+	}, func(ctx context.Context) error {
+		return repo.storage.UpdateShortURLOwner(ctx, domain, link, id)
+	})
+}
+
+// Find entries of the audit trail matching the filter, newest first.
+func (repo *ShortURLStorage) FindAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	return repo.audit.Find(ctx, filter)
+}
+
+// Returns the blocklist destinations are checked against.
+func (repo *ShortURLStorage) Blocklist() *blocklist.List {
+	return repo.blocklist
+}
+
+// Checks the link against the blocklist, a match is written to the audit trail
+// as the action and returned as *utils.BlockedLinkError.
+func (repo *ShortURLStorage) checkBlocklist(ctx context.Context, action, shortLink, link string) error {
+	match, ok := repo.blocklist.Check(link)
+	if !ok {
+		return nil
+	}
+
+	details := map[string]string{
+		"url":   link,
+		"list":  match.List,
+		"entry": match.Entry,
+	}
+	if err := repo.record(ctx, action, shortLink, details); err != nil {
+		return err
+	}
+	repo.log(ctx).Warn().Str("action", action).Str("short_url", shortLink).Str("url", link).
+		Str("list", match.List).Str("entry", match.Entry).Msg("blocked link")
+
+	return utils.NewBlockedLinkError(link, match.List, match.Entry)
+}
+
+// Checks that the audit trail has not been tampered with.
+func (repo *ShortURLStorage) VerifyAuditTrail(ctx context.Context) error {
+	return repo.audit.Verify(ctx)
+}
+
+// Applies the edit to the existing link and writes the link before and after
+// the change to the audit trail, see audited. Every future edit of a link
+// is expected to go through update.
+func (repo *ShortURLStorage) update(ctx context.Context, action, domain, link string, details map[string]string,
+	change func(s *ShortURL), edit func(ctx context.Context) error) error {
+	before, err := repo.findOne(ctx, domain, link)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return utils.ErrLinkNotFound
+	}
+
+	after := *before
+	change(&after)
+	entry, err := newAuditEntry(ctx, action, auditLink(domain, link), before, &after, details)
+	if err != nil {
+		return err
+	}
+
+	return repo.audited(ctx, []audit.Entry{entry}, edit)
+}
+
+// Returns the link by the short link of the domain or nil if there is no such link.
+func (repo *ShortURLStorage) findOne(ctx context.Context, domain, link string) (*ShortURL, error) {
+	res, err := repo.storage.FindShortURLs(ctx, ShortURLFilter{ShortLink: link, Domain: &domain})
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return &res[0], nil
+}
+
+// pendingAudit holds the entries of a mutation for the backend
+// to write to the audit trail in the transaction of the mutation.
+type pendingAudit struct {
+	recorder *audit.DBRecorder
+	entries  []audit.Entry
+	written  bool
+}
+
+type contextKey int
+
+const contextKeyPendingAudit contextKey = iota
+
+// Returns the entries of the mutation the backend is applying with ctx or nil.
+func pendingAuditFromContext(ctx context.Context) *pendingAudit {
+	pending, _ := ctx.Value(contextKeyPendingAudit).(*pendingAudit)
+	return pending
+}
+
+// Applies the mutation and writes its entries to the audit trail. With the trail
+// kept in Postgres the entries are written in the transaction of the mutation,
+// see DBStorage.writeAudit, so that both are committed or neither is. Otherwise
+// they are written once the mutation is applied and an entry that cannot be
+// written fails the request.
+func (repo *ShortURLStorage) audited(ctx context.Context, entries []audit.Entry, mutate func(ctx context.Context) error) error {
+	var pending *pendingAudit
+	if recorder, ok := repo.audit.(*audit.DBRecorder); ok {
+		pending = &pendingAudit{recorder: recorder, entries: entries}
+		ctx = context.WithValue(ctx, contextKeyPendingAudit, pending)
+	}
+
+	if err := mutate(ctx); err != nil {
+		return err
+	}
+	if pending != nil && pending.written {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if err := repo.writeAudit(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the action performed by the actor from ctx, which changes no link, to the audit trail.
+func (repo *ShortURLStorage) record(ctx context.Context, action, link string, details map[string]string) error {
+	entry, err := newAuditEntry(ctx, action, link, nil, nil, details)
+	if err != nil {
+		return err
+	}
+	return repo.writeAudit(ctx, entry)
+}
+
+// Writes the entry to the audit trail, a failed write is counted and logged.
+func (repo *ShortURLStorage) writeAudit(ctx context.Context, entry audit.Entry) error {
+	err := repo.audit.Record(ctx, entry)
+	if err != nil {
+		metrics.AuditWriteErrors.Inc()
+		repo.log(ctx).Error().Err(err).Str("action", entry.Action).Str("short_url", entry.ShortURL).
+			Msg("cannot write the audit entry")
+	}
+	return err
+}
+
+// Returns the entry of the action performed by the actor from ctx
+// with the link before and after the action.
+func newAuditEntry(ctx context.Context, action, link string, before, after *ShortURL, details map[string]string) (audit.Entry, error) {
+	entry := audit.NewEntry(ctx, action, link)
+	entry.Details = details
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return entry, err
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// Returns the logger of the request from ctx or the logger of the storage.
+func (repo *ShortURLStorage) log(ctx context.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &repo.logger
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// slowStorage imitates the latency of a network backend like Postgres.
+type slowStorage struct {
+	StorageOperations
+	delay time.Duration
+}
+
+func (s *slowStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	time.Sleep(s.delay)
+	return s.StorageOperations.GetInitialLink(ctx, domain, shortLink)
+}
+
+func (s *slowStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	time.Sleep(s.delay)
+	return s.StorageOperations.WriteShortURL(ctx, shortURL)
+}
+
+// lockedStorage guards a backend with a single global lock, the writes
+// are serialized with each other and with the reads.
+type lockedStorage struct {
+	StorageOperations
+	m sync.RWMutex
+}
+
+func (s *lockedStorage) GetInitialLink(ctx context.Context, domain, shortLink string) (string, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.StorageOperations.GetInitialLink(ctx, domain, shortLink)
+}
+
+func (s *lockedStorage) WriteShortURL(ctx context.Context, shortURL *ShortURL) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.StorageOperations.WriteShortURL(ctx, shortURL)
+}
+
+// Benchmarks the repo with parallel mixes of redirects and creates
+// over the in memory backend and over a backend with the latency of a network,
+// each of them also behind a global lock to compare with.
+// Run with -cpu to compare the throughput for the number of goroutines.
+func BenchmarkShortURLStorage(b *testing.B) {
+	backends := []struct {
+		name string
+		new  func() StorageOperations
+	}{
+		{
+			name: "memory",
+			new:  func() StorageOperations { return NewInMemoryStorage() },
+		},
+		{
+			name: "slow",
+			new: func() StorageOperations {
+				return &slowStorage{StorageOperations: NewInMemoryStorage(), delay: 50 * time.Microsecond}
+			},
+		},
+		{
+			name: "memory_locked",
+			new:  func() StorageOperations { return &lockedStorage{StorageOperations: NewInMemoryStorage()} },
+		},
+		{
+			name: "slow_locked",
+			new: func() StorageOperations {
+				return &lockedStorage{StorageOperations: &slowStorage{StorageOperations: NewInMemoryStorage(), delay: 50 * time.Microsecond}}
+			},
+		},
+	}
+	mixes := []struct {
+		name          string
+		createPercent int
+	}{
+		{name: "redirect", createPercent: 0},
+		{name: "redirect90_create10", createPercent: 10},
+		{name: "redirect50_create50", createPercent: 50},
+	}
+
+	for _, backend := range backends {
+		for _, mix := range mixes {
+			b.Run(backend.name+"/"+mix.name, func(b *testing.B) {
+				benchmarkMix(b, backend.new(), mix.createPercent)
+			})
+		}
+	}
+}
+
+func benchmarkMix(b *testing.B, st StorageOperations, createPercent int) {
+	ctx := context.Background()
+	repo, err := newShortURLStorage(st, nil, zerolog.Nop())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	shortLinks := make([]string, 1000)
+	for i := range shortLinks {
+		link, err := repo.CreateShortURL(ctx, &ShortURL{InitialLink: fmt.Sprintf("https://example.com/seed/%d", i)})
+		if err != nil {
+			b.Fatal(err)
+		}
+		shortLinks[i] = link
+	}
+
+	var created int64
+	var seed int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+		for pb.Next() {
+			if rnd.Intn(100) < createPercent {
+				n := atomic.AddInt64(&created, 1)
+				shortURL := &ShortURL{InitialLink: fmt.Sprintf("https://example.com/new/%d", n)}
+				if _, err := repo.CreateShortURL(ctx, shortURL); err != nil {
+					b.Error(err)
+					return
+				}
+				continue
+			}
+			if _, err := repo.GetInitialLink(ctx, "", shortLinks[rnd.Intn(len(shortLinks))]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
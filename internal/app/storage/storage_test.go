@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+// failingRecorder fails to write entries to the audit trail once failing is set.
+type failingRecorder struct {
+	*audit.InMemoryRecorder
+	failing bool
+}
+
+func (r *failingRecorder) Record(ctx context.Context, entry audit.Entry) error {
+	if r.failing {
+		return errors.New("audit trail is unavailable")
+	}
+	return r.InMemoryRecorder.Record(ctx, entry)
+}
+
+func TestAuditFailureFailsTheMutation(t *testing.T) {
+	ctx := context.Background()
+	repo, err := newShortURLStorage(NewInMemoryStorage(), nil, zerolog.Nop())
+	require.NoError(t, err)
+	recorder := &failingRecorder{InMemoryRecorder: audit.NewInMemoryRecorder()}
+	repo.audit = recorder
+
+	link, err := repo.CreateShortURL(ctx, &ShortURL{InitialLink: "https://example.com/page", UserID: 1})
+	require.NoError(t, err)
+
+	recorder.failing = true
+	_, err = repo.CreateShortURL(ctx, &ShortURL{InitialLink: "https://example.com/other", UserID: 1})
+	assert.Error(t, err)
+	assert.Error(t, repo.DeleteShortURLUser(ctx, "", link, 1))
+	assert.Error(t, repo.ModerateShortURL(ctx, "", link, Moderation{Disabled: true, Status: 451, Reason: "spam"}))
+	_, err = repo.FindShortURLs(ctx, ShortURLFilter{})
+	assert.Error(t, err)
+
+	// the entries written before the failure keep the trail intact.
+	recorder.failing = false
+	require.NoError(t, repo.VerifyAuditTrail(ctx))
+	entries, err := repo.FindAuditEntries(ctx, audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "create", entries[0].Action)
+}
+
+func TestAuditEntriesOfChanges(t *testing.T) {
+	ctx := context.Background()
+	repo, err := newShortURLStorage(NewInMemoryStorage(), nil, zerolog.Nop())
+	require.NoError(t, err)
+
+	link, err := repo.CreateShortURL(ctx, &ShortURL{InitialLink: "https://example.com/page", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, repo.ReassignShortURL(ctx, "", link, 2))
+
+	entries, err := repo.FindAuditEntries(ctx, audit.Filter{Action: "admin.reassign"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Contains(t, string(entries[0].Before), `"user_id":1`)
+	assert.Contains(t, string(entries[0].After), `"user_id":2`)
+}
+
+func TestNewShortURLStorageMissingBlocklist(t *testing.T) {
+	old := *configs.Get()
+	cfg := old
+	cfg.BlocklistPaths = []string{filepath.Join(t.TempDir(), "missing.txt")}
+	configs.Set(cfg)
+	t.Cleanup(func() { configs.Set(old) })
+
+	_, err := newShortURLStorage(NewInMemoryStorage(), nil, zerolog.Nop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,475 @@
+// Package storagetest contains the conformance tests every
+// storage.StorageOperations and storage.RecordStore implementation must pass.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/storage"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/utils"
+)
+
+// Run runs the conformance tests as subtests of t,
+// every subtest gets an empty storage from newStorage.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.StorageOperations) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, st storage.StorageOperations)
+	}{
+		{name: "create", test: testCreate},
+		{name: "missing", test: testMissing},
+		{name: "duplicate", test: testDuplicate},
+		{name: "short link collision", test: testShortLinkCollision},
+		{name: "batch", test: testBatch},
+		{name: "batch duplicate", test: testBatchDuplicate},
+		{name: "list by user", test: testListByUser},
+		{name: "delete", test: testDelete},
+		{name: "ownership", test: testOwnership},
+		{name: "moderation", test: testModeration},
+		{name: "find", test: testFind},
+		{name: "domains", test: testDomains},
+		{name: "concurrent access", test: testConcurrentAccess},
+		{name: "concurrent duplicates", test: testConcurrentDuplicates},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func write(t *testing.T, st storage.StorageOperations, initialLink, shortLink string, userID uint32) {
+	t.Helper()
+	shortURL := &storage.ShortURL{InitialLink: initialLink, ShortLink: shortLink, UserID: userID}
+	require.NoError(t, st.WriteShortURL(context.Background(), shortURL))
+}
+
+func assertLink(t *testing.T, st storage.StorageOperations, shortLink, want string) {
+	t.Helper()
+	link, err := st.GetInitialLink(context.Background(), "", shortLink)
+	require.NoError(t, err)
+	assert.Equal(t, want, link)
+}
+
+func testCreate(t *testing.T, st storage.StorageOperations) {
+	write(t, st, "https://example.com/a", "a", 1)
+	assertLink(t, st, "a", "https://example.com/a")
+	require.NoError(t, st.CheckHealth(context.Background()))
+}
+
+func testMissing(t *testing.T, st storage.StorageOperations) {
+	link, err := st.GetInitialLink(context.Background(), "", "missing")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound)
+	assert.Empty(t, link)
+}
+
+func testDuplicate(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+
+	duplicate := &storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "b", UserID: 2}
+	err := st.WriteShortURL(ctx, duplicate)
+	assert.ErrorIs(t, err, utils.ErrUniqueLink)
+	assert.Equal(t, "a", duplicate.ShortLink, "the stored short link must be returned")
+
+	assertLink(t, st, duplicate.ShortLink, "https://example.com/a")
+	_, err = st.GetInitialLink(ctx, "", "b")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound, "the duplicate must not be written")
+}
+
+func testShortLinkCollision(t *testing.T, st storage.StorageOperations) {
+	write(t, st, "https://example.com/a", "a", 1)
+
+	collision := &storage.ShortURL{InitialLink: "https://example.com/b", ShortLink: "a", UserID: 2}
+	err := st.WriteShortURL(context.Background(), collision)
+	assert.ErrorIs(t, err, utils.ErrUniqueLink)
+	assertLink(t, st, "a", "https://example.com/a")
+}
+
+func testBatch(t *testing.T, st storage.StorageOperations) {
+	require.NoError(t, st.WriteListShortURL(context.Background(), []storage.ShortURLByUser{
+		{InitialLink: "https://example.com/a", ShortLink: "a", CorrelationID: "1"},
+		{InitialLink: "https://example.com/b", ShortLink: "b", CorrelationID: "2"},
+	}))
+
+	assertLink(t, st, "a", "https://example.com/a")
+	assertLink(t, st, "b", "https://example.com/b")
+}
+
+func testBatchDuplicate(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/b", "b", 1)
+
+	err := st.WriteListShortURL(ctx, []storage.ShortURLByUser{
+		{InitialLink: "https://example.com/a", ShortLink: "a"},
+		{InitialLink: "https://example.com/b", ShortLink: "c"},
+	})
+	assert.ErrorIs(t, err, utils.ErrUniqueLink)
+
+	_, err = st.GetInitialLink(ctx, "", "a")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound, "the batch must be written entirely or not at all")
+
+	err = st.WriteListShortURL(ctx, []storage.ShortURLByUser{
+		{InitialLink: "https://example.com/d", ShortLink: "d"},
+		{InitialLink: "https://example.com/d", ShortLink: "e"},
+	})
+	assert.ErrorIs(t, err, utils.ErrUniqueLink, "the links of the batch must be unique")
+
+	_, err = st.GetInitialLink(ctx, "", "d")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound)
+}
+
+func testListByUser(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+	write(t, st, "https://example.com/b", "b", 1)
+	write(t, st, "https://example.com/c", "c", 2)
+
+	links, err := st.GetAllShortURLByUser(ctx, 1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []storage.ShortURLByUser{
+		{InitialLink: "https://example.com/a", ShortLink: "a"},
+		{InitialLink: "https://example.com/b", ShortLink: "b"},
+	}, links)
+
+	links, err = st.GetAllShortURLByUser(ctx, 3)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+}
+
+func testDelete(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+
+	require.NoError(t, st.DeleteShortURLByUser(ctx, "", "a", 2))
+	assertLink(t, st, "a", "https://example.com/a")
+
+	require.NoError(t, st.DeleteShortURLByUser(ctx, "", "a", 1))
+	_, err := st.GetInitialLink(ctx, "", "a")
+	assert.ErrorIs(t, err, utils.ErrDeletedLink)
+
+	found, err := st.FindShortURLs(ctx, storage.ShortURLFilter{ShortLink: "a"})
+	require.NoError(t, err)
+	require.Len(t, found, 1, "the deleted link is kept")
+	assert.True(t, found[0].Deleted)
+
+	require.NoError(t, st.DeleteShortURLByUser(ctx, "", "missing", 1))
+}
+
+func testOwnership(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+	write(t, st, "https://example.com/b", "b", 2)
+
+	notOwned, err := st.CheckURLsCreatedByUser(ctx, "", []string{"a", "b", "missing"}, 1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b", "missing"}, notOwned)
+
+	notOwned, err = st.CheckURLsCreatedByUser(ctx, "", []string{"a"}, 1)
+	require.NoError(t, err)
+	assert.Empty(t, notOwned)
+
+	require.NoError(t, st.UpdateShortURLOwner(ctx, "", "b", 1))
+	notOwned, err = st.CheckURLsCreatedByUser(ctx, "", []string{"a", "b"}, 1)
+	require.NoError(t, err)
+	assert.Empty(t, notOwned)
+
+	links, err := st.GetAllShortURLByUser(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	assert.ErrorIs(t, st.UpdateShortURLOwner(ctx, "", "missing", 1), utils.ErrLinkNotFound)
+}
+
+func testModeration(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+
+	moderation := storage.Moderation{Disabled: true, Reason: "phishing", Status: http.StatusUnavailableForLegalReasons}
+	require.NoError(t, st.UpdateModeration(ctx, "", "a", moderation))
+	_, err := st.GetInitialLink(ctx, "", "a")
+	assert.ErrorIs(t, err, utils.ErrDisabledLink)
+
+	require.NoError(t, st.UpdateModeration(ctx, "", "a", storage.Moderation{}))
+	assertLink(t, st, "a", "https://example.com/a")
+
+	assert.ErrorIs(t, st.UpdateModeration(ctx, "", "missing", moderation), utils.ErrLinkNotFound)
+}
+
+func testFind(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+	write(t, st, "https://example.org/b", "b", 2)
+
+	found, err := st.FindShortURLs(ctx, storage.ShortURLFilter{InitialLink: "EXAMPLE.COM"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "a", UserID: 1}, found[0])
+
+	userID := uint32(2)
+	found, err = st.FindShortURLs(ctx, storage.ShortURLFilter{UserID: &userID})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "b", found[0].ShortLink)
+
+	found, err = st.FindShortURLs(ctx, storage.ShortURLFilter{ShortLink: "missing"})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+// The domain of the links of testDomains besides the default one.
+const brandDomain = "go.brand.example"
+
+func testDomains(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	write(t, st, "https://example.com/a", "a", 1)
+	err := st.WriteShortURL(ctx, &storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "a", Domain: brandDomain, UserID: 2})
+	require.NoError(t, err, "the short link and the initial link are unique within the domain")
+	require.NoError(t, st.WriteListShortURL(ctx, []storage.ShortURLByUser{
+		{InitialLink: "https://example.com/b", ShortLink: "b", Domain: brandDomain},
+	}))
+
+	err = st.WriteShortURL(ctx, &storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "c", Domain: brandDomain})
+	assert.ErrorIs(t, err, utils.ErrUniqueLink)
+	_, err = st.GetInitialLink(ctx, "", "b")
+	assert.ErrorIs(t, err, utils.ErrLinkNotFound, "the link of a domain is not in the default one")
+	link, err := st.GetInitialLink(ctx, brandDomain, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/b", link)
+
+	links, err := st.GetAllShortURLByUser(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ShortURLByUser{{InitialLink: "https://example.com/a", ShortLink: "a", Domain: brandDomain}}, links)
+
+	notOwned, err := st.CheckURLsCreatedByUser(ctx, brandDomain, []string{"a"}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, notOwned, "the user owns the link of the default domain only")
+
+	moderation := storage.Moderation{Disabled: true, Reason: "phishing", Status: http.StatusUnavailableForLegalReasons}
+	require.NoError(t, st.UpdateModeration(ctx, brandDomain, "a", moderation))
+	_, err = st.GetInitialLink(ctx, brandDomain, "a")
+	assert.ErrorIs(t, err, utils.ErrDisabledLink)
+	assertLink(t, st, "a", "https://example.com/a")
+
+	require.NoError(t, st.DeleteShortURLByUser(ctx, "", "a", 1))
+	link, err = st.GetInitialLink(ctx, brandDomain, "a")
+	assert.ErrorIs(t, err, utils.ErrDisabledLink, "the link of the other domain is not deleted")
+	assert.Empty(t, link)
+
+	domain := brandDomain
+	found, err := st.FindShortURLs(ctx, storage.ShortURLFilter{ShortLink: "a", Domain: &domain})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, storage.ShortURL{
+		InitialLink: "https://example.com/a", ShortLink: "a", Domain: brandDomain, UserID: 2,
+		DisabledStatus: http.StatusUnavailableForLegalReasons, DisabledReason: "phishing",
+	}, found[0])
+	found, err = st.FindShortURLs(ctx, storage.ShortURLFilter{ShortLink: "a"})
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+}
+
+func testConcurrentAccess(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shortLink := fmt.Sprintf("s%d", i)
+			shortURL := &storage.ShortURL{InitialLink: "https://example.com/" + shortLink, ShortLink: shortLink, UserID: uint32(i % 2)}
+			if !assert.NoError(t, st.WriteShortURL(ctx, shortURL)) {
+				return
+			}
+			link, err := st.GetInitialLink(ctx, "", shortLink)
+			assert.NoError(t, err)
+			assert.Equal(t, shortURL.InitialLink, link)
+			_, err = st.GetAllShortURLByUser(ctx, shortURL.UserID)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	found, err := st.FindShortURLs(ctx, storage.ShortURLFilter{})
+	require.NoError(t, err)
+	assert.Len(t, found, 20)
+}
+
+func testConcurrentDuplicates(t *testing.T, st storage.StorageOperations) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	written := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shortURL := &storage.ShortURL{InitialLink: "https://example.com", ShortLink: fmt.Sprintf("s%d", i)}
+			err := st.WriteShortURL(ctx, shortURL)
+			if err == nil {
+				m.Lock()
+				written++
+				m.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, utils.ErrUniqueLink)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, written, "the initial link must be written once")
+	found, err := st.FindShortURLs(ctx, storage.ShortURLFilter{InitialLink: "example.com"})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+}
+
+// RunRecords runs the conformance tests of storage.RecordStore as subtests of t,
+// every subtest gets an empty storage from newStorage.
+func RunRecords(t *testing.T, newStorage func(t *testing.T) storage.RecordStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, st storage.RecordStore)
+	}{
+		{name: "import and export", test: testImportExport},
+		{name: "export pages", test: testExportPages},
+		{name: "import duplicate", test: testImportDuplicate},
+		{name: "export domains", test: testExportDomains},
+		{name: "export after changes", test: testExportAfterChanges},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+// Returns the records with all the fields set.
+func testRecords() []storage.Record {
+	createdAt := time.Date(2021, time.March, 4, 0, 0, 0, 0, time.UTC)
+	return []storage.Record{
+		{ShortURL: storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "a", UserID: 1}, CreatedAt: createdAt},
+		{ShortURL: storage.ShortURL{InitialLink: "https://example.com/b", ShortLink: "b", UserID: 2, Deleted: true}, CreatedAt: createdAt},
+		{
+			ShortURL: storage.ShortURL{
+				InitialLink: "https://example.com/c", ShortLink: "c", UserID: 1,
+				DisabledStatus: http.StatusGone, DisabledReason: "spam",
+			},
+		},
+	}
+}
+
+func testImportExport(t *testing.T, st storage.RecordStore) {
+	ctx := context.Background()
+	require.NoError(t, st.ImportRecords(ctx, testRecords()))
+
+	records, err := st.ExportRecords(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	for i, want := range testRecords() {
+		assert.Equal(t, want.ShortURL, records[i].ShortURL)
+		assert.True(t, want.CreatedAt.Equal(records[i].CreatedAt), "the creation date of %s", want.ShortLink)
+	}
+
+	ops, ok := st.(storage.StorageOperations)
+	require.True(t, ok)
+	_, err = ops.GetInitialLink(ctx, "", "b")
+	assert.ErrorIs(t, err, utils.ErrDeletedLink)
+	_, err = ops.GetInitialLink(ctx, "", "c")
+	assert.ErrorIs(t, err, utils.ErrDisabledLink)
+	links, err := ops.GetAllShortURLByUser(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+}
+
+func testExportPages(t *testing.T, st storage.RecordStore) {
+	ctx := context.Background()
+	require.NoError(t, st.ImportRecords(ctx, testRecords()))
+
+	records, err := st.ExportRecords(ctx, "", 2)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "a", records[0].ShortLink)
+	assert.Equal(t, "b", records[1].ShortLink)
+
+	records, err = st.ExportRecords(ctx, "b", 2)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "c", records[0].ShortLink)
+
+	records, err = st.ExportRecords(ctx, "c", 2)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func testImportDuplicate(t *testing.T, st storage.RecordStore) {
+	ctx := context.Background()
+	records := testRecords()
+	require.NoError(t, st.ImportRecords(ctx, records[:1]))
+
+	d := storage.Record{ShortURL: storage.ShortURL{InitialLink: "https://example.com/d", ShortLink: "d"}}
+	err := st.ImportRecords(ctx, []storage.Record{d, records[0]})
+	assert.ErrorIs(t, err, utils.ErrUniqueLink, "the short link is already there")
+
+	sameInitialLink := storage.Record{ShortURL: storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "e"}}
+	err = st.ImportRecords(ctx, []storage.Record{d, sameInitialLink})
+	assert.ErrorIs(t, err, utils.ErrUniqueLink, "the initial link is already there")
+
+	exported, err := st.ExportRecords(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, exported, 1, "the records must be written all or none")
+}
+
+func testExportAfterChanges(t *testing.T, st storage.RecordStore) {
+	ctx := context.Background()
+	records := testRecords()
+	require.NoError(t, st.ImportRecords(ctx, []storage.Record{records[0], records[2]}))
+	exported, err := st.ExportRecords(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+
+	require.NoError(t, st.ImportRecords(ctx, records[1:2]))
+	ops, ok := st.(storage.StorageOperations)
+	require.True(t, ok)
+	require.NoError(t, ops.UpdateShortURLOwner(ctx, "", "a", 3))
+
+	exported, err = st.ExportRecords(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, exported, 3)
+	assert.Equal(t, "b", exported[1].ShortLink)
+	assert.Equal(t, uint32(3), exported[0].UserID)
+}
+
+func testExportDomains(t *testing.T, st storage.RecordStore) {
+	ctx := context.Background()
+	require.NoError(t, st.ImportRecords(ctx, []storage.Record{
+		{ShortURL: storage.ShortURL{InitialLink: "https://example.com/b", ShortLink: "b"}},
+		{ShortURL: storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "a", Domain: brandDomain}},
+		{ShortURL: storage.ShortURL{InitialLink: "https://example.com/a", ShortLink: "a"}},
+	}))
+
+	err := st.ImportRecords(ctx, []storage.Record{
+		{ShortURL: storage.ShortURL{InitialLink: "https://example.com/c", ShortLink: "a", Domain: brandDomain}},
+	})
+	assert.ErrorIs(t, err, utils.ErrUniqueLink)
+
+	var keys []string
+	after := ""
+	for {
+		records, err := st.ExportRecords(ctx, after, 1)
+		require.NoError(t, err)
+		if len(records) == 0 {
+			break
+		}
+		after = records[0].Key()
+		keys = append(keys, after)
+	}
+	assert.Equal(t, []string{"a", storage.LinkKey(brandDomain, "a"), "b"}, keys)
+}
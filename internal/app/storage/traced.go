@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/audit"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/health"
+	"github.com/GorunovAlx/shortening_long_url/internal/app/tracing"
+)
+
+// tracedRepo wraps every call of the repo into a span,
+// the queries of the call are traced as children of the span.
+type tracedRepo struct {
+	repo ShortURLRepo
+}
+
+// Returns the repo with its calls traced.
+func Traced(repo ShortURLRepo) ShortURLRepo {
+	return &tracedRepo{
+		repo: repo,
+	}
+}
+
+func (t *tracedRepo) GetInitialLink(ctx context.Context, domain, shortLink string) (link string, err error) {
+	ctx, span := t.start(ctx, "GetInitialLink", attribute.String("domain", domain), attribute.String("short_url", shortLink))
+	defer t.end(span, &err)
+	return t.repo.GetInitialLink(ctx, domain, shortLink)
+}
+
+func (t *tracedRepo) CreateShortURL(ctx context.Context, shortURL *ShortURL) (link string, err error) {
+	ctx, span := t.start(ctx, "CreateShortURL")
+	defer t.end(span, &err)
+	return t.repo.CreateShortURL(ctx, shortURL)
+}
+
+func (t *tracedRepo) CreateListShortURL(ctx context.Context, links []ShortURLByUser) (res []ShortURLByUser, err error) {
+	ctx, span := t.start(ctx, "CreateListShortURL", attribute.Int("links", len(links)))
+	defer t.end(span, &err)
+	return t.repo.CreateListShortURL(ctx, links)
+}
+
+func (t *tracedRepo) GetAllShortURLUser(ctx context.Context, id uint32) (res []ShortURLByUser, err error) {
+	ctx, span := t.start(ctx, "GetAllShortURLUser")
+	defer t.end(span, &err)
+	return t.repo.GetAllShortURLUser(ctx, id)
+}
+
+func (t *tracedRepo) PingDB(ctx context.Context) (err error) {
+	ctx, span := t.start(ctx, "PingDB")
+	defer t.end(span, &err)
+	return t.repo.PingDB(ctx)
+}
+
+func (t *tracedRepo) DeleteShortURLUser(ctx context.Context, domain, link string, id uint32) (err error) {
+	ctx, span := t.start(ctx, "DeleteShortURLUser", attribute.String("domain", domain), attribute.String("short_url", link))
+	defer t.end(span, &err)
+	return t.repo.DeleteShortURLUser(ctx, domain, link, id)
+}
+
+func (t *tracedRepo) CheckURLsCreatedByUser(ctx context.Context, domain string, links []string, id uint32) (res []string, err error) {
+	ctx, span := t.start(ctx, "CheckURLsCreatedByUser", attribute.String("domain", domain), attribute.Int("links", len(links)))
+	defer t.end(span, &err)
+	return t.repo.CheckURLsCreatedByUser(ctx, domain, links, id)
+}
+
+func (t *tracedRepo) FindShortURLs(ctx context.Context, filter ShortURLFilter) (res []ShortURL, err error) {
+	ctx, span := t.start(ctx, "FindShortURLs")
+	defer t.end(span, &err)
+	return t.repo.FindShortURLs(ctx, filter)
+}
+
+func (t *tracedRepo) ModerateShortURL(ctx context.Context, domain, link string, m Moderation) (err error) {
+	ctx, span := t.start(ctx, "ModerateShortURL", attribute.String("domain", domain), attribute.String("short_url", link))
+	defer t.end(span, &err)
+	return t.repo.ModerateShortURL(ctx, domain, link, m)
+}
+
+func (t *tracedRepo) ReassignShortURL(ctx context.Context, domain, link string, id uint32) (err error) {
+	ctx, span := t.start(ctx, "ReassignShortURL", attribute.String("domain", domain), attribute.String("short_url", link))
+	defer t.end(span, &err)
+	return t.repo.ReassignShortURL(ctx, domain, link, id)
+}
+
+func (t *tracedRepo) FindAuditEntries(ctx context.Context, filter audit.Filter) (res []audit.Entry, err error) {
+	ctx, span := t.start(ctx, "FindAuditEntries")
+	defer t.end(span, &err)
+	return t.repo.FindAuditEntries(ctx, filter)
+}
+
+func (t *tracedRepo) HealthComponents() []health.Component {
+	return t.repo.HealthComponents()
+}
+
+func (t *tracedRepo) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "ShortURLStorage."+method, trace.WithAttributes(attrs...))
+}
+
+// Ends the span, the expected results like a deleted link are not recorded as errors.
+func (t *tracedRepo) end(span trace.Span, err *error) {
+	if isExpected(*err) {
+		span.SetAttributes(attribute.String("result", (*err).Error()))
+		tracing.End(span, nil)
+		return
+	}
+	tracing.End(span, *err)
+}
@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PgxLogger turns the queries logged by pgx into spans and passes
+// the messages up to Level to the Next logger. pgx v4 has no query hooks
+// but logs every query with its duration at the info level,
+// so the connections must be configured with ConnLevel.
+type PgxLogger struct {
+	Next  pgx.Logger
+	Level pgx.LogLevel
+}
+
+// ConnLevel returns the level the connections must log at:
+// the configured level, but at least info.
+func (l *PgxLogger) ConnLevel() pgx.LogLevel {
+	if l.Level < pgx.LogLevelInfo {
+		return pgx.LogLevelInfo
+	}
+	return l.Level
+}
+
+// Log implements pgx.Logger. A span is created only inside a traced request,
+// it is started the query duration ago and ends now.
+func (l *PgxLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	if d, ok := data["time"].(time.Duration); ok && trace.SpanContextFromContext(ctx).IsValid() {
+		end := time.Now()
+		_, span := Start(ctx, "postgres "+msg,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithTimestamp(end.Add(-d)),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL),
+		)
+		if sql, ok := data["sql"].(string); ok {
+			span.SetAttributes(semconv.DBStatementKey.String(sql))
+		}
+		if rows, ok := data["rowCount"].(int); ok {
+			span.SetAttributes(attribute.Int("db.rows", rows))
+		}
+		if err, ok := data["err"].(error); ok {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End(trace.WithTimestamp(end))
+	}
+
+	if l.Next != nil && level <= l.Level {
+		l.Next.Log(ctx, level, msg, data)
+	}
+}
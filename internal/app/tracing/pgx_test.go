@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type countingLogger struct {
+	messages []string
+}
+
+func (l *countingLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	l.messages = append(l.messages, msg)
+}
+
+func TestPgxLogger(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	next := &countingLogger{}
+	logger := &PgxLogger{Next: next, Level: pgx.LogLevelWarn}
+	assert.Equal(t, pgx.LogLevel(pgx.LogLevelInfo), logger.ConnLevel())
+
+	// queries outside of a trace are not traced.
+	logger.Log(context.Background(), pgx.LogLevelInfo, "Query", map[string]interface{}{
+		"sql":  "select 1",
+		"time": time.Millisecond,
+	})
+	assert.Empty(t, recorder.Ended())
+
+	ctx, parent := Start(context.Background(), "request")
+	logger.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{
+		"sql":      "select initial_link from short_urls where short_url = $1",
+		"time":     50 * time.Millisecond,
+		"rowCount": 1,
+	})
+	logger.Log(ctx, pgx.LogLevelError, "Exec", map[string]interface{}{
+		"sql":  "insert into short_urls values ($1)",
+		"time": time.Millisecond,
+		"err":  errors.New("unique violation"),
+	})
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	query, exec := spans[0], spans[1]
+	assert.Equal(t, "postgres Query", query.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(t, 50*time.Millisecond, query.EndTime().Sub(query.StartTime()))
+	assert.Contains(t, query.Attributes(), semconv.DBStatementKey.String("select initial_link from short_urls where short_url = $1"))
+	assert.Equal(t, codes.Error, exec.Status().Code)
+
+	// only the error is passed to the next logger configured with warn.
+	assert.Equal(t, []string{"Exec"}, next.messages)
+}
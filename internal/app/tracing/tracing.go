@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+const instrumentationName = "github.com/GorunovAlx/shortening_long_url"
+
+var provider *sdktrace.TracerProvider
+
+// Init sets the W3C trace context and baggage propagator and,
+// if the OTLP endpoint is configured, the tracer provider exporting
+// the spans to it over http. Without the endpoint the spans are not recorded.
+func Init(ctx context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	cfg := configs.Get()
+	if cfg.TracingOTLPEndpoint == "" {
+		return nil
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(cfg.TracingOTLPEndpoint),
+	}
+	if cfg.TracingOTLPInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(cfg.TracingServiceName),
+	))
+	if err != nil {
+		return err
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return nil
+}
+
+// Shutdown exports the remaining spans and stops the exporter.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Returns the tracer of the service from the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a span as a child of the span in ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End records the error in the span unless it is nil and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// HandlerFunc wraps the handler into a span with the name, so that
+// the time of the handler is told apart from the time of the middlewares.
+func HandlerFunc(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), name)
+		defer span.End()
+
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// TraceID returns the hex trace id of the span in ctx or an empty string.
+func TraceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
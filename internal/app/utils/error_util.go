@@ -5,12 +5,46 @@ import (
 	"fmt"
 )
 
+// The kinds of errors, errors.Is(err, ErrNotFound) reports whether err is of the kind.
+// The storage backends return errors of these kinds only, the handlers map
+// the kinds to the http statuses.
 var (
-	ErrUniqueLink  = errors.New(`this link already exists`)
-	ErrDeletedLink = errors.New(`this link has been removed`)
+	ErrNotFound    = errors.New(`not found`)
+	ErrConflict    = errors.New(`conflict`)
+	ErrForbidden   = errors.New(`forbidden`)
+	ErrGone        = errors.New(`gone`)
+	ErrValidation  = errors.New(`validation failed`)
+	ErrUnavailable = errors.New(`unavailable`)
+)
+
+var kinds = []error{ErrNotFound, ErrConflict, ErrForbidden, ErrGone, ErrValidation, ErrUnavailable}
+
+var (
+	ErrUniqueLink      = NewKindError(ErrConflict, `this link already exists`)
+	ErrDeletedLink     = NewKindError(ErrGone, `this link has been removed`)
+	ErrDisabledLink    = NewKindError(ErrGone, `this link has been disabled`)
+	ErrLinkNotFound    = NewKindError(ErrNotFound, `this link does not exist`)
+	ErrNotOwner        = NewKindError(ErrForbidden, `the links are not created by the user`)
+	ErrAuditTampered   = errors.New(`the audit trail has been tampered with`)
+	ErrPolicyViolation = NewKindError(ErrValidation, `the link is rejected by the url policy`)
+	ErrBlockedLink     = NewKindError(ErrValidation, `the link is in a malware or phishing blocklist`)
 )
 
 type (
+	// KindError is an error of the kind with the message,
+	// Err is the cause if any.
+	KindError struct {
+		Kind    error
+		Message string
+		Err     error
+	}
+
+	// NotOwnerError lists the links the user is not the owner of.
+	NotOwnerError struct {
+		Links []string
+		Err   error
+	}
+
 	InsertUniqueLinkError struct {
 		Link string
 		Err  error
@@ -20,8 +54,83 @@ type (
 		ShortURL string
 		Err      error
 	}
+
+	// DisabledLinkError is returned for a link disabled by moderation,
+	// Status is the http status the link must be served with.
+	DisabledLinkError struct {
+		ShortURL string
+		Reason   string
+		Status   int
+		Err      error
+	}
+
+	// AuditTamperedError points to the first entry of the audit trail
+	// that breaks the hash chain.
+	AuditTamperedError struct {
+		Seq    uint64
+		Reason string
+		Err    error
+	}
+
+	// PolicyViolationError names the rule of the url policy the link is rejected by.
+	PolicyViolationError struct {
+		Link   string
+		Rule   string
+		Reason string
+		Err    error
+	}
+
+	// BlockedLinkError names the blocklist and its entry the link is matched by.
+	BlockedLinkError struct {
+		Link  string
+		List  string
+		Entry string
+		Err   error
+	}
 )
 
+func NewKindError(kind error, msg string) error {
+	return &KindError{
+		Kind:    kind,
+		Message: msg,
+	}
+}
+
+// Returns an error of the ErrValidation kind.
+func NewValidationError(msg string) error {
+	return NewKindError(ErrValidation, msg)
+}
+
+// Returns an error of the ErrUnavailable kind caused by err, nil if err is nil.
+// An error of a kind is returned as is.
+func NewUnavailableError(err error) error {
+	if err == nil || Kind(err) != nil {
+		return err
+	}
+	return &KindError{
+		Kind:    ErrUnavailable,
+		Message: `the storage is unavailable`,
+		Err:     err,
+	}
+}
+
+func NewNotOwnerError(links []string) error {
+	return &NotOwnerError{
+		Err:   ErrNotOwner,
+		Links: links,
+	}
+}
+
+// Kind returns the kind of err or nil if err is not of any kind.
+func Kind(err error) error {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
 func NewInsertUniqueLinkError(l string) error {
 	return &InsertUniqueLinkError{
 		Err:  ErrUniqueLink,
@@ -36,6 +145,52 @@ func NewDeletedLinkError(su string) error {
 	}
 }
 
+func NewDisabledLinkError(su, reason string, status int) error {
+	return &DisabledLinkError{
+		Err:      ErrDisabledLink,
+		ShortURL: su,
+		Reason:   reason,
+		Status:   status,
+	}
+}
+
+func NewAuditTamperedError(seq uint64, reason string) error {
+	return &AuditTamperedError{
+		Err:    ErrAuditTampered,
+		Seq:    seq,
+		Reason: reason,
+	}
+}
+
+func NewPolicyViolationError(link, rule, reason string) error {
+	return &PolicyViolationError{
+		Err:    ErrPolicyViolation,
+		Link:   link,
+		Rule:   rule,
+		Reason: reason,
+	}
+}
+
+func NewBlockedLinkError(link, list, entry string) error {
+	return &BlockedLinkError{
+		Err:   ErrBlockedLink,
+		Link:  link,
+		List:  list,
+		Entry: entry,
+	}
+}
+
+func (ke *KindError) Error() string {
+	if ke.Err != nil {
+		return fmt.Sprintf("%v: %v", ke.Message, ke.Err)
+	}
+	return ke.Message
+}
+
+func (no *NotOwnerError) Error() string {
+	return fmt.Sprintf("%v: %v", no.Err, no.Links)
+}
+
 func (iu *InsertUniqueLinkError) Error() string {
 	return fmt.Sprintf("%v: %v", iu.Err, iu.Link)
 }
@@ -44,6 +199,35 @@ func (de *DeletedLinkError) Error() string {
 	return fmt.Sprintf("%v: %v", de.Err, de.ShortURL)
 }
 
+func (di *DisabledLinkError) Error() string {
+	return fmt.Sprintf("%v: %v: %v", di.Err, di.ShortURL, di.Reason)
+}
+
+func (at *AuditTamperedError) Error() string {
+	return fmt.Sprintf("%v: entry %d: %v", at.Err, at.Seq, at.Reason)
+}
+
+func (pv *PolicyViolationError) Error() string {
+	return fmt.Sprintf("%v: %v: %v", pv.Err, pv.Rule, pv.Reason)
+}
+
+func (bl *BlockedLinkError) Error() string {
+	return fmt.Sprintf("%v: %v: %v", bl.Err, bl.List, bl.Entry)
+}
+
+func (ke *KindError) Unwrap() error {
+	return ke.Err
+}
+
+// Is reports whether target is the kind of the error.
+func (ke *KindError) Is(target error) bool {
+	return target == ke.Kind
+}
+
+func (no *NotOwnerError) Unwrap() error {
+	return no.Err
+}
+
 func (iu *InsertUniqueLinkError) Unwrap() error {
 	return iu.Err
 }
@@ -51,3 +235,19 @@ func (iu *InsertUniqueLinkError) Unwrap() error {
 func (de *DeletedLinkError) Unwrap() error {
 	return de.Err
 }
+
+func (di *DisabledLinkError) Unwrap() error {
+	return di.Err
+}
+
+func (at *AuditTamperedError) Unwrap() error {
+	return at.Err
+}
+
+func (pv *PolicyViolationError) Unwrap() error {
+	return pv.Err
+}
+
+func (bl *BlockedLinkError) Unwrap() error {
+	return bl.Err
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "sentinel of a kind",
+			err:  ErrLinkNotFound,
+			want: ErrNotFound,
+		},
+		{
+			name: "typed error wrapping a sentinel",
+			err:  NewDeletedLinkError("abc"),
+			want: ErrGone,
+		},
+		{
+			name: "wrapped typed error",
+			err:  fmt.Errorf("delete: %w", NewNotOwnerError([]string{"abc"})),
+			want: ErrForbidden,
+		},
+		{
+			name: "unavailable with the cause",
+			err:  NewUnavailableError(errors.New("connection refused")),
+			want: ErrUnavailable,
+		},
+		{
+			name: "error without a kind",
+			err:  errors.New("unexpected"),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Kind(tt.err))
+		})
+	}
+}
+
+func TestNewUnavailableError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := NewUnavailableError(cause)
+	assert.ErrorIs(t, err, cause)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, "the storage is unavailable: connection refused", err.Error())
+
+	assert.Same(t, ErrLinkNotFound, NewUnavailableError(ErrLinkNotFound))
+	assert.NoError(t, NewUnavailableError(nil))
+}
@@ -1,25 +1,72 @@
 package utils
 
 import (
+	"io"
+	"log"
 	"os"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
 )
 
-var Logger zerolog.Logger
+// Logger is the logger of the service, it writes to stderr until LoggerInit configures it.
+var Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 
-func LoggerInit() {
-	logfile, err := os.OpenFile("server_logs.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal().Err(err).Msgf("Cannot start %v", logfile)
+// LoggerInit configures Logger with the level and the output from config.
+// The level is set globally, so that ApplyLogLevel changes it for
+// the copies of Logger too. The standard logger is redirected to Logger,
+// so that the remaining log.Println calls are written as json too.
+func LoggerInit() error {
+	if err := ApplyLogLevel(); err != nil {
+		return err
 	}
-	defer logfile.Close()
 
-	Logger := zerolog.New(logfile).With().Timestamp().Logger()
-	zerolog.SetGlobalLevel(zerolog.Level(configs.Cfg.ZerologLevel))
+	Logger = zerolog.New(logOutput()).With().Timestamp().Logger()
+
+	log.SetFlags(0)
+	log.SetOutput(Logger)
 
 	Logger.Info().Msg("start server")
+	return nil
+}
+
+// ApplyLogLevel sets the level from the current config, it is called on reload.
+func ApplyLogLevel() error {
+	level, err := LogLevel()
+	if err != nil {
+		return err
+	}
+
+	zerolog.SetGlobalLevel(level)
+	return nil
+}
+
+// LogLevel returns the level named by LOG_LEVEL or the numeric ZERO_LOG_LEVEL if it is empty.
+func LogLevel() (zerolog.Level, error) {
+	cfg := configs.Get()
+	if cfg.LogLevel != "" {
+		return zerolog.ParseLevel(cfg.LogLevel)
+	}
+	return zerolog.Level(cfg.ZerologLevel), nil
+}
+
+// Returns stdout, stderr or the file rotated by size.
+// The file stays open for the lifetime of the process.
+func logOutput() io.Writer {
+	cfg := configs.Get()
+	switch cfg.LogOutput {
+	case "", "stdout":
+		return os.Stdout
+	case "stderr":
+		return os.Stderr
+	}
+
+	return &lumberjack.Logger{
+		Filename:   cfg.LogOutput,
+		MaxSize:    cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+		MaxAge:     cfg.LogMaxAgeDays,
+	}
 }
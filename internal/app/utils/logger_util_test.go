@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GorunovAlx/shortening_long_url/internal/app/configs"
+)
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		name         string
+		logLevel     string
+		zerologLevel int8
+		want         zerolog.Level
+		wantErr      bool
+	}{
+		{
+			name:     "level by name",
+			logLevel: "warn",
+			want:     zerolog.WarnLevel,
+		},
+		{
+			name:         "numeric level if the name is empty",
+			zerologLevel: int8(zerolog.ErrorLevel),
+			want:         zerolog.ErrorLevel,
+		},
+		{
+			name:     "unknown level",
+			logLevel: "loud",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs.Set(configs.Config{LogLevel: tt.logLevel, ZerologLevel: tt.zerologLevel})
+			defer configs.Set(configs.Config{})
+
+			level, err := LogLevel()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, level)
+		})
+	}
+}